@@ -0,0 +1,502 @@
+//
+// Abuse report functions for ASCII-log
+//
+// The addresses blocked by the policy can be reported to the abuse contact
+// of their network, as given by whois or RDAP. The addresses are grouped by
+// contact, so that every provider gets a single report, listing the times,
+// reason and sample log lines of each address. The reports are written out
+// as ready to send messages, and can be sent via an SMTP relay as well.
+//
+// Every reported address is noted, one per line, with a tab between the
+// address and the time it was reported, so that it is not reported again
+// until the report interval has passed.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/mail"
+    "net/smtp"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+)
+
+// Layout of the times listed in abuse reports, which includes the offset
+// of the timezone they were logged in.
+const abuseTimeLayout = "2006-01-02 15:04:05 -0700"
+
+//! An address to report, along with the details to report about it
+type abuseIncident struct {
+    block   *blockEntry
+    record  whoisRecord
+    entries []logEntry
+    samples []string
+}
+
+//! Check whether an abuse contact or sender is a bare mail address, which
+//! is safe to put in the headers of a report
+/*
+ * @param     string    mail address; e.g. abuse@example.net
+ *
+ * @return    bool      whether or not it is a proper address
+ */
+func isValidAbuseAddress(address string) bool {
+
+    // control characters, e.g. line breaks meant to add headers, and
+    // display names are not wanted, only the address itself
+    if strings.IndexFunc(address, unicode.IsControl) >= 0 {
+        return false
+    }
+    parsed, err := mail.ParseAddress(address)
+    if err != nil || parsed.Address != address || len(parsed.Name) > 0 {
+        return false
+    }
+
+    at := strings.LastIndex(address, "@")
+    return at > 0 && at < len(address) - 1 &&
+      !strings.ContainsAny(address, " <>\"")
+}
+
+//! Strip the control characters of a value, so that it stays on a single
+//! header line
+/*
+ * @param     string    value; e.g. the network name given by whois
+ *
+ * @return    string    value, without control characters
+ */
+func stripControlCharacters(value string) string {
+    return strings.Map(func(r rune) rune {
+        if unicode.IsControl(r) {
+            return -1
+        }
+        return r
+    }, value)
+}
+
+//! Read the times every address was last reported at
+/*
+ * @param     string    /path/to/file
+ *
+ * @return    map       map of addresses and when they were reported
+ */
+func readAbuseReported(path string) map[string] time.Time {
+
+    // variable declaration
+    var result = make(map[string] time.Time)
+
+    // a missing file simply means nothing has been reported yet
+    byte_contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return result
+    }
+
+    for _, line := range strings.Split(string(byte_contents), "\n") {
+        pieces := strings.SplitN(line, "\t", 2)
+        if len(pieces) < 2 {
+            continue
+        }
+        reported, err := time.Parse(ledgerTimeLayout, pieces[1])
+        if err == nil {
+            result[pieces[0]] = reported
+        }
+    }
+
+    return result
+}
+
+//! Write the times every address was last reported at
+/*
+ * @param     string    /path/to/file
+ * @param     map       map of addresses and when they were reported
+ *
+ * @return    error     error message, if any
+ */
+func writeAbuseReported(path string, reported map[string] time.Time) error {
+
+    // variable declaration
+    var contents string = ""
+    var targets = make([]string, 0, len(reported))
+
+    for target, _ := range reported {
+        targets = append(targets, target)
+    }
+    sort.Strings(targets)
+
+    for _, target := range targets {
+        contents += target + "\t" +
+          reported[target].Format(ledgerTimeLayout) + "\n"
+    }
+
+    return writeLogFile(path, contents)
+}
+
+//! Group the blocked addresses by the abuse contact of their network
+/*
+ * @param     blockEntry[]    blocks made or renewed this run
+ * @param     map             string map containing parsed whois records
+ * @param     map             map of addresses and when they were reported
+ * @param     Time            current time
+ * @param     Duration        how long to wait before reporting an address
+ *                            again
+ *
+ * @return    map             map of abuse contacts and their incidents
+ * @return    int             number of addresses lacking an abuse contact
+ */
+func groupAbuseIncidents(blocks []*blockEntry,
+  whois_record_map map[string] whoisRecord, reported map[string] time.Time,
+  now time.Time, interval time.Duration) (map[string] []*abuseIncident,
+  int) {
+
+    // variable declaration
+    var groups = make(map[string] []*abuseIncident)
+    var uncontacted int = 0
+
+    for _, block := range blocks {
+
+        // only single addresses are reported; subnets span many
+        // customers, so their hosts are reported by themselves
+        if net.ParseIP(block.target) == nil {
+            continue
+        }
+
+        if last, exists := reported[block.target]; exists &&
+          now.Before(last.Add(interval)) {
+            continue
+        }
+
+        // a contact that is no proper address counts as lacking one
+        record := whois_record_map[block.target]
+        if !isValidAbuseAddress(record.abuse_email) {
+            uncontacted++
+            continue
+        }
+
+        groups[record.abuse_email] = append(groups[record.abuse_email],
+          &abuseIncident{block: block, record: record})
+    }
+
+    return groups, uncontacted
+}
+
+//! Gather the log entries and sample log lines of every incident
+/*
+ * @param     map         map of abuse contacts and their incidents
+ * @param     logEntry[]  parsed entries of the latest date
+ * @param     string[]    lines of the access log
+ * @param     string      latest date in the access log
+ * @param     int         most sample log lines per address
+ */
+func gatherAbuseEvidence(groups map[string] []*abuseIncident,
+  log_entries []logEntry, lines []string, latest_date string,
+  max_samples int) {
+
+    // variable declaration
+    var incidents = make(map[string] *abuseIncident)
+
+    for _, group := range groups {
+        for _, incident := range group {
+            incidents[incident.block.target] = incident
+        }
+    }
+
+    for _, entry := range log_entries {
+        if incident, exists := incidents[entry.ip]; exists {
+            incident.entries = append(incident.entries, entry)
+        }
+    }
+
+    for _, line := range lines {
+        ip := strings.Split(line, " ")[0]
+        incident, exists := incidents[ip]
+        if !exists || len(incident.samples) >= max_samples ||
+          !strings.Contains(line, latest_date) {
+            continue
+        }
+        incident.samples = append(incident.samples, line)
+    }
+}
+
+//! Assemble the abuse report sent to a single abuse contact
+/*
+ * @param     string             abuse contact
+ * @param     abuseIncident[]    incidents of the contact's network
+ * @param     string             sender address
+ * @param     string             name of this server
+ * @param     Time               current time
+ *
+ * @return    string             ready to send message, headers included
+ */
+func assembleAbuseReport(contact string, incidents []*abuseIncident,
+  from string, hostname string, now time.Time) string {
+
+    // variable declaration
+    var report string = ""
+    var networks = make([]string, 0)
+
+    sort.Slice(incidents, func(i, j int) bool {
+        return incidents[i].block.target < incidents[j].block.target
+    })
+
+    // the network names come from whois, so they are kept from adding
+    // lines to the headers
+    for _, incident := range incidents {
+        network := stripControlCharacters(incident.record.netname)
+        if len(network) < 1 {
+            network = stripControlCharacters(incident.record.asn)
+        }
+        if len(network) > 0 && !isStringInArray(network, networks) {
+            networks = append(networks, network)
+        }
+    }
+
+    subject := fmt.Sprintf("Abuse report: %d blocked IP address",
+      len(incidents))
+    if len(incidents) != 1 {
+        subject += "es"
+    }
+    if len(networks) > 0 {
+        subject += " of " + strings.Join(networks, ", ")
+    }
+
+    report += "From: " + from + "\n"
+    report += "To: " + contact + "\n"
+    report += "Date: " + now.Format(time.RFC1123Z) + "\n"
+    report += "Subject: " + subject + "\n"
+    report += "Message-ID: <" + strconv.FormatInt(now.UnixNano(), 36) +
+      "." + strings.Replace(contact, "@", ".", -1) + "@" + hostname + ">\n"
+    report += "MIME-Version: 1.0\n"
+    report += "Content-Type: text/plain; charset=utf-8\n"
+    report += "Auto-Submitted: auto-generated\n"
+    report += "\n"
+
+    report += "Hello,\n\n"
+    report += "The addresses below, which whois lists you as the abuse " +
+      "contact of, sent\n"
+    report += "abusive requests to " + hostname + ", and have been " +
+      "blocked there.\n"
+    report += "Times are as logged by the web server, along with their " +
+      "UTC offset;\n"
+    report += "this report was generated at " +
+      now.Format(abuseTimeLayout) + " (" + now.Format("MST") + ").\n"
+
+    for i, incident := range incidents {
+
+        report += "\n"
+        report += fmt.Sprintf("%d) %s\n", i+1, incident.block.target)
+
+        details := make([]string, 0)
+        for _, detail := range []string{incident.record.asn,
+          incident.record.netname, incident.record.cidr,
+          incident.record.country} {
+            if len(detail) > 0 {
+                details = append(details, detail)
+            }
+        }
+        if len(details) > 0 {
+            report += "   Network:  " + strings.Join(details, ", ") + "\n"
+        }
+        report += "   Reason:   " + incident.block.reason + "\n"
+
+        if len(incident.entries) > 0 {
+            first := incident.entries[0].timestamp
+            last := first
+            for _, entry := range incident.entries {
+                if entry.timestamp.Before(first) {
+                    first = entry.timestamp
+                }
+                if entry.timestamp.After(last) {
+                    last = entry.timestamp
+                }
+            }
+            report += fmt.Sprintf("   Requests: %d, from %s to %s\n",
+              len(incident.entries), first.Format(abuseTimeLayout),
+              last.Format(abuseTimeLayout))
+        }
+
+        report += "   Blocked:  " +
+          incident.block.first_blocked.Format(abuseTimeLayout) +
+          ", until " + incident.block.expires.Format(abuseTimeLayout) +
+          "\n"
+
+        if len(incident.samples) > 0 {
+            report += "   Sample log lines:\n"
+            for _, sample := range incident.samples {
+                report += "     " + sample + "\n"
+            }
+        }
+    }
+
+    report += "\n"
+    report += "Please look into this, and stop the abuse if you can.\n\n"
+    report += "Regards,\n"
+    report += "ascii-log on " + hostname + "\n"
+
+    return report
+}
+
+//! Send a report via the SMTP relay
+/*
+ * @param     string    relay address; e.g. localhost:25
+ * @param     string    sender address
+ * @param     string    abuse contact
+ * @param     string    ready to send message
+ *
+ * @return    error     error message, if any
+ */
+func sendAbuseReport(relay string, from string, contact string,
+  report string) error {
+
+    // mail wants every line ended with CRLF
+    message := strings.Replace(report, "\n", "\r\n", -1)
+
+    err := smtp.SendMail(relay, nil, from, []string{contact},
+      []byte(message))
+    if err != nil {
+        return fmt.Errorf("sendAbuseReport() --> unable to send the " +
+          "report for %s via %s: %s", contact, relay, err.Error())
+    }
+    return nil
+}
+
+//! Write, and send if need be, the abuse reports of this run
+/*
+ * @param     blockEntry[]    blocks made or renewed this run
+ * @param     map             string map containing parsed whois records
+ * @param     logEntry[]      parsed entries of the latest date
+ * @param     string[]        lines of the access log
+ * @param     string          latest date in the access log
+ * @param     Time            current time
+ *
+ * @return    error           error message, if any
+ */
+func reportAbuse(blocks []*blockEntry,
+  whois_record_map map[string] whoisRecord, log_entries []logEntry,
+  lines []string, latest_date string, now time.Time) error {
+
+    // variable declaration
+    var written int = 0
+    var sent int = 0
+    var failures = make([]string, 0)
+
+    hostname, err := os.Hostname()
+    hostname = stripControlCharacters(hostname)
+    if err != nil || len(hostname) < 1 {
+        hostname = "localhost"
+    }
+    from := abuseFrom
+    if len(from) < 1 {
+        from = "ascii-log@" + hostname
+    }
+    if !isValidAbuseAddress(from) {
+        return fmt.Errorf("reportAbuse() --> '%s' is not a proper sender " +
+          "address", from)
+    }
+
+    reported_path := state_directory + abuse_reported
+    reported := readAbuseReported(reported_path)
+
+    groups, uncontacted := groupAbuseIncidents(blocks, whois_record_map,
+      reported, now, abuseReportInterval)
+    gatherAbuseEvidence(groups, log_entries, lines, latest_date,
+      abuseSampleLines)
+
+    contacts := make([]string, 0, len(groups))
+    for contact, _ := range groups {
+        contacts = append(contacts, contact)
+    }
+    sort.Strings(contacts)
+
+    // file names are kept to characters that are safe on any filesystem
+    unsafe := regexp.MustCompile("[^A-Za-z0-9@._-]")
+
+    for _, contact := range contacts {
+
+        report := assembleAbuseReport(contact, groups[contact], from,
+          hostname, now)
+        path := strings.TrimSuffix(abuseReportDir, "/") + "/" +
+          now.Format("20060102-150405") + "-" +
+          unsafe.ReplaceAllString(contact, "_") + ".eml"
+
+        // during a dry run, print the reports rather than writing or
+        // sending them
+        if dryRun {
+            fmt.Println("# write " + path)
+            fmt.Print(report)
+            if len(abuseSmtpRelay) > 0 {
+                fmt.Println("# send to " + contact + " via " +
+                  abuseSmtpRelay)
+            }
+            continue
+        }
+
+        // a failure is noted and the remaining contacts are still
+        // reported, so that the reports already sent get recorded
+        err = ioutil.WriteFile(path, []byte(report), 0644)
+        if err != nil {
+            failures = append(failures, "unable to write " + path + ": " +
+              err.Error())
+            continue
+        }
+        written++
+
+        // the reports are only sent when enforcing, and only noted as
+        // reported once sent; without a relay, writing them is the
+        // hand off
+        delivered := len(abuseSmtpRelay) < 1
+        if enforce && len(abuseSmtpRelay) > 0 {
+            err = sendAbuseReport(abuseSmtpRelay, from, contact, report)
+            if err != nil {
+                failures = append(failures, err.Error())
+                continue
+            }
+            sent++
+            delivered = true
+        }
+        if delivered {
+            for _, incident := range groups[contact] {
+                reported[incident.block.target] = now
+            }
+        }
+    }
+
+    if dryRun {
+        return nil
+    }
+
+    // forget the addresses that may be reported again anyway
+    for target, last := range reported {
+        if !now.Before(last.Add(abuseReportInterval)) {
+            delete(reported, target)
+        }
+    }
+    err = writeAbuseReported(reported_path, reported)
+    if err != nil {
+        failures = append(failures, err.Error())
+    }
+
+    if written > 0 || uncontacted > 0 || len(failures) > 0 {
+        fmt.Printf("Abuse reports: %d written to %s, %d sent, %d " +
+          "problems, %d blocked IPs lacking an abuse contact.\n", written,
+          abuseReportDir, sent, len(failures), uncontacted)
+    }
+
+    if len(failures) > 0 {
+        return fmt.Errorf("reportAbuse() --> %s",
+          strings.Join(failures, "; "))
+    }
+    return nil
+}
@@ -0,0 +1,348 @@
+//
+// Abuse report tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "bufio"
+    "net"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+//! A message, as it was handed to the stand-in SMTP server
+type receivedMail struct {
+    from string
+    to   []string
+    data string
+}
+
+//! Stand-in SMTP relay, listening on a local port
+type fakeSmtpServer struct {
+    address  string
+    rejected []string
+    mutex    sync.Mutex
+    messages []receivedMail
+}
+
+//! Start a stand-in SMTP relay, stopped once the test is over
+/*
+ * @param     T*                test state
+ * @param     string[]          recipients that are refused, with a 550
+ *
+ * @return    fakeSmtpServer    server
+ */
+func startFakeSmtpServer(t *testing.T, rejected []string) *fakeSmtpServer {
+
+    t.Helper()
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("unable to listen: %v", err)
+    }
+    t.Cleanup(func() { listener.Close() })
+
+    server := &fakeSmtpServer{address: listener.Addr().String(),
+      rejected: rejected}
+
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            server.serve(conn)
+        }
+    }()
+
+    return server
+}
+
+//! Hold a single SMTP session; just enough of the protocol for net/smtp
+/*
+ * @param     Conn    connection of the client
+ */
+func (s *fakeSmtpServer) serve(conn net.Conn) {
+
+    // variable declaration
+    var mail receivedMail
+
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+    reader := bufio.NewReader(conn)
+    reply := func(line string) {
+        conn.Write([]byte(line + "\r\n"))
+    }
+
+    reply("220 fake.example ESMTP")
+    for {
+        line, err := reader.ReadString('\n')
+        if err != nil {
+            return
+        }
+        line = strings.TrimRight(line, "\r\n")
+        command := strings.ToUpper(line)
+
+        switch {
+        case strings.HasPrefix(command, "EHLO"),
+          strings.HasPrefix(command, "HELO"):
+            reply("250 fake.example")
+        case strings.HasPrefix(command, "MAIL FROM:"):
+            mail = receivedMail{from: strings.Trim(line[10:], "<> ")}
+            reply("250 OK")
+        case strings.HasPrefix(command, "RCPT TO:"):
+            to := strings.Trim(line[8:], "<> ")
+            if isStringInArray(to, s.rejected) {
+                reply("550 no such mailbox")
+                continue
+            }
+            mail.to = append(mail.to, to)
+            reply("250 OK")
+        case command == "DATA":
+            reply("354 go ahead")
+            for {
+                data, err := reader.ReadString('\n')
+                if err != nil {
+                    return
+                }
+                if data == ".\r\n" {
+                    break
+                }
+                mail.data += data
+            }
+            s.mutex.Lock()
+            s.messages = append(s.messages, mail)
+            s.mutex.Unlock()
+            reply("250 queued")
+        case command == "QUIT":
+            reply("221 bye")
+            return
+        default:
+            reply("502 not implemented")
+        }
+    }
+}
+
+//! Obtain the messages received so far
+/*
+ * @return    receivedMail[]    messages, in order
+ */
+func (s *fakeSmtpServer) received() []receivedMail {
+
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    return append([]receivedMail{}, s.messages...)
+}
+
+func TestIsValidAbuseAddress(t *testing.T) {
+
+    tests := []struct {
+        address string
+        want    bool
+    }{
+        {"abuse@example.net", true},
+        {"abuse+ripe@noc.example.net", true},
+        {"", false},
+        {"abuse", false},
+        {"@example.net", false},
+        {"abuse@", false},
+        {"abuse@example.net\r\nBcc: victim@example.org", false},
+        {"abuse@example.net\nSubject: spam", false},
+        {"abuse@example.net\x00", false},
+        {"Abuse Desk <abuse@example.net>", false},
+        {"abuse@example.net, victim@example.org", false},
+        {"\"abuse desk\"@example.net", false},
+    }
+
+    for _, test := range tests {
+        got := isValidAbuseAddress(test.address)
+        if got != test.want {
+            t.Errorf("isValidAbuseAddress(%q) = %v, want %v", test.address,
+              got, test.want)
+        }
+    }
+}
+
+func TestGroupAbuseIncidents(t *testing.T) {
+
+    now := time.Now()
+    blocks := []*blockEntry{
+        {target: "192.0.2.1"},
+        {target: "192.0.2.2"},
+        {target: "198.51.100.1"},
+        {target: "203.0.113.1"},
+        {target: "203.0.113.0/24"},
+    }
+    records := map[string] whoisRecord{
+        "192.0.2.1":    {abuse_email: "abuse@a.example"},
+        "192.0.2.2":    {abuse_email: "abuse@a.example"},
+        "198.51.100.1": {abuse_email: "abuse@b.example\r\n" +
+          "Bcc: victim@example.org"},
+        "203.0.113.0/24": {abuse_email: "abuse@c.example"},
+    }
+
+    // the injected contact and the one lacking a contact are left out,
+    // as is the subnet
+    groups, uncontacted := groupAbuseIncidents(blocks, records,
+      map[string] time.Time{"192.0.2.2": now.Add(-time.Hour)}, now,
+      24 * time.Hour)
+    if uncontacted != 2 {
+        t.Errorf("%d addresses lacking a contact, want 2", uncontacted)
+    }
+    if len(groups) != 1 || len(groups["abuse@a.example"]) != 1 ||
+      groups["abuse@a.example"][0].block.target != "192.0.2.1" {
+        t.Errorf("groups = %v, want 192.0.2.1 for abuse@a.example", groups)
+    }
+}
+
+func TestAssembleAbuseReportHeaders(t *testing.T) {
+
+    now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+    incidents := []*abuseIncident{{block: &blockEntry{target: "192.0.2.1",
+      reason: "busy", first_blocked: now, expires: now.Add(time.Hour)},
+      record: whoisRecord{netname: "EVIL-NET\r\nBcc: victim@example.org",
+      abuse_email: "abuse@example.net"}}}
+
+    report := assembleAbuseReport("abuse@example.net", incidents,
+      "ascii-log@web.example", "web.example", now)
+    headers := strings.SplitN(report, "\n\n", 2)[0]
+
+    want := []string{"From: ascii-log@web.example",
+      "To: abuse@example.net",
+      "Date: Fri, 02 Jan 2026 03:04:05 +0000",
+      "Subject: Abuse report: 1 blocked IP address of " +
+        "EVIL-NETBcc: victim@example.org"}
+    lines := strings.Split(headers, "\n")
+    if len(lines) != 8 {
+        t.Errorf("%d header lines, want 8:\n%s", len(lines), headers)
+    }
+    for i, line := range want {
+        if i >= len(lines) || lines[i] != line {
+            t.Errorf("header %d = %q, want %q", i, lines[i], line)
+        }
+    }
+}
+
+func TestSendAbuseReport(t *testing.T) {
+
+    server := startFakeSmtpServer(t, []string{"abuse@refused.example"})
+    report := "Subject: Abuse from 192.0.2.1\n\nLine one\nLine two\n"
+
+    err := sendAbuseReport(server.address, "ascii-log@web.example",
+      "abuse@net.example", report)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    messages := server.received()
+    if len(messages) != 1 {
+        t.Fatalf("%d messages received, want 1", len(messages))
+    }
+    if messages[0].from != "ascii-log@web.example" ||
+      strings.Join(messages[0].to, ",") != "abuse@net.example" {
+        t.Errorf("envelope = %s -> %v", messages[0].from, messages[0].to)
+    }
+    want := "Subject: Abuse from 192.0.2.1\r\n\r\nLine one\r\nLine two\r\n"
+    if messages[0].data != want {
+        t.Errorf("data = %q, want %q", messages[0].data, want)
+    }
+
+    // a refused recipient, or an unreachable relay, is an error
+    err = sendAbuseReport(server.address, "ascii-log@web.example",
+      "abuse@refused.example", report)
+    if err == nil {
+        t.Errorf("expected the refused recipient to be an error")
+    }
+    err = sendAbuseReport(obtainClosedAddress(t), "ascii-log@web.example",
+      "abuse@net.example", report)
+    if err == nil {
+        t.Errorf("expected the unreachable relay to be an error")
+    }
+}
+
+func TestReportAbuseRecordsSentReports(t *testing.T) {
+
+    // the settings of a real run, put back once done
+    saved_state, saved_dir, saved_relay := state_directory, abuseReportDir,
+      abuseSmtpRelay
+    saved_enforce, saved_dry_run, saved_from := enforce, dryRun, abuseFrom
+    defer func() {
+        state_directory, abuseReportDir = saved_state, saved_dir
+        abuseSmtpRelay, enforce = saved_relay, saved_enforce
+        dryRun, abuseFrom = saved_dry_run, saved_from
+    }()
+
+    // the contact sorted first is refused, the other must still be sent
+    server := startFakeSmtpServer(t, []string{"abuse@a-refused.example"})
+    state_directory = t.TempDir() + "/"
+    abuseReportDir = t.TempDir()
+    abuseSmtpRelay = server.address
+    abuseFrom = "ascii-log@web.example"
+    enforce, dryRun = true, false
+
+    now := time.Now()
+    blocks := []*blockEntry{
+        {target: "192.0.2.1", reason: "busy", expires: now.Add(time.Hour)},
+        {target: "198.51.100.1", reason: "busy",
+          expires: now.Add(time.Hour)},
+        {target: "203.0.113.0/24", reason: "subnet",
+          expires: now.Add(time.Hour)},
+    }
+    records := map[string] whoisRecord{
+        "192.0.2.1":    {abuse_email: "abuse@a-refused.example"},
+        "198.51.100.1": {abuse_email: "abuse@b-net.example"},
+    }
+
+    err := reportAbuse(blocks, records, nil, nil, "", now)
+    if err == nil || !strings.Contains(err.Error(),
+      "abuse@a-refused.example") {
+        t.Errorf("expected the refused report to be an error, got %v", err)
+    }
+
+    messages := server.received()
+    if len(messages) != 1 || messages[0].to[0] != "abuse@b-net.example" {
+        t.Fatalf("messages = %+v, want one to abuse@b-net.example",
+          messages)
+    }
+
+    // only the report that was sent is recorded, so that the next run
+    // retries the refused one without mailing the other again
+    reported := readAbuseReported(filepath.Join(state_directory,
+      abuse_reported))
+    if _, exists := reported["198.51.100.1"]; !exists {
+        t.Errorf("sent report not recorded: %v", reported)
+    }
+    if _, exists := reported["192.0.2.1"]; exists {
+        t.Errorf("refused report recorded: %v", reported)
+    }
+
+    err = reportAbuse(blocks, records, nil, nil, "", now.Add(time.Minute))
+    if err == nil {
+        t.Errorf("expected the refused report to be an error again")
+    }
+    if len(server.received()) != 1 {
+        t.Errorf("reported contact mailed again: %+v", server.received())
+    }
+
+    // a sender that is no proper address is refused before anything is
+    // built or sent
+    abuseFrom = "ascii-log@web.example\r\nBcc: victim@example.org"
+    err = reportAbuse(blocks, records, nil, nil, "", now.Add(time.Hour))
+    if err == nil || !strings.Contains(err.Error(), "sender") {
+        t.Errorf("improper sender = %v, want an error", err)
+    }
+    if len(server.received()) != 1 {
+        t.Errorf("mailed with an improper sender: %+v", server.received())
+    }
+}
@@ -0,0 +1,250 @@
+//
+// Allowlist functions for ASCII-log
+//
+// An allowlist holds the addresses that must never be blocked, one entry per
+// line, in any of the following forms:
+//
+// 192.0.2.10           single IPv4 or IPv6 address
+// 198.51.100.0/24      IPv4 or IPv6 network
+// AS64500              every address announced by an AS
+// .monitoring.example  reverse DNS suffix, confirmed by a forward lookup
+//
+// Allowlisted addresses override every block rule, but are still counted.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "strconv"
+    "strings"
+)
+
+//! Addresses, networks, AS numbers and hostnames that are never blocked
+type allowlist struct {
+    networks []*net.IPNet
+    asns     []string
+    suffixes []string
+}
+
+//! Parse the contents of an allowlist
+/*
+ * @param     string       allowlist contents
+ *
+ * @return    allowlist    parsed allowlist
+ * @return    error        every problem found, one per line
+ */
+func parseAllowlist(contents string) (*allowlist, error) {
+
+    // variable declaration
+    var result = &allowlist{}
+    var problems = make([]string, 0)
+
+    for i, line := range strings.Split(contents, "\n") {
+
+        // strip away comments and blank lines
+        if hash := strings.Index(line, "#"); hash >= 0 {
+            line = line[:hash]
+        }
+        value := strings.TrimSpace(line)
+        if len(value) < 1 {
+            continue
+        }
+
+        // single addresses are stored as networks of a single address
+        if parsed := net.ParseIP(value); parsed != nil {
+            if parsed.To4() != nil {
+                value += "/32"
+            } else {
+                value += "/128"
+            }
+        }
+
+        // networks
+        if strings.Contains(value, "/") {
+            _, network, err := net.ParseCIDR(value)
+            if err != nil {
+                problems = append(problems, fmt.Sprintf("line %d: '%s' " +
+                  "is not a CIDR", i+1, value))
+                continue
+            }
+            result.networks = append(result.networks, network)
+            continue
+        }
+
+        // AS numbers
+        upper := strings.ToUpper(value)
+        if strings.HasPrefix(upper, "AS") {
+            if _, err := strconv.ParseUint(upper[2:], 10, 32); err != nil {
+                problems = append(problems, fmt.Sprintf("line %d: '%s' " +
+                  "is not an AS number", i+1, value))
+                continue
+            }
+            result.asns = append(result.asns, upper)
+            continue
+        }
+
+        // otherwise it ought to be a reverse DNS suffix; no top level
+        // domain is all digits, so that is an improper address instead
+        suffix := strings.ToLower(strings.Trim(value, "."))
+        top := suffix[strings.LastIndex(suffix, ".")+1:]
+        if _, err := strconv.Atoi(top); err == nil ||
+          strings.ContainsAny(suffix, " /:") || !strings.Contains(suffix,
+          ".") {
+            problems = append(problems, fmt.Sprintf("line %d: '%s' is " +
+              "not an address, CIDR, AS number or domain", i+1, value))
+            continue
+        }
+        result.suffixes = append(result.suffixes, suffix)
+    }
+
+    if len(problems) > 0 {
+        return nil, fmt.Errorf("parseAllowlist() --> %s",
+          strings.Join(problems, "; "))
+    }
+
+    return result, nil
+}
+
+//! Read and parse an allowlist file, or an empty one if none is given
+/*
+ * @param     string       /path/to/file, or blank
+ *
+ * @return    allowlist    parsed allowlist
+ * @return    error        error message, if any
+ */
+func readAllowlistFile(path string) (*allowlist, error) {
+
+    // if no file is given, nothing is allowlisted
+    if len(path) < 1 {
+        return &allowlist{}, nil
+    }
+
+    byte_contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("readAllowlistFile() --> unable to read " +
+          "the following file: %s", path)
+    }
+
+    return parseAllowlist(string(byte_contents))
+}
+
+//! Obtain the network or AS number entry that matches an IP address, if
+//! any; the reverse DNS suffixes are left to obtainAllowlistMatches
+/*
+ * @param     string    IP address
+ * @param     string    origin AS number of the address, if known
+ *
+ * @return    string    matching entry, or blank if none
+ */
+func (a *allowlist) obtainMatch(ip string, asn string) string {
+
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return ""
+    }
+
+    for _, network := range a.networks {
+        if network.Contains(parsed) {
+            return network.String()
+        }
+    }
+
+    if len(asn) > 0 && isStringInArray(strings.ToUpper(asn), a.asns) {
+        return strings.ToUpper(asn)
+    }
+
+    return ""
+}
+
+//! Check whether blocking an address or CIDR would block anything that is
+//! allowlisted
+/*
+ * @param     string    IP address or CIDR
+ * @param     map       map of allowlisted ip addresses seen this run
+ *
+ * @return    bool      whether or not the target covers an allowlisted
+ *                      address or network
+ */
+func (a *allowlist) coversTarget(target string,
+  allowlisted map[string] string) bool {
+
+    // treat single addresses as networks of a single address
+    target = normalizeFirewallTarget(target)
+    if !strings.Contains(target, "/") {
+        if strings.Contains(target, ":") {
+            target += "/128"
+        } else {
+            target += "/32"
+        }
+    }
+    _, own, err := net.ParseCIDR(target)
+    if err != nil {
+        return false
+    }
+
+    // overlapping networks always contain the base of the narrower one
+    for _, network := range a.networks {
+        if own.Contains(network.IP) || network.Contains(own.IP) {
+            return true
+        }
+    }
+
+    // addresses allowlisted by AS number or hostname
+    for ip, _ := range allowlisted {
+        if own.Contains(net.ParseIP(ip)) {
+            return true
+        }
+    }
+
+    return false
+}
+
+//! Obtain the allowlisted addresses among the given ones
+/*
+ * @param     Context      context of the reverse DNS lookups
+ * @param     allowlist    parsed allowlist
+ * @param     string[]     array of ip addresses
+ * @param     map          map of ip addresses and their whois records
+ *
+ * @return    map          map of allowlisted ip addresses and the entries
+ *                         they matched
+ */
+func obtainAllowlistMatches(ctx context.Context, a *allowlist, ips []string,
+  records map[string] whoisRecord) map[string] string {
+
+    // variable declaration
+    var result = make(map[string] string)
+    var unmatched = make([]string, 0)
+    var suffixes = make([][]string, 0)
+
+    for _, ip := range ips {
+        if match := a.obtainMatch(ip, records[ip].asn); len(match) > 0 {
+            result[ip] = match
+        } else if net.ParseIP(ip) != nil && len(a.suffixes) > 0 {
+            unmatched = append(unmatched, ip)
+            suffixes = append(suffixes, a.suffixes)
+        }
+    }
+
+    // the reverse lookups are done last, since they are the slowest, by
+    // the pool of workers; if they fail, the address is simply not
+    // allowlisted this run
+    hostnames, _ := obtainForwardConfirmations(ctx, unmatched, suffixes)
+    for i, ip := range unmatched {
+        if len(hostnames[i]) > 0 {
+            result[ip] = hostnames[i]
+        }
+    }
+
+    return result
+}
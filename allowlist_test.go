@@ -0,0 +1,180 @@
+//
+// Allowlist tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "strings"
+    "testing"
+)
+
+// Allowlist of every form an entry can take.
+const allowlistTestContents = "# monitoring and office\n" +
+  "192.0.2.10\n" +
+  "  198.51.100.0/28   # office\n" +
+  "2001:db8::5\n" +
+  "2001:db8:1::/48\n" +
+  "as64500\n" +
+  ".Monitoring.Example.\n" +
+  "\n"
+
+func TestParseAllowlist(t *testing.T) {
+
+    allowed, err := parseAllowlist(allowlistTestContents)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    networks := make([]string, 0)
+    for _, network := range allowed.networks {
+        networks = append(networks, network.String())
+    }
+    if strings.Join(networks, " ") != "192.0.2.10/32 198.51.100.0/28 " +
+      "2001:db8::5/128 2001:db8:1::/48" {
+        t.Errorf("networks = %q", networks)
+    }
+    if len(allowed.asns) != 1 || allowed.asns[0] != "AS64500" {
+        t.Errorf("asns = %q", allowed.asns)
+    }
+    if len(allowed.suffixes) != 1 ||
+      allowed.suffixes[0] != "monitoring.example" {
+        t.Errorf("suffixes = %q", allowed.suffixes)
+    }
+
+    // an empty file allowlists nothing
+    allowed, err = parseAllowlist("")
+    if err != nil || len(allowed.networks) + len(allowed.asns) +
+      len(allowed.suffixes) > 0 {
+        t.Errorf("empty allowlist = %+v, %v", allowed, err)
+    }
+}
+
+func TestParseAllowlistMalformed(t *testing.T) {
+
+    tests := []struct {
+        line    string
+        problem string
+    }{
+        {"192.0.2.300", "is not an address, CIDR"},
+        {"192.0.2.0/33", "is not a CIDR"},
+        {"2001:db8::/129", "is not a CIDR"},
+        {"2001:db8:::1", "is not an address, CIDR"},
+        {"ASN64500", "is not an AS number"},
+        {"AS99999999999", "is not an AS number"},
+        {"localhost", "is not an address, CIDR"},
+        {"monitoring example", "is not an address, CIDR"},
+    }
+
+    for _, test := range tests {
+        _, err := parseAllowlist("192.0.2.10\n" + test.line + "\n")
+        if err == nil || !strings.Contains(err.Error(), "line 2: '" +
+          test.line + "' " + test.problem) {
+            t.Errorf("%q: error = %v, want line 2 %s", test.line, err,
+              test.problem)
+        }
+    }
+
+    // every problem is named, not just the first one
+    _, err := parseAllowlist("192.0.2.0/33\nASfoo\n")
+    if err == nil || !strings.Contains(err.Error(), "line 1:") ||
+      !strings.Contains(err.Error(), "line 2:") {
+        t.Errorf("error = %v, want both lines named", err)
+    }
+}
+
+func TestAllowlistObtainMatch(t *testing.T) {
+
+    allowed, err := parseAllowlist(allowlistTestContents)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    tests := []struct {
+        ip   string
+        asn  string
+        want string
+    }{
+        {"192.0.2.10", "", "192.0.2.10/32"},
+        {"192.0.2.11", "", ""},
+        {"198.51.100.15", "", "198.51.100.0/28"},
+        {"198.51.100.16", "", ""},
+        {"::ffff:192.0.2.10", "", "192.0.2.10/32"},
+        {"2001:db8::5", "", "2001:db8::5/128"},
+        {"2001:DB8:0::5", "", "2001:db8::5/128"},
+        {"2001:db8::6", "", ""},
+        {"2001:db8:1:ffff::1", "", "2001:db8:1::/48"},
+        {"2001:db8:2::1", "", ""},
+        {"203.0.113.1", "as64500", "AS64500"},
+        {"203.0.113.1", "AS64501", ""},
+        {"not an address", "AS64500", ""},
+        {"", "", ""},
+    }
+
+    for _, test := range tests {
+        match := allowed.obtainMatch(test.ip, test.asn)
+        if match != test.want {
+            t.Errorf("%q, %q matched %q, want %q", test.ip, test.asn,
+              match, test.want)
+        }
+    }
+
+    // reverse DNS suffixes are only matched after a lookup
+    matches := obtainAllowlistMatches(context.Background(),
+      &allowlist{networks: allowed.networks, asns: allowed.asns},
+      []string{"192.0.2.10", "192.0.2.11", "203.0.113.1"},
+      map[string] whoisRecord{"203.0.113.1": {asn: "AS64500"}})
+    if len(matches) != 2 || matches["192.0.2.10"] != "192.0.2.10/32" ||
+      matches["203.0.113.1"] != "AS64500" {
+        t.Errorf("matches = %v", matches)
+    }
+}
+
+func TestAllowlistCoversTarget(t *testing.T) {
+
+    allowed, err := parseAllowlist(allowlistTestContents)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    // an address allowlisted this run by AS number or hostname
+    seen := map[string] string{"203.0.113.77": "AS64500"}
+
+    tests := []struct {
+        target string
+        want   bool
+    }{
+        {"192.0.2.10", true},
+        {"192.0.2.10/32", true},
+        {"192.0.2.11", false},
+        {"192.0.2.0/24", true},
+        {"192.0.2.0/29", false},
+        {"192.0.2.8/30", true},
+        {"198.51.100.0/24", true},
+        {"198.51.100.4/30", true},
+        {"198.51.100.16/28", false},
+        {"2001:db8::/64", true},
+        {"2001:db8::6", false},
+        {"2001:db8:1:2::/64", true},
+        {"2001:db8:2::/48", false},
+        {"203.0.113.0/24", true},
+        {"203.0.113.77", true},
+        {"203.0.113.78", false},
+        {"not a target", false},
+    }
+
+    for _, test := range tests {
+        covers := allowed.coversTarget(test.target, seen)
+        if covers != test.want {
+            t.Errorf("%s covers = %v, want %v", test.target, covers,
+              test.want)
+        }
+    }
+}
@@ -0,0 +1,95 @@
+//
+// Apache output functions for ASCII-log
+//
+// Apache 2.4 blocks addresses with authorization directives, which can go
+// in an included config file or in an .htaccess file alike:
+//
+// <RequireAll>
+//     Require all granted
+//     Require not ip 192.0.2.1
+//     Require not ip 198.51.100.0/24
+// </RequireAll>
+//
+// As AuthMerging defaults to Off, "Require all granted" would replace any
+// authorization already in force for the directory, e.g. a password or
+// "Require all denied", opening it up. An .htaccess file therefore starts
+// with "AuthMerging And", so that both have to pass. AuthMerging is not
+// allowed at the server or virtual host level, so an included file leaves
+// it out, and must only be included there, never within a <Directory>,
+// <Location> or similar section.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "strings"
+)
+
+//! Assemble the Apache 2.4 directives that block the given addresses
+/*
+ * @param     string[]    blocked IP addresses and CIDRs
+ * @param     bool        whether to merge with the authorization already
+ *                        in force, as an .htaccess file has to
+ *
+ * @return    string      <RequireAll> block
+ */
+func assembleApacheBlockConfig(targets []string, merge bool) string {
+
+    // variable declaration
+    var config string = ""
+
+    if merge {
+        config += "AuthMerging And\n"
+    }
+    config += "<RequireAll>\n"
+    config += "    Require all granted\n"
+    for _, target := range targets {
+        config += "    Require not ip " + target + "\n"
+    }
+    config += "</RequireAll>\n"
+
+    return config
+}
+
+//! Check whether an Apache block file is an .htaccess file
+/*
+ * @param     string    /path/to/include.conf or .htaccess
+ *
+ * @return    bool      whether or not the file is an .htaccess file
+ */
+func isHtaccessFile(path string) bool {
+    return strings.HasSuffix(path, ".htaccess")
+}
+
+//! Assemble the managed Apache block file
+/*
+ * @param     commandRunner        runs apachectl
+ * @param     string               /path/to/include.conf or .htaccess
+ * @param     string               apachectl binary
+ * @param     bool                 whether to print the file, not write it
+ * @param     string               /path/to/history, to record changes in
+ *
+ * @return    managedConfigFile    managed file
+ */
+func newApacheBlockFile(runner commandRunner, path string,
+  apachectl string, dry_run bool,
+  history_path string) *managedConfigFile {
+
+    // .htaccess files are read on every request, so there is no need to
+    // reload, but the main config is still checked in case it includes
+    // the file
+    reload := []string{apachectl, "graceful"}
+    if isHtaccessFile(path) {
+        reload = nil
+    }
+
+    return &managedConfigFile{runner: runner, path: path, dry_run: dry_run,
+      test_command: []string{apachectl, "configtest"},
+      reload_command: reload, history_path: history_path}
+}
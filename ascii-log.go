@@ -25,6 +25,8 @@ import (
     "io/ioutil"
     "os"
     "regexp"
+    "sort"
+    "strconv"
     "strings"
     "time"
 )
@@ -44,6 +46,27 @@ var (
     // Web location
     web_location = "/var/www/html/data/"
 
+    // Location of the state kept between runs
+    state_directory = "/var/lib/ascii-log/"
+
+    // Name of the file holding the country counts of past periods
+    countries_history = "countries.history"
+
+    // Name of the file holding the blocked addresses and their expiry
+    blocks_ledger = "blocks.ledger"
+
+    // Name of the file holding the changes made to web server configs
+    config_history = "config.history"
+
+    // Name of the file holding the block set of the previous run
+    blocked_set = "blocked.set"
+
+    // Name of the file holding the whois records of earlier runs
+    whois_cache = "whois.cache"
+
+    // Name of the file holding the addresses reported to abuse contacts
+    abuse_reported = "abuse.reported"
+
     // Name of the IP log file on the webserver.
     ip_log = "ip.log"
 
@@ -56,6 +79,33 @@ var (
     // Name of the blocked log file on the webserver.
     blocked_log = "blocked.log"
 
+    // Name of the sessions log file on the webserver.
+    sessions_log = "sessions.log"
+
+    // Name of the latency log file on the webserver.
+    latency_log = "latency.log"
+
+    // Name of the subnets log file on the webserver.
+    subnets_log = "subnets.log"
+
+    // Name of the networks log file on the webserver.
+    networks_log = "networks.log"
+
+    // Name of the countries log file on the webserver.
+    countries_log = "countries.log"
+
+    // Name of the methods log file on the webserver.
+    methods_log = "methods.log"
+
+    // Name of the crawlers log file on the webserver.
+    crawlers_log = "crawlers.log"
+
+    // Name of the policy log file on the webserver.
+    policy_log = "policy.log"
+
+    // Name of the blocked diff log file on the webserver.
+    blocked_diff_log = "blocked-diff.log"
+
     // Parameter for the server type
     serverType = ""
 
@@ -67,6 +117,189 @@ var (
 
     // Argument for enabling daemon mode
     daemonMode = false
+
+    // Inactivity gap after which a visitor session is considered over
+    sessionGap = 30 * time.Minute
+
+    // Sessions lasting at least this long are listed as unusually long
+    longSession = time.Hour
+
+    // Argument for enabling the fixed memory sketch mode
+    sketchMode = false
+
+    // Number of heavy hitters tracked while in sketch mode
+    sketchTopK = 100
+
+    // Wider IPv4 and IPv6 prefixes listed in the subnets log
+    subnetPrefixes4 = "16"
+    subnetPrefixes6 = "48"
+
+    // Parsed forms of the above prefix lists
+    subnetPrefixList4 = []int{}
+    subnetPrefixList6 = []int{}
+
+    // Thresholds of hosts and requests at which a /24 or /64 is blocked
+    subnetBlockHosts    = 5
+    subnetBlockRequests = 25
+
+    // Search engine crawlers, and the domains of their reverse DNS
+    crawlers = "Googlebot=googlebot.com|google.com," +
+      "bingbot=search.msn.com,Applebot=applebot.apple.com," +
+      "YandexBot=yandex.ru|yandex.net|yandex.com," +
+      "Baiduspider=baidu.com|baidu.jp"
+
+    // Parsed form of the above crawler list
+    crawlerRules = []crawlerRule{}
+
+    // Location of the blocking policy file, if any
+    policyFile = ""
+
+    // Parsed form of the blocking policy
+    policyRules = []policyRule{}
+
+    // Location of the allowlist file, if any
+    allowlistFile = ""
+
+    // Parsed form of the allowlist
+    allowlistEntries = &allowlist{}
+
+    // Firewall backend to apply the blocked addresses to, if any
+    firewall = ""
+
+    // Location of the nft binary
+    nftBinary = "nft"
+
+    // Locations of the ipset, iptables and ip6tables binaries
+    ipsetBinary     = "ipset"
+    iptablesBinary  = "iptables"
+    ip6tablesBinary = "ip6tables"
+
+    // Argument for computing and reporting the blocks, while printing the
+    // changes rather than applying or saving them
+    dryRun = false
+
+    // Argument for applying the blocks to the configured backends
+    enforce = false
+
+    // Location of the Apache include or .htaccess file to block IPs in
+    apacheBlockFile = ""
+
+    // Location of the apachectl binary
+    apachectlBinary = "apachectl"
+
+    // Location of the nginx include file to block IPs in, and its format
+    nginxBlockFile   = ""
+    nginxBlockFormat = nginxFormatDeny
+
+    // Location of the nginx binary
+    nginxBinary = "nginx"
+
+    // Location of the fail2ban event log, if any
+    fail2banLog = ""
+
+    // Name of the fail2ban jail, and filter, fed by the event log
+    fail2banJail = "ascii-log"
+
+    // Location of the fail2ban config directory to generate the filter.d
+    // and jail.d files in, if any
+    fail2banConfigDir = ""
+
+    // Argument for banning the blocked IPs via fail2ban-client right away
+    fail2banBan = false
+
+    // Location of the fail2ban-client binary
+    fail2banClient = "fail2ban-client"
+
+    // Length of time a blocked address stays in the firewall
+    blockTTL = 48 * time.Hour
+
+    // Factor the block length is multiplied by for every repeat offence
+    blockTTLFactor = 2.0
+
+    // Longest length of time an address can be blocked for
+    blockMaxTTL = 30 * 24 * time.Hour
+
+    // Length of time an expired block is remembered, to spot repeat
+    // offenders
+    blockMemory = 30 * 24 * time.Hour
+
+    // Number of blocked hosts at which their whole /24 or /64 is blocked
+    blockWidenHosts = 0
+
+    // Length of time whois records are cached for, or 0 to not cache them
+    whoisCacheTTL = 7 * 24 * time.Hour
+
+    // Length of time failed whois lookups are cached for
+    whoisNegativeTTL = time.Hour
+
+    // Whois records of earlier runs, if cached
+    whoisCacheEntries *whoisCache = nil
+
+    // Whois server lookups start at; e.g. whois.iana.org or whois.ripe.net
+    whoisServer = "whois.iana.org"
+
+    // Length of time a whois server has to answer
+    whoisTimeout = 10 * time.Second
+
+    // Most referrals a whois lookup follows
+    whoisMaxReferrals = 3
+
+    // Most lookups in flight per whois server
+    whoisConcurrency = 2
+
+    // Client that whois lookups are made with
+    whoisLookupClient *whoisClient = nil
+
+    // Comma separated list of RDAP bootstrap files, if any
+    rdapBootstrapFiles = ""
+
+    // RDAP server of addresses outside of the bootstrap files, if any
+    rdapServer = ""
+
+    // Length of time an RDAP server has to answer
+    rdapTimeout = 10 * time.Second
+
+    // Client that RDAP lookups are made with, if RDAP is configured
+    rdapLookupClient *rdapClient = nil
+
+    // Number of whois / RDAP lookups made at once, and the most made per
+    // second, or 0 for no limit
+    whoisWorkers = 4
+    whoisRate    = 2.0
+
+    // Number of reverse DNS lookups made at once, and the most made per
+    // second, or 0 for no limit
+    rdnsWorkers = 16
+    rdnsRate    = 0.0
+
+    // Length of time a single lookup may take, or 0 for no limit
+    lookupTimeout = 30 * time.Second
+
+    // Length of time the lookups of a run may take, or 0 for no limit
+    lookupDeadline = time.Duration(0)
+
+    // Locations of the MaxMind DB country and ASN files, if any
+    geoipCountryFile = ""
+    geoipAsnFile     = ""
+
+    // Readers of the above files, if configured
+    geoipCountryDb *mmdbReader = nil
+    geoipAsnDb     *mmdbReader = nil
+
+    // Directory abuse reports are written to, or blank to not report
+    abuseReportDir = ""
+
+    // Sender of the abuse reports; blank means ascii-log@hostname
+    abuseFrom = ""
+
+    // SMTP relay the abuse reports are sent via, if any; e.g. localhost:25
+    abuseSmtpRelay = ""
+
+    // Most sample log lines listed per reported address
+    abuseSampleLines = 5
+
+    // Length of time before an address is reported again
+    abuseReportInterval = 7 * 24 * time.Hour
 )
 
 // Initialize the argument input flags.
@@ -79,6 +312,144 @@ func init() {
     // Daemon mode flag
     flag.BoolVar(&daemonMode, "daemon-mode", false,
       "Whether or not to run this program as a background service.")
+
+    // Session inactivity gap flag
+    flag.DurationVar(&sessionGap, "session-gap", 30 * time.Minute,
+      "Inactivity gap after which a visitor session ends; e.g. '30m' ")
+
+    // Long session threshold flag
+    flag.DurationVar(&longSession, "long-session", time.Hour,
+      "Sessions at least this long are listed in the sessions log.")
+
+    // Sketch mode flag
+    flag.BoolVar(&sketchMode, "sketch-mode", false,
+      "Count IPs with fixed memory sketches; only the top IPs are listed.")
+
+    // Sketch top-K flag
+    flag.IntVar(&sketchTopK, "sketch-top-k", 100,
+      "Number of heaviest hitting IPs tracked while in sketch mode.")
+
+    // Subnet prefix flags
+    flag.StringVar(&subnetPrefixes4, "subnet-prefixes", "16",
+      "Wider IPv4 prefixes to list in the subnets log; e.g. '16,20' ")
+    flag.StringVar(&subnetPrefixes6, "subnet-prefixes6", "48",
+      "Wider IPv6 prefixes to list in the subnets log; e.g. '32,48' ")
+
+    // Subnet blocking threshold flags
+    flag.IntVar(&subnetBlockHosts, "subnet-block-hosts", 5,
+      "Block a /24 or /64 once this many of its hosts are seen; 0 = never")
+    flag.IntVar(&subnetBlockRequests, "subnet-block-requests", 25,
+      "Block a /24 or /64 only if it sent at least this many requests.")
+
+    // Crawler verification flag
+    flag.StringVar(&crawlers, "crawlers", crawlers,
+      "Crawlers to verify; e.g. 'Googlebot=googlebot.com|google.com' ")
+
+    // Policy file flag
+    flag.StringVar(&policyFile, "policy-file", "",
+      "File of ordered allow / block / flag rules; default is built-in")
+
+    // Allowlist file flag
+    flag.StringVar(&allowlistFile, "allowlist-file", "",
+      "File of IPs, CIDRs, ASNs and rDNS suffixes that are never blocked.")
+
+    // Firewall flags
+    flag.StringVar(&firewall, "firewall", "",
+      "Firewall to apply blocked IPs to; 'nftables' or 'ipset' ")
+    flag.StringVar(&nftBinary, "nft-binary", "nft",
+      "Location of the nft binary.")
+    flag.StringVar(&ipsetBinary, "ipset-binary", "ipset",
+      "Location of the ipset binary.")
+    flag.StringVar(&iptablesBinary, "iptables-binary", "iptables",
+      "Location of the iptables binary.")
+    flag.StringVar(&ip6tablesBinary, "ip6tables-binary", "ip6tables",
+      "Location of the ip6tables binary.")
+    flag.BoolVar(&dryRun, "dry-run", false,
+      "Compute and report only; print the changes rather than making them.")
+    flag.BoolVar(&enforce, "enforce", false,
+      "Apply the blocked IPs to the configured firewall / server / fail2ban.")
+    flag.DurationVar(&blockTTL, "block-ttl", 48 * time.Hour,
+      "Length of time blocked IPs stay in the firewall; e.g. '48h' ")
+
+    // Apache output flags
+    flag.StringVar(&apacheBlockFile, "apache-block-file", "",
+      "Apache include or .htaccess file to write the blocked IPs to.")
+    flag.StringVar(&apachectlBinary, "apachectl-binary", "apachectl",
+      "Location of the apachectl binary.")
+
+    // nginx output flags
+    flag.StringVar(&nginxBlockFile, "nginx-block-file", "",
+      "nginx include file to write the blocked IPs to.")
+    flag.StringVar(&nginxBlockFormat, "nginx-block-format", nginxFormatDeny,
+      "Format of the nginx include file; 'deny' or 'geo' ")
+    flag.StringVar(&nginxBinary, "nginx-binary", "nginx",
+      "Location of the nginx binary.")
+
+    // fail2ban output flags
+    flag.StringVar(&fail2banLog, "fail2ban-log", "",
+      "Event log of blocked IPs for fail2ban to watch.")
+    flag.StringVar(&fail2banJail, "fail2ban-jail", "ascii-log",
+      "Name of the fail2ban jail and filter.")
+    flag.StringVar(&fail2banConfigDir, "fail2ban-config-dir", "",
+      "Generate the filter.d and jail.d files here; e.g. '/etc/fail2ban' ")
+    flag.BoolVar(&fail2banBan, "fail2ban-banip", false,
+      "Ban the blocked IPs right away via fail2ban-client.")
+    flag.StringVar(&fail2banClient, "fail2ban-client-binary",
+      "fail2ban-client", "Location of the fail2ban-client binary.")
+
+    // Block escalation and expiry flags
+    flag.Float64Var(&blockTTLFactor, "block-ttl-factor", 2.0,
+      "Multiply the block length by this for every repeat offence.")
+    flag.DurationVar(&blockMaxTTL, "block-max-ttl", 30 * 24 * time.Hour,
+      "Longest length of time an IP can be blocked for; 0 = no limit")
+    flag.DurationVar(&blockMemory, "block-memory", 30 * 24 * time.Hour,
+      "Length of time expired blocks are remembered for repeat offenders.")
+    flag.IntVar(&blockWidenHosts, "block-widen-hosts", 0,
+      "Block a whole /24 or /64 once this many of its hosts are; 0 = never")
+    flag.DurationVar(&whoisCacheTTL, "whois-cache-ttl", 7 * 24 * time.Hour,
+      "Length of time whois records are cached for; 0 = no cache")
+    flag.DurationVar(&whoisNegativeTTL, "whois-negative-ttl", time.Hour,
+      "Length of time failed whois lookups are cached for")
+    flag.StringVar(&whoisServer, "whois-server", "whois.iana.org",
+      "Whois server lookups start at; e.g. 'whois.ripe.net' or 'host:port'")
+    flag.DurationVar(&whoisTimeout, "whois-timeout", 10 * time.Second,
+      "Length of time a whois server has to answer")
+    flag.IntVar(&whoisMaxReferrals, "whois-max-referrals", 3,
+      "Most referrals a whois lookup follows")
+    flag.IntVar(&whoisConcurrency, "whois-concurrency", 2,
+      "Most lookups in flight per whois server")
+    flag.StringVar(&rdapBootstrapFiles, "rdap-bootstrap", "",
+      "Comma separated list of RDAP bootstrap files; e.g. ipv4.json,ipv6.json")
+    flag.StringVar(&rdapServer, "rdap-server", "",
+      "RDAP server of addresses outside of the bootstrap files")
+    flag.DurationVar(&rdapTimeout, "rdap-timeout", 10 * time.Second,
+      "Length of time an RDAP server has to answer")
+    flag.IntVar(&whoisWorkers, "whois-workers", 4,
+      "Number of whois / RDAP lookups made at once")
+    flag.Float64Var(&whoisRate, "whois-rate", 2.0,
+      "Most whois / RDAP lookups made per second; 0 = no limit")
+    flag.IntVar(&rdnsWorkers, "rdns-workers", 16,
+      "Number of reverse DNS lookups made at once")
+    flag.Float64Var(&rdnsRate, "rdns-rate", 0,
+      "Most reverse DNS lookups made per second; 0 = no limit")
+    flag.DurationVar(&lookupTimeout, "lookup-timeout", 30 * time.Second,
+      "Length of time a single lookup may take; 0 = no limit")
+    flag.DurationVar(&lookupDeadline, "lookup-deadline", 0,
+      "Length of time the lookups of a run may take; 0 = no limit")
+    flag.StringVar(&geoipCountryFile, "geoip-country-db", "",
+      "Location of a MaxMind DB country file; e.g. GeoLite2-Country.mmdb")
+    flag.StringVar(&geoipAsnFile, "geoip-asn-db", "",
+      "Location of a MaxMind DB ASN file; e.g. GeoLite2-ASN.mmdb")
+    flag.StringVar(&abuseReportDir, "abuse-report-dir", "",
+      "Directory abuse reports are written to; blank = no reports")
+    flag.StringVar(&abuseFrom, "abuse-from", "",
+      "Sender of the abuse reports; blank = ascii-log@hostname")
+    flag.StringVar(&abuseSmtpRelay, "abuse-smtp-relay", "",
+      "SMTP relay abuse reports are sent via, if enforcing; e.g. localhost:25")
+    flag.IntVar(&abuseSampleLines, "abuse-sample-lines", 5,
+      "Most sample log lines listed per reported IP")
+    flag.DurationVar(&abuseReportInterval, "abuse-report-interval",
+      7 * 24 * time.Hour, "Length of time before an IP is reported again")
 }
 
 //
@@ -90,14 +461,14 @@ func main() {
     var err error = nil
 
     // Variables to hold the extracted IP addresses and to hold ip
-    // addresses to consider blocking, if enough data is gathered
-    //
-    // TODO: adjust the code so that it will add the blocked IPv4s
-    //       to a firewall for some period of time; e.g. 48 hours
-    //
+    // addresses to consider blocking, if enough data is gathered; these
+    // are then kept in the block ledger until their blocks expire
     var ip_addresses = make(map[string] int)
     var blocked_ip_addresses = []string{}
 
+    // Variable to hold the parsed entries of the latest date
+    var log_entries = make([]logEntry, 0)
+
     // Variable to hold a generic log header
     var generic_log_header = ""
 
@@ -110,6 +481,16 @@ func main() {
     // Variable to hold the number of lines added to the redirect log
     var lines_added_to_redirect uint = 0
 
+    // Run a subcommand instead of the automated run, if one was given.
+    if len(os.Args) > 1 && isStringInArray(os.Args[1], subcommands) {
+        err = runSubcommand(os.Args[1], os.Args[2:])
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+
     // Parse the flags, if any.
     flag.Parse()
 
@@ -132,6 +513,71 @@ func main() {
         os.Exit(1)
     }
 
+    // Attempt to parse the lists of wider subnet prefixes.
+    subnetPrefixList4, err = parseIntList(subnetPrefixes4)
+    if err == nil {
+        subnetPrefixList6, err = parseIntList(subnetPrefixes6)
+    }
+
+    // Attempt to parse the list of crawlers.
+    if err == nil {
+        crawlerRules, err = parseCrawlerRules(crawlers)
+    }
+
+    // Ensure the nginx block file format is a known one.
+    nginxBlockFormat = strings.ToLower(nginxBlockFormat)
+    if err == nil && nginxBlockFormat != nginxFormatDeny &&
+      nginxBlockFormat != nginxFormatGeo {
+        err = fmt.Errorf("unknown nginx block file format: %s",
+          nginxBlockFormat)
+    }
+
+    // Print the usage message if any list is improper.
+    if err != nil {
+        fmt.Println(err)
+        flag.Usage()
+        os.Exit(1)
+    }
+
+    // Assemble the firewall backend, printing the changes instead of
+    // applying them during a dry run.
+    var runner commandRunner = execRunner{}
+    if dryRun {
+        runner = dryRunRunner{}
+    }
+
+    // The backends are only touched when enforcing, or to print what would
+    // have been done during a dry run.
+    apply_changes := enforce || dryRun
+    run_mode := "report only, pass --enforce to apply"
+    if dryRun {
+        run_mode = "dry run, nothing applied or saved"
+    } else if enforce {
+        run_mode = "enforce"
+    }
+    firewall_backend, err := newFirewallBackend(strings.ToLower(firewall),
+      runner, dryRun, blockMaxTTL)
+    if err != nil {
+        fmt.Println(err)
+        flag.Usage()
+        os.Exit(1)
+    }
+
+    // Attempt to read the blocking policy, and report any problems with
+    // it now rather than partway thru a run.
+    policyRules, err = readPolicyFile(policyFile)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
+    // Likewise for the allowlist.
+    allowlistEntries, err = readAllowlistFile(allowlistFile)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
     // Check if the web data directory actually exists.
     _, err = ioutil.ReadDir(web_location)
 
@@ -142,6 +588,34 @@ func main() {
         os.Exit(1)
     }
 
+    // Attempt to create the state directory, if it does not yet exist.
+    err = os.MkdirAll(state_directory, 0755)
+
+    // ensure no error occurred
+    if err != nil {
+        fmt.Println("Unable to create the following directory: ",
+          state_directory)
+        os.Exit(1)
+    }
+
+    // Likewise for the abuse report directory, if reports were asked for.
+    if len(abuseReportDir) > 0 {
+        err = os.MkdirAll(abuseReportDir, 0755)
+        if err != nil {
+            fmt.Println("Unable to create the following directory: ",
+              abuseReportDir)
+            os.Exit(1)
+        }
+    }
+
+    // Assemble the whois client, load the GeoIP databases, and read the
+    // whois records of earlier runs.
+    err = setupLookups()
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
     // Assemble the access.log file location.
     access_log_location := log_directory + serverType + "/" + access_log
 
@@ -193,6 +667,16 @@ func main() {
         // the previous generic log header
         generic_log_header = ""
 
+        // likewise clear the parsed entries and blocked addresses of the
+        // previous cycle
+        log_entries = log_entries[:0]
+        blocked_ip_addresses = blocked_ip_addresses[:0]
+
+        // in sketch mode, the unique IPs are estimated with a HyperLogLog
+        // and only the heaviest hitters are counted
+        unique_ip_sketch := newHyperLogLog()
+        heavy_hitters := newSpaceSaving(sketchTopK)
+
         // assemble the generic log header used by all of the logs
         generic_log_header += "Generated on: " + datetime + "\n"
         generic_log_header += "\n"
@@ -233,14 +717,30 @@ func main() {
             // grab the first element, that is the IP address
             ip := elements[0]
 
-            // determine if this is a valid IPv4 address
-            if !isValidIPv4Address(ip) {
+            // determine if this is a valid IPv4 or IPv6 address
+            if !isValidIPv4Address(ip) && !isValidIPv6Address(ip) {
                 continue
             }
 
             // since the ip address is valid, go ahead and add it to the
-            // global array of ip addresses.
-            ip_addresses[ip]++
+            // global array of ip addresses, or to the sketches if the
+            // memory used ought to stay fixed
+            if sketchMode {
+                unique_ip_sketch.add(ip)
+                heavy_hitters.add(ip)
+            } else {
+                ip_addresses[ip]++
+            }
+
+            // attempt to parse the line, and if successful, keep the entry
+            // for the reports that need more than the IP address; in
+            // sketch mode this waits until the top IPs are known
+            if !sketchMode {
+                entry, err := parseLogLine(line)
+                if err == nil {
+                    log_entries = append(log_entries, entry)
+                }
+            }
 
             // check if the line contains the 302 pattern
             redirect_chunk := redirect_regex.FindString(line)
@@ -293,9 +793,9 @@ func main() {
             }
 
             // since the \t character tends to get mangled easily, add a
-            // buffer of single-space characters instead to the IPv4
+            // buffer of single-space characters instead to the IP
             // addresses
-            space_formatted_ip_address, err := spaceFormatIPv4(ip)
+            space_formatted_ip_address, err := spaceFormatIPAddress(ip)
 
             // if an error occurs, skip to the next element
             if err != nil {
@@ -309,18 +809,43 @@ func main() {
             // append it to the log contents of redirect entries
             redirect_log_contents += assembled_line_string
 
-            // finally, add the ip address to the list of IPv4 addresses to
-            // consider blocking eventually
-            if (!isStringInArray(ip, blocked_ip_addresses)) {
-                blocked_ip_addresses = append(blocked_ip_addresses, ip)
-            }
-
             // increment the line counter
             lines_added_to_redirect++
         }
 
+        // in sketch mode, only the heaviest hitters are looked up, listed
+        // in the ip.log and run through the policy; a second pass recounts
+        // their requests exactly, since the space-saving counts may be
+        // overestimated, and keeps their entries, so that the state kept
+        // does not grow with the number of addresses seen
+        if sketchMode {
+            ip_addresses = heavy_hitters.counts()
+            for ip, _ := range ip_addresses {
+                ip_addresses[ip] = 0
+            }
+            for _, line := range lines {
+                if len(re.FindString(line)) < 1 {
+                    continue
+                }
+                ip := strings.SplitN(line, " ", 2)[0]
+                if _, exists := ip_addresses[ip]; !exists {
+                    continue
+                }
+                ip_addresses[ip]++
+                entry, err := parseLogLine(line)
+                if err == nil {
+                    log_entries = append(log_entries, entry)
+                }
+            }
+        }
+
+        // the lookups of this run are given up on once the deadline, if
+        // any, has passed
+        lookup_ctx, cancel_lookups := obtainLookupContext()
+
         // attempt to obtain the whois entries, as a string
-        whois_strings, whois_summary_map, err := obtainWhoisEntries(ip_addresses)
+        whois_strings, whois_summary_map, whois_record_map, err :=
+          obtainWhoisEntries(lookup_ctx, ip_addresses)
 
         // if an error occurred, terminate the program
         if err != nil {
@@ -328,6 +853,28 @@ func main() {
             os.Exit(1)
         }
 
+        // save the whois records, so that the next run can reuse them;
+        // they are merely looked up, so this is done during a dry run too
+        err = saveWhoisCache()
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // gather the per IP statistics of the parsed entries
+        ip_stats := aggregateIpStats(log_entries)
+
+        // list every address seen, or in sketch mode the top IPs
+        policy_ips := make([]string, 0, len(ip_addresses))
+        for ip, _ := range ip_addresses {
+            policy_ips = append(policy_ips, ip)
+        }
+        sort.Strings(policy_ips)
+
+        // find the addresses that can never be blocked
+        allowlisted := obtainAllowlistMatches(lookup_ctx, allowlistEntries,
+          policy_ips, whois_record_map)
+
         // append the title to the whois_log_contents
         whois_log_contents += "Whois Entry Data\n\n"
 
@@ -354,8 +901,8 @@ func main() {
                                0644)
 
         // convert the ip addresses map into an array of strings
-        ip_strings, err := convertIpAddressMapToString(ip_addresses,
-          whois_summary_map)
+        ip_strings, err := convertIpAddressMapToString(lookup_ctx,
+          ip_addresses, whois_summary_map, allowlisted)
 
         // if an error occurred, terminate from the program
         if err != nil {
@@ -380,6 +927,15 @@ func main() {
         // append the generic log header to the ip.log file
         ip_log_contents += generic_log_header
 
+        // in sketch mode, note that the choice of top IPs is an estimate
+        if sketchMode {
+            ip_log_contents += "Unique IPs (estimated): " +
+              strconv.FormatUint(unique_ip_sketch.estimate(), 10) + "\n"
+            ip_log_contents += "Top " + strconv.Itoa(len(ip_addresses)) +
+              " IPs listed, chosen by counts that may be overestimated " +
+              "by up to " + strconv.Itoa(heavy_hitters.maxError()) + "\n\n"
+        }
+
         // append the ip_strings content to this point of the log; it will
         // either contain the "IPv4 Address + Daily Count" or a message stating
         // that no addresses appear to be recorded today.
@@ -425,39 +981,305 @@ func main() {
             os.Exit(1)
         }
 
-        // cycle thru all of the ip address counts...
-        for ip, count := range ip_addresses {
+        // rebuild the visitor sessions from the parsed entries
+        sessions := buildSessions(log_entries, sessionGap)
+
+        // assemble the sessions log contents
+        sessions_log_contents := "Visitor Session Data\n\n"
+        sessions_log_contents += generic_log_header
+        sessions_log_contents += convertSessionsToString(sessions,
+          longSession)
+
+        // having gotten this far, attempt to write the session data
+        // contents to the log file
+        err = writeLogFile(web_location + sessions_log,
+                           sessions_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // assemble the latency log contents from the response times
+        latency_log_contents := "Response Time Data\n\n"
+        latency_log_contents += generic_log_header
+        latency_log_contents += convertLatenciesToString(log_entries)
+
+        // attempt to write the latency data contents to the log file
+        err = writeLogFile(web_location + latency_log, latency_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // assemble the methods log contents
+        methods_log_contents := "HTTP Method and Protocol Data\n\n"
+        methods_log_contents += generic_log_header
+        methods_log_contents += obtainMethodReport(log_entries)
+
+        // attempt to write the method data contents to the log file
+        err = writeLogFile(web_location + methods_log, methods_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // verify the addresses claiming to be search engine crawlers
+        crawler_checks := obtainCrawlerChecks(lookup_ctx, ip_stats,
+          crawlerRules)
+        cancel_lookups()
 
-            // obtain the country code of this IP address
-            given_country_code := whois_summary_map[ip]
+        // assemble the crawlers log contents
+        crawlers_log_contents := "Search Engine Crawler Data\n\n"
+        crawlers_log_contents += generic_log_header
+        crawlers_log_contents += convertCrawlerChecksToString(crawler_checks)
 
-            // safety check, ensure the result is not nil
-            if len(given_country_code) != 2 || given_country_code == ".." {
+        // attempt to write the crawler data contents to the log file
+        err = writeLogFile(web_location + crawlers_log,
+                           crawlers_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // evaluate the blocking policy against every address
+        policy_decisions := make(map[string] policyDecision)
+        for _, ip := range policy_ips {
+
+            // allowlisted addresses override every rule
+            if match, exists := allowlisted[ip]; exists {
+                policy_decisions[ip] = policyDecision{action: policyAllow,
+                  rule: "allowlist (" + match + ")"}
                 continue
             }
 
-            // skip if the country is one of the following:
-            //
-            // * US --> United States
-            // * CA --> Canada
-            // * UK --> United Kingdom
-            // * FR --> France
-            // * DE --> Germany
-            // * NL --> Netherlands
-            //
-            if given_country_code == "US" || given_country_code == "CA" ||
-              given_country_code == "UK" || given_country_code == "FR" ||
-              given_country_code == "DE" || given_country_code == "NL" {
-                continue
+            // the request count, falling back on the parsed entries
+            requests := ip_addresses[ip]
+            if requests < 1 && ip_stats[ip] != nil {
+                requests = ip_stats[ip].requests
             }
 
-            // skip to the next if count is less than 5
-            if count < 5 {
-                continue
+            // fallback to "--" if the country code is unknown
+            country_code := whois_summary_map[ip]
+            if len(country_code) != 2 || country_code == ".." {
+                country_code = "--"
             }
 
+            decision := evaluatePolicy(policyRules, policyInput{
+                ip:       ip,
+                requests: requests,
+                country:  country_code,
+                asn:      whois_record_map[ip].asn,
+                crawler:  crawler_checks[ip].status,
+                stats:    ip_stats[ip],
+            })
+            policy_decisions[ip] = decision
+
             // go ahead an append to the list of blocked ips
-            blocked_ip_addresses = append(blocked_ip_addresses, ip)
+            if decision.action == policyBlock {
+                blocked_ip_addresses = append(blocked_ip_addresses, ip)
+            }
+        }
+
+        // assemble the policy log contents
+        policy_log_contents := "Blocking Policy Data\n\n"
+        policy_log_contents += generic_log_header
+        policy_log_contents += convertPolicyDecisionsToString(policyRules,
+          policy_decisions)
+
+        // attempt to write the policy data contents to the log file
+        err = writeLogFile(web_location + policy_log, policy_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // roll up the IP address counts into subnets, since botnets tend
+        // to spread their requests across a subnet to stay under the
+        // single IP threshold; the busy ones then go through the same
+        // allow rules as single addresses
+        blocked_subnets, allowed_subnets := obtainPolicySubnets(
+          obtainSubnetsToBlock(aggregateSubnets(ip_addresses, 24, 64),
+          subnetBlockHosts, subnetBlockRequests), policyRules,
+          policy_decisions, whois_summary_map, whois_record_map)
+
+        // append the remaining subnets, as CIDRs, unless they would also
+        // block an allowlisted address
+        subnet_notes := make(map[string] string)
+        for subnet, rule := range allowed_subnets {
+            subnet_notes[subnet] = "allowed (" + rule + ")"
+        }
+        for _, subnet := range blocked_subnets {
+            if allowlistEntries.coversTarget(subnet, allowlisted) {
+                subnet_notes[subnet] = "allowed (allowlist)"
+                continue
+            }
+            subnet_notes[subnet] = "blocked"
+            if !isStringInArray(subnet, blocked_ip_addresses) {
+                blocked_ip_addresses = append(blocked_ip_addresses, subnet)
+            }
+        }
+
+        // assemble the subnets log contents
+        subnets_log_contents := "Subnet Aggregation Data\n\n"
+        subnets_log_contents += generic_log_header
+        subnets_log_contents += obtainSubnetReport(ip_addresses,
+          subnetPrefixList4, subnetPrefixList6, subnet_notes)
+
+        // attempt to write the subnet data contents to the log file
+        err = writeLogFile(web_location + subnets_log, subnets_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // group the traffic and block candidates by network owner
+        networks_log_contents := "Network Owner Data\n\n"
+        networks_log_contents += generic_log_header
+        networks_log_contents += obtainNetworkReport(ip_addresses,
+          whois_record_map, blocked_ip_addresses)
+
+        // attempt to write the network data contents to the log file
+        err = writeLogFile(web_location + networks_log,
+                           networks_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // summarize the traffic of every country, compared against the
+        // previous period
+        country_strings, err := obtainCountryReport(ip_addresses,
+          whois_summary_map, ip_stats,
+          blocked_ip_addresses, latest_date_in_log,
+          state_directory + countries_history)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // assemble the countries log contents
+        countries_log_contents := "Country Summary Data\n\n"
+        countries_log_contents += generic_log_header
+        countries_log_contents += country_strings
+
+        // attempt to write the country data contents to the log file
+        err = writeLogFile(web_location + countries_log,
+                           countries_log_contents)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // attempt to read the block ledger, so that earlier blocks stay in
+        // force until they expire
+        ledger, err := readBlockLedger(state_directory + blocks_ledger)
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // record the addresses blocked by the policy, along with the rule
+        // that blocked them, and the subnets that crossed the thresholds
+        now := time.Now()
+        recorded_blocks := make([]*blockEntry, 0)
+        for _, target := range blocked_ip_addresses {
+            reason, ttl := "subnet", blockTTL
+            if decision, exists := policy_decisions[target]; exists {
+                reason = decision.rule
+                if decision.ttl > 0 {
+                    ttl = decision.ttl
+                }
+            }
+            recorded_blocks = append(recorded_blocks,
+              recordBlock(ledger, target, reason, ttl, now))
+        }
+
+        // lift the blocks of addresses allowlisted since they were made
+        for target, _ := range ledger {
+            if allowlistEntries.coversTarget(target, allowlisted) {
+                delete(ledger, target)
+            }
+        }
+
+        // forget the blocks that expired long ago, then save the ledger,
+        // unless this is merely a dry run
+        pruneBlockLedger(ledger, now, blockMemory)
+        if !dryRun {
+            err = writeBlockLedger(state_directory + blocks_ledger, ledger)
+        }
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
+        }
+
+        // the blocked.log and firewall are generated from the blocks still
+        // in force, rather than from this run alone
+        //
+        // each entry only stays in force for what remains of its block
+        active_blocks := obtainActiveBlocks(ledger, now)
+        block_entries := make([]firewallEntry, 0, len(active_blocks))
+        for _, block := range active_blocks {
+            block_entries = append(block_entries,
+              firewallEntry{block.target, block.expires.Sub(now)})
+        }
+
+        // collapse them into the fewest CIDRs, widening to whole /24s or
+        // /64s where enough of their hosts are blocked
+        block_entries = collapseFirewallEntries(widenFirewallEntries(
+          block_entries, blockWidenHosts, allowlistEntries, allowlisted))
+        blocked_targets := make([]string, 0, len(block_entries))
+        blocked_reasons := make(map[string] string)
+        for _, entry := range block_entries {
+            blocked_targets = append(blocked_targets, entry.target)
+            blocked_reasons[entry.target] = "merged"
+            if block, exists := ledger[entry.target]; exists {
+                blocked_reasons[entry.target] = block.reason
+            }
+        }
+
+        // compare the block set against that of the previous run
+        blocked_diff_contents := "Blocked IP Changes Data\n\n"
+        blocked_diff_contents += generic_log_header
+        blocked_diff_contents += convertBlockDiffToString(
+          readBlockSet(state_directory + blocked_set), blocked_reasons,
+          run_mode)
+
+        // attempt to write the blocked diff contents to the log file
+        err = writeLogFile(web_location + blocked_diff_log,
+                           blocked_diff_contents)
+
+        // keep the block set for the next run to compare against
+        if err == nil && !dryRun {
+            err = writeBlockSet(state_directory + blocked_set,
+              blocked_reasons)
+        }
+
+        // if an error occurs, terminate from the program
+        if err != nil {
+            fmt.Println(err)
+            os.Exit(1)
         }
 
         // attempt to stat() the blocked.log file, else create it if it does
@@ -473,34 +1295,34 @@ func main() {
 
         // if no entries were added to the blocked.log, then add a short
         // message noting that there were no addresses at this time
-        if len(blocked_ip_addresses) < 1 {
+        if len(block_entries) < 1 {
             blocked_log_contents += "No IPs blocked at this time."
 
         // if there *are* IPs that have been requested to block, attempt to
         // generate a list of IP addresses to block, in the form of an
         // nginx 'sites-available' configuration
-        } else if len(blocked_ip_addresses) >= 1 && serverType == "nginx" {
+        } else if len(block_entries) >= 1 && serverType == "nginx" {
 
             // start with a location chunk
             blocked_log_contents += "location / {\n"
 
-            // append all of the blocked IPs together, newline separated
-            for _, ip := range blocked_ip_addresses {
-                blocked_log_contents += "deny " + ip + ";\n"
+            // append all of the blocked IPs together, newline separated,
+            // noting why and until when each one is blocked
+            for _, entry := range block_entries {
+                blocked_log_contents += "deny " + entry.target + "; # " +
+                  blocked_reasons[entry.target] + ", until " +
+                  now.Add(entry.timeout).Format(ledgerTimeLayout) + "\n"
             }
 
             // terminate with a curl bracket, so signal the end of the
             // server location
             blocked_log_contents += "}\n"
 
-        // otherwise the server is not an nginx, so just print out a list
-        // of IPs that would have been blocked
+        // otherwise the server is an apache, so assemble the equivalent
+        // Apache 2.4 'Require' directives
         } else {
-
-            // append all of the blocked IPs together, newline separated
-            for _, ip := range blocked_ip_addresses {
-                blocked_log_contents += ip + "\n"
-            }
+            blocked_log_contents += assembleApacheBlockConfig(
+              blocked_targets, false)
         }
 
         // having gotten this far, attempt to write the blocked data
@@ -515,8 +1337,107 @@ func main() {
             os.Exit(1)
         }
 
-        // TODO: as mentioned earlier, consider adding the blocked IPv4
-        //       addresses to a firewall at this point in the program
+        // if an Apache block file was given, bring it in line with the
+        // blocked addresses, reloading apache if the config test passes
+        if apply_changes && serverType == "apache" &&
+          len(apacheBlockFile) > 0 {
+
+            apache_file := newApacheBlockFile(runner, apacheBlockFile,
+              apachectlBinary, dryRun, state_directory + config_history)
+            _, err = apache_file.apply(
+              assembleApacheBlockConfig(blocked_targets,
+              isHtaccessFile(apacheBlockFile)))
+
+            // if an error occurs, terminate from the program
+            if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
+            }
+        }
+
+        // likewise for the nginx block file
+        if apply_changes && serverType == "nginx" &&
+          len(nginxBlockFile) > 0 {
+
+            nginx_file := newNginxBlockFile(runner, nginxBlockFile,
+              nginxBinary, dryRun, state_directory + config_history)
+
+            nginx_config := assembleNginxDenyConfig(blocked_targets)
+            if nginxBlockFormat == nginxFormatGeo {
+                nginx_config = assembleNginxGeoConfig(blocked_targets)
+            }
+            _, err = nginx_file.apply(nginx_config)
+
+            // if an error occurs, terminate from the program
+            if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
+            }
+        }
+
+        // hand the blocks of this run over to fail2ban, if need be; fail2ban
+        // acts on the event log, so it is only printed during a dry run
+        if apply_changes && len(fail2banLog) > 0 {
+
+            fail2ban_events := assembleFail2banEvents(recorded_blocks, now)
+            if dryRun {
+                fmt.Println("# append " + fail2banLog)
+                fmt.Print(fail2ban_events)
+            } else {
+                err = appendFail2banEvents(fail2banLog, fail2ban_events)
+            }
+
+            if err == nil && len(fail2banConfigDir) > 0 {
+                err = writeFail2banConfig(runner, fail2banClient,
+                  fail2banConfigDir, fail2banJail, fail2banLog, blockTTL,
+                  blockTTLFactor, blockMaxTTL, dryRun,
+                  state_directory + config_history)
+            }
+
+            // if an error occurs, terminate from the program
+            if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
+            }
+        }
+        if apply_changes && fail2banBan {
+
+            err = banWithFail2ban(runner, fail2banClient, fail2banJail,
+              recorded_blocks)
+
+            // if an error occurs, terminate from the program
+            if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
+            }
+        }
+
+        // if a firewall backend was chosen, bring it in line with the
+        // blocked addresses
+        if apply_changes && firewall_backend != nil {
+
+            err = firewall_backend.sync(block_entries)
+
+            // if an error occurs, terminate from the program
+            if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
+            }
+        }
+
+        // report the addresses blocked this run to the abuse contacts of
+        // their networks, if need be
+        if len(abuseReportDir) > 0 {
+
+            err = reportAbuse(recorded_blocks, whois_record_map,
+              log_entries, lines, latest_date_in_log, now)
+
+            // if an error occurs, terminate from the program
+            if err != nil {
+                fmt.Println(err)
+                os.Exit(1)
+            }
+        }
 
         // if daemon mode is disabled, then exit this loop
         if !daemonMode {
@@ -0,0 +1,150 @@
+//
+// Block set diff functions for ASCII-log
+//
+// The block set of every run is kept, so that the next run can report which
+// entries were added, removed or left unchanged, and why. The set is stored
+// one entry per line, with a tab between the target and the reason.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "sort"
+    "strings"
+)
+
+//! Read the block set of the previous run
+/*
+ * @param     string    /path/to/file
+ *
+ * @return    map       map of blocked targets and their reasons
+ */
+func readBlockSet(path string) map[string] string {
+
+    // variable declaration
+    var result = make(map[string] string)
+
+    // a missing file simply means there was no previous run
+    byte_contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return result
+    }
+
+    for _, line := range strings.Split(string(byte_contents), "\n") {
+        pieces := strings.SplitN(line, "\t", 2)
+        if len(pieces[0]) < 1 {
+            continue
+        }
+        reason := ""
+        if len(pieces) > 1 {
+            reason = pieces[1]
+        }
+        result[pieces[0]] = reason
+    }
+
+    return result
+}
+
+//! Write the block set of this run, for the next run to compare against
+/*
+ * @param     string    /path/to/file
+ * @param     map       map of blocked targets and their reasons
+ *
+ * @return    error     error message, if any
+ */
+func writeBlockSet(path string, blocked map[string] string) error {
+
+    // variable declaration
+    var contents string = ""
+
+    for _, target := range sortedStringKeys(blocked) {
+        contents += target + "\t" + blocked[target] + "\n"
+    }
+
+    return writeLogFile(path, contents)
+}
+
+//! Obtain the sorted keys of a string map
+/*
+ * @param     map         string map
+ *
+ * @return    string[]    sorted keys
+ */
+func sortedStringKeys(values map[string] string) []string {
+
+    keys := make([]string, 0, len(values))
+    for key, _ := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+//! Convert the differences between two block sets into the contents of the
+//! blocked-diff log
+/*
+ * @param     map       previous map of blocked targets and their reasons
+ * @param     map       current map of blocked targets and their reasons
+ * @param     string    mode of the run; e.g. enforce
+ *
+ * @return    string    block set diff report
+ */
+func convertBlockDiffToString(previous map[string] string,
+  current map[string] string, mode string) string {
+
+    // variable declaration
+    var added = make([]string, 0)
+    var removed = make([]string, 0)
+    var unchanged = make([]string, 0)
+    var diff_strings string = ""
+
+    for _, target := range sortedStringKeys(current) {
+        if _, exists := previous[target]; exists {
+            unchanged = append(unchanged, target)
+        } else {
+            added = append(added, target)
+        }
+    }
+    for _, target := range sortedStringKeys(previous) {
+        if _, exists := current[target]; !exists {
+            removed = append(removed, target)
+        }
+    }
+
+    diff_strings += "Mode: " + mode + "\n"
+    diff_strings += fmt.Sprintf("Added: %d, removed: %d, unchanged: %d\n",
+      len(added), len(removed), len(unchanged))
+
+    sections := []struct {
+        title   string
+        marker  string
+        targets []string
+        reasons map[string] string
+    }{
+        {"Added", "+", added, current},
+        {"Removed", "-", removed, previous},
+        {"Unchanged", "=", unchanged, current},
+    }
+
+    for _, section := range sections {
+        diff_strings += "\n" + section.title + "\n\n"
+        if len(section.targets) < 1 {
+            diff_strings += "None.\n"
+            continue
+        }
+        for _, target := range section.targets {
+            diff_strings += fmt.Sprintf("%s %-43s | %s\n", section.marker,
+              target, section.reasons[target])
+        }
+    }
+
+    return diff_strings
+}
@@ -0,0 +1,150 @@
+//
+// Block set diff tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestConvertBlockDiffToString(t *testing.T) {
+
+    previous := map[string] string{
+        "192.0.2.1":       "busy",
+        "198.51.100.0/24": "subnet",
+        "2001:db8::1":     "scanner",
+    }
+    current := map[string] string{
+        "192.0.2.1":    "busy, again",
+        "2001:db8::1":  "scanner",
+        "203.0.113.5":  "spam",
+        "203.0.113.10": "abuse",
+    }
+
+    output := convertBlockDiffToString(previous, current, "enforce")
+    lines := strings.Split(output, "\n")
+
+    if lines[0] != "Mode: enforce" ||
+      lines[1] != "Added: 2, removed: 1, unchanged: 2" {
+        t.Errorf("header = %q", lines[:2])
+    }
+
+    // each target sits in its own section, sorted, with the reason of the
+    // run it belongs to
+    want := map[string] []string{
+        "Added": {
+            "+ 203.0.113.10", "abuse",
+            "+ 203.0.113.5", "spam",
+        },
+        "Removed": {
+            "- 198.51.100.0/24", "subnet",
+        },
+        "Unchanged": {
+            "= 192.0.2.1", "busy, again",
+            "= 2001:db8::1", "scanner",
+        },
+    }
+    section := ""
+    got := make(map[string] []string)
+    for _, line := range lines[2:] {
+        if _, exists := want[line]; exists {
+            section = line
+            continue
+        }
+        pieces := strings.SplitN(line, " | ", 2)
+        if len(pieces) != 2 {
+            continue
+        }
+        got[section] = append(got[section], strings.TrimSpace(pieces[0]),
+          pieces[1])
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("sections = %q, want %q\n%s", got, want, output)
+    }
+}
+
+func TestConvertBlockDiffToStringEmpty(t *testing.T) {
+
+    // with nothing on either side, every section says so
+    tests := []struct {
+        name     string
+        previous map[string] string
+        current  map[string] string
+        counts   string
+        nones    int
+    }{
+        {"no runs", nil, nil, "Added: 0, removed: 0, unchanged: 0", 3},
+        {"first run", nil, map[string] string{"192.0.2.1": "busy"},
+          "Added: 1, removed: 0, unchanged: 0", 2},
+        {"nothing left", map[string] string{"192.0.2.1": "busy"},
+          map[string] string{}, "Added: 0, removed: 1, unchanged: 0", 2},
+    }
+
+    for _, test := range tests {
+
+        output := convertBlockDiffToString(test.previous, test.current,
+          "dry-run")
+        if !strings.HasPrefix(output, "Mode: dry-run\n" + test.counts +
+          "\n") {
+            t.Errorf("%s: header of\n%s", test.name, output)
+        }
+        if nones := strings.Count(output, "\nNone.\n"); nones != test.nones {
+            t.Errorf("%s: %d empty sections, want %d", test.name, nones,
+              test.nones)
+        }
+    }
+}
+
+func TestBlockSetRoundTrip(t *testing.T) {
+
+    path := filepath.Join(t.TempDir(), "blocked.set")
+
+    // a missing file is an empty set, rather than an error
+    if blocked := readBlockSet(path); len(blocked) != 0 {
+        t.Errorf("missing file read as %v", blocked)
+    }
+
+    blocked := map[string] string{
+        "192.0.2.1":       "busy",
+        "198.51.100.0/24": "subnet of 3 busy addresses",
+        "2001:db8::1":     "",
+        "203.0.113.5":     "reason\twith a tab",
+    }
+    err := writeBlockSet(path, blocked)
+    if err != nil {
+        t.Fatalf("unable to write the block set: %v", err)
+    }
+    read := readBlockSet(path)
+    if !reflect.DeepEqual(read, blocked) {
+        t.Errorf("read back %q, want %q", read, blocked)
+    }
+
+    // and the sets of two runs alike make for no changes at all
+    output := convertBlockDiffToString(read, blocked, "enforce")
+    if !strings.Contains(output, "Added: 0, removed: 0, unchanged: 4\n") {
+        t.Errorf("round trip diff:\n%s", output)
+    }
+
+    // blank lines, and a file of an older run without reasons, still read
+    err = ioutil.WriteFile(path, []byte("192.0.2.1\n\n198.51.100.7\tspam\n"),
+      0644)
+    if err != nil {
+        t.Fatalf("unable to write the block set: %v", err)
+    }
+    read = readBlockSet(path)
+    want := map[string] string{"192.0.2.1": "", "198.51.100.7": "spam"}
+    if !reflect.DeepEqual(read, want) {
+        t.Errorf("read %q, want %q", read, want)
+    }
+}
@@ -0,0 +1,309 @@
+//
+// CIDR utility functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "math/big"
+    "net"
+    "sort"
+    "strings"
+    "time"
+)
+
+//! Convert an IP address into an integer, along with its bit length
+/*
+ * @param    IP        IPv4 or IPv6 address
+ *
+ * @return   Int*      address as an integer
+ * @return   int       32 for IPv4, 128 for IPv6
+ */
+func ipToInt(ip net.IP) (*big.Int, int) {
+
+    if v4 := ip.To4(); v4 != nil {
+        return new(big.Int).SetBytes(v4), 32
+    }
+    return new(big.Int).SetBytes(ip.To16()), 128
+}
+
+//! Convert an integer back into an IP address
+/*
+ * @param    Int*      address as an integer
+ * @param    int       32 for IPv4, 128 for IPv6
+ *
+ * @return   IP        IPv4 or IPv6 address
+ */
+func intToIp(value *big.Int, bits int) net.IP {
+
+    // pad the integer bytes out to the full address length
+    raw := value.Bytes()
+    result := make(net.IP, bits / 8)
+    copy(result[len(result)-len(raw):], raw)
+    return result
+}
+
+//! Convert an inclusive range of addresses into the CIDRs covering it
+/*
+ * @param    string      first address of the range
+ * @param    string      last address of the range
+ *
+ * @return   string[]    array of CIDRs, smallest address first
+ * @return   error       error message, if any
+ */
+func convertRangeToCidrs(first string, last string) ([]string, error) {
+
+    // variable declaration
+    var result = make([]string, 0)
+
+    // attempt to parse both ends of the range
+    first_ip := net.ParseIP(strings.TrimSpace(first))
+    last_ip := net.ParseIP(strings.TrimSpace(last))
+    if first_ip == nil || last_ip == nil {
+        return nil, fmt.Errorf("convertRangeToCidrs() --> invalid input")
+    }
+
+    start, bits := ipToInt(first_ip)
+    end, end_bits := ipToInt(last_ip)
+    if bits != end_bits || start.Cmp(end) > 0 {
+        return nil, fmt.Errorf("convertRangeToCidrs() --> improper range")
+    }
+
+    one := big.NewInt(1)
+
+    // repeatedly take the largest aligned block that starts at the
+    // beginning of the range and does not go past the end of it
+    for start.Cmp(end) <= 0 {
+
+        // the alignment of the start limits the block size
+        host_bits := 0
+        for host_bits < bits && start.Bit(host_bits) == 0 {
+            host_bits++
+        }
+
+        // shrink the block until it fits within the range
+        for host_bits > 0 {
+            block_end := new(big.Int).Lsh(one, uint(host_bits))
+            block_end.Add(block_end, start)
+            block_end.Sub(block_end, one)
+            if block_end.Cmp(end) <= 0 {
+                break
+            }
+            host_bits--
+        }
+
+        result = append(result, fmt.Sprintf("%s/%d",
+          intToIp(start, bits).String(), bits - host_bits))
+
+        // move on to the address after the block
+        size := new(big.Int).Lsh(one, uint(host_bits))
+        start = new(big.Int).Add(start, size)
+    }
+
+    return result, nil
+}
+
+//! An inclusive range of addresses, as blocked until a given time
+type blockedRange struct {
+    first   *big.Int
+    last    *big.Int
+    bits    int
+    timeout time.Duration
+}
+
+//! Convert a firewall entry into the range of addresses it covers
+/*
+ * @param    firewallEntry    IP address or CIDR entry
+ *
+ * @return   blockedRange     range of addresses
+ * @return   error            error message, if any
+ */
+func convertEntryToRange(entry firewallEntry) (blockedRange, error) {
+
+    // treat single addresses as networks of a single address
+    target := normalizeFirewallTarget(entry.target)
+    if !strings.Contains(target, "/") {
+        if strings.Contains(target, ":") {
+            target += "/128"
+        } else {
+            target += "/32"
+        }
+    }
+
+    _, network, err := net.ParseCIDR(target)
+    if err != nil {
+        return blockedRange{}, fmt.Errorf("convertEntryToRange() --> " +
+          "improper target: %s", entry.target)
+    }
+
+    first, bits := ipToInt(network.IP)
+    ones, _ := network.Mask.Size()
+
+    // the last address has every host bit set
+    last := new(big.Int).Lsh(big.NewInt(1), uint(bits - ones))
+    last.Sub(last, big.NewInt(1))
+    last.Or(last, first)
+
+    return blockedRange{first, last, bits, entry.timeout}, nil
+}
+
+//! Widen the blocked addresses to their /24 or /64 once enough of its
+//! hosts are blocked, unless that would block an allowlisted address
+/*
+ * @param    firewallEntry[]    blocked addresses and CIDRs
+ * @param    int                hosts needed to widen; 0 = never
+ * @param    allowlist          parsed allowlist
+ * @param    map                map of allowlisted ip addresses seen this run
+ *
+ * @return   firewallEntry[]    entries, along with the widened prefixes
+ */
+func widenFirewallEntries(entries []firewallEntry, min_hosts int,
+  allowed *allowlist, allowlisted map[string] string) []firewallEntry {
+
+    // variable declaration
+    var hosts = make(map[string] int)
+    var timeouts = make(map[string] time.Duration)
+    var result = append([]firewallEntry{}, entries...)
+
+    if min_hosts < 1 {
+        return result
+    }
+
+    for _, entry := range entries {
+
+        // only single addresses count as hosts
+        if net.ParseIP(entry.target) == nil {
+            continue
+        }
+        prefix_len := 24
+        if strings.Contains(entry.target, ":") {
+            prefix_len = 64
+        }
+        prefix, err := obtainPrefixFromIp(entry.target, prefix_len)
+        if err != nil {
+            continue
+        }
+
+        // the prefix is blocked for as long as any of its hosts is, so
+        // that widening never cuts a block short
+        hosts[prefix]++
+        if timeout, exists := timeouts[prefix]; !exists ||
+          entry.timeout > timeout {
+            timeouts[prefix] = entry.timeout
+        }
+    }
+
+    for prefix, count := range hosts {
+        if count < min_hosts || allowed.coversTarget(prefix, allowlisted) {
+            continue
+        }
+        result = append(result, firewallEntry{prefix, timeouts[prefix]})
+    }
+
+    return result
+}
+
+//! Collapse blocked addresses and CIDRs into the fewest CIDRs covering
+//! them, merging contiguous and overlapping ones that expire together
+/*
+ * @param    firewallEntry[]    blocked addresses and CIDRs
+ *
+ * @return   firewallEntry[]    collapsed entries, sorted by address
+ */
+func collapseFirewallEntries(entries []firewallEntry) []firewallEntry {
+
+    // variable declaration
+    var ranges = make([]blockedRange, 0, len(entries))
+    var merged = make([]blockedRange, 0)
+    var result = make([]firewallEntry, 0)
+
+    for _, entry := range entries {
+        block, err := convertEntryToRange(entry)
+        if err == nil {
+            ranges = append(ranges, block)
+        }
+    }
+
+    // IPv4 first, then by the start of every range, and the widest range
+    // first among those starting at the same address
+    sort.Slice(ranges, func(i, j int) bool {
+        if ranges[i].bits != ranges[j].bits {
+            return ranges[i].bits < ranges[j].bits
+        }
+        if ranges[i].first.Cmp(ranges[j].first) != 0 {
+            return ranges[i].first.Cmp(ranges[j].first) < 0
+        }
+        return ranges[i].last.Cmp(ranges[j].last) > 0
+    })
+
+    one := big.NewInt(1)
+    for _, block := range ranges {
+
+        if len(merged) > 0 {
+            previous := &merged[len(merged)-1]
+            next := new(big.Int).Add(previous.last, one)
+
+            // a range within the previous one keeps the covering timeout
+            if previous.bits == block.bits &&
+              block.last.Cmp(previous.last) <= 0 {
+                continue
+            }
+
+            // touching or overlapping ranges are only merged when they
+            // are blocked for as long; otherwise the part that overlaps
+            // keeps the timeout of the previous range
+            if previous.bits == block.bits && block.first.Cmp(next) <= 0 {
+                if block.timeout == previous.timeout {
+                    previous.last = block.last
+                    continue
+                }
+                block.first = next
+            }
+        }
+
+        merged = append(merged, block)
+    }
+
+    for _, block := range merged {
+        cidrs, err := convertRangeToCidrs(intToIp(block.first,
+          block.bits).String(), intToIp(block.last, block.bits).String())
+        if err != nil {
+            continue
+        }
+        for _, cidr := range cidrs {
+            result = append(result, firewallEntry{
+              normalizeFirewallTarget(cidr), block.timeout})
+        }
+    }
+
+    return result
+}
+
+//! Check whether two addresses or CIDRs have any address in common
+/*
+ * @param    string    IP address or CIDR
+ * @param    string    IP address or CIDR
+ *
+ * @return   bool      whether or not the two overlap
+ */
+func targetsOverlap(first string, second string) bool {
+
+    a, err := convertEntryToRange(firewallEntry{target: first})
+    if err != nil {
+        return false
+    }
+    b, err := convertEntryToRange(firewallEntry{target: second})
+    if err != nil {
+        return false
+    }
+
+    return a.bits == b.bits && a.first.Cmp(b.last) <= 0 &&
+      b.first.Cmp(a.last) <= 0
+}
@@ -0,0 +1,245 @@
+//
+// CIDR tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "testing"
+    "time"
+)
+
+//! Convert firewall entries into a comparable string
+/*
+ * @param     firewallEntry[]    entries
+ *
+ * @return    string             e.g. "10.0.0.0/24 1h0m0s, ..."
+ */
+func convertFirewallEntriesToString(entries []firewallEntry) string {
+
+    // variable declaration
+    var result string = ""
+
+    for i, entry := range entries {
+        if i > 0 {
+            result += ", "
+        }
+        result += fmt.Sprintf("%s %v", entry.target, entry.timeout)
+    }
+    return result
+}
+
+func TestConvertRangeToCidrs(t *testing.T) {
+
+    tests := []struct {
+        first string
+        last  string
+        want  string
+        fails bool
+    }{
+        {"192.0.2.1", "192.0.2.1", "[192.0.2.1/32]", false},
+        {"192.0.2.0", "192.0.2.255", "[192.0.2.0/24]", false},
+        {" 192.0.2.0 ", "192.0.2.255\t", "[192.0.2.0/24]", false},
+        {"10.0.0.1", "10.0.0.6",
+          "[10.0.0.1/32 10.0.0.2/31 10.0.0.4/31 10.0.0.6/32]", false},
+        {"192.0.2.0", "192.0.3.127", "[192.0.2.0/24 192.0.3.0/25]", false},
+        {"0.0.0.0", "255.255.255.255", "[0.0.0.0/0]", false},
+        {"255.255.255.254", "255.255.255.255", "[255.255.255.254/31]",
+          false},
+        {"::ffff:192.0.2.0", "192.0.2.3", "[192.0.2.0/30]", false},
+        {"2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", "[2001:db8::/64]",
+          false},
+        {"2001:db8::1", "2001:db8::2", "[2001:db8::1/128 2001:db8::2/128]",
+          false},
+        {"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "[::/0]", false},
+        {"192.0.2.10", "192.0.2.9", "", true},
+        {"192.0.2.0", "2001:db8::", "", true},
+        {"", "", "", true},
+        {"192.0.2.0", "", "", true},
+        {"192.0.2", "192.0.2.255", "", true},
+    }
+
+    for _, test := range tests {
+
+        cidrs, err := convertRangeToCidrs(test.first, test.last)
+        if test.fails {
+            if err == nil {
+                t.Errorf("%q - %q: expected an error, got %v", test.first,
+                  test.last, cidrs)
+            }
+            continue
+        }
+        if err != nil || fmt.Sprint(cidrs) != test.want {
+            t.Errorf("%q - %q = %v, %v, want %s", test.first, test.last,
+              cidrs, err, test.want)
+        }
+    }
+}
+
+func TestCollapseFirewallEntries(t *testing.T) {
+
+    tests := []struct {
+        name    string
+        entries []firewallEntry
+        want    string
+    }{
+        {
+            name:    "halves merged",
+            entries: []firewallEntry{
+                {"192.0.2.128/25", time.Hour},
+                {"192.0.2.0/25", time.Hour},
+            },
+            want:    "192.0.2.0/24 1h0m0s",
+        },
+        {
+            name:    "halves with differing timeouts kept apart",
+            entries: []firewallEntry{
+                {"192.0.2.128/25", time.Hour},
+                {"192.0.2.0/25", 2 * time.Hour},
+            },
+            want:    "192.0.2.0/25 2h0m0s, 192.0.2.128/25 1h0m0s",
+        },
+        {
+            name:    "neighbours merged into the fewest CIDRs",
+            entries: []firewallEntry{
+                {"10.0.0.3", time.Hour},
+                {"10.0.0.1", time.Hour},
+                {"10.0.0.2", time.Hour},
+            },
+            want:    "10.0.0.1 1h0m0s, 10.0.0.2/31 1h0m0s",
+        },
+        {
+            name:    "address within a network, for the network's timeout",
+            entries: []firewallEntry{
+                {"10.1.2.3", time.Minute},
+                {"10.1.0.0/16", time.Hour},
+                {"10.1.200.0/24", 3 * time.Hour},
+            },
+            want:    "10.1.0.0/16 1h0m0s",
+        },
+        {
+            name:    "mixed timeouts, merged only where they match",
+            entries: []firewallEntry{
+                {"10.2.0.0/24", 2 * time.Hour},
+                {"10.2.0.7", time.Minute},
+                {"10.2.1.0/24", time.Hour},
+                {"10.2.2.0/24", time.Hour},
+                {"10.2.3.0/24", time.Hour},
+                {"10.2.4.0/24", 2 * time.Hour},
+            },
+            want:    "10.2.0.0/24 2h0m0s, 10.2.1.0/24 1h0m0s, " +
+              "10.2.2.0/23 1h0m0s, 10.2.4.0/24 2h0m0s",
+        },
+        {
+            name:    "gaps kept, IPv4 first",
+            entries: []firewallEntry{
+                {"2001:db8:0:0:8000::/65", time.Hour},
+                {"10.0.0.9", time.Hour},
+                {"2001:db8::/65", time.Hour},
+                {"10.0.0.7", time.Hour},
+            },
+            want:    "10.0.0.7 1h0m0s, 10.0.0.9 1h0m0s, " +
+              "2001:db8::/64 1h0m0s",
+        },
+        {
+            name:    "improper entries dropped",
+            entries: []firewallEntry{
+                {"not-an-ip", time.Hour},
+                {"192.0.2.1/33", time.Hour},
+                {"192.0.2.1", time.Hour},
+            },
+            want:    "192.0.2.1 1h0m0s",
+        },
+        {
+            name:    "nothing to collapse",
+            want:    "",
+        },
+    }
+
+    for _, test := range tests {
+        got := convertFirewallEntriesToString(
+          collapseFirewallEntries(test.entries))
+        if got != test.want {
+            t.Errorf("%s: collapseFirewallEntries() = %q, want %q",
+              test.name, got, test.want)
+        }
+    }
+}
+
+func TestWidenFirewallEntries(t *testing.T) {
+
+    allowed, err := parseAllowlist("192.0.2.10\n")
+    if err != nil {
+        t.Fatalf("unable to parse the allowlist: %v", err)
+    }
+
+    entries := []firewallEntry{
+        {"198.51.100.1", time.Hour},
+        {"198.51.100.2", time.Minute},
+        {"198.51.100.3", time.Hour},
+        {"192.0.2.1", time.Hour},
+        {"192.0.2.2", time.Hour},
+        {"192.0.2.3", time.Hour},
+        {"203.0.113.1", time.Hour},
+        {"203.0.113.2", time.Hour},
+        {"203.0.113.7", time.Hour},
+        {"2001:db8::1", time.Hour},
+    }
+    base := convertFirewallEntriesToString(entries)
+
+    tests := []struct {
+        name        string
+        min_hosts   int
+        allowlisted map[string] string
+        want        string
+    }{
+        {"never widened", 0, nil, base},
+        {
+            "busy prefixes widened for their longest timeout, " +
+              "unless allowlisted",
+            3,
+            map[string] string{"203.0.113.7": "AS64500"},
+            base + ", 198.51.100.0/24 1h0m0s",
+        },
+    }
+
+    for _, test := range tests {
+        got := widenFirewallEntries(entries, test.min_hosts, allowed,
+          test.allowlisted)
+        if convertFirewallEntriesToString(got) != test.want {
+            t.Errorf("%s: widenFirewallEntries() = %q, want %q",
+              test.name, convertFirewallEntriesToString(got), test.want)
+        }
+    }
+}
+
+func TestTargetsOverlap(t *testing.T) {
+
+    tests := []struct {
+        first  string
+        second string
+        want   bool
+    }{
+        {"192.0.2.0/24", "192.0.2.77", true},
+        {"192.0.2.0/24", "192.0.3.0/24", false},
+        {"192.0.2.0/23", "192.0.3.0/24", true},
+        {"2001:db8::/32", "2001:db8:1::1", true},
+        {"0.0.0.0/0", "::/0", false},
+        {"192.0.2.1", "not-an-ip", false},
+    }
+
+    for _, test := range tests {
+        got := targetsOverlap(test.first, test.second)
+        if got != test.want {
+            t.Errorf("targetsOverlap(%s, %s) = %v, want %v", test.first,
+              test.second, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,269 @@
+//
+// Per country summary functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+//! Traffic totals of every address of a given country
+type countryStats struct {
+    code     string
+    ips      int
+    requests int
+    parsed   int
+    errors   int
+    blocked  int
+}
+
+//! Group the IP address counts by country
+/*
+ * @param     map               string map containing ip addresses and counts
+ * @param     map               string map containing ip/whois country data
+ * @param     map               map of ip addresses and their statistics
+ * @param     string[]          addresses that are to be blocked
+ *
+ * @return    countryStats[]    countries, most requests first
+ */
+func aggregateCountries(ip_map map[string] int,
+  whois_country_map map[string] string, stats_map map[string] *ipStats,
+  blocked []string) []countryStats {
+
+    // variable declaration
+    var country_map = make(map[string] *countryStats)
+    var result = make([]countryStats, 0)
+
+    // for every IP address...
+    for ip, count := range ip_map {
+
+        // fallback to "--" if the country code is unknown
+        code := whois_country_map[ip]
+        if len(code) != 2 || code == ".." {
+            code = "--"
+        }
+
+        country, exists := country_map[code]
+        if !exists {
+            country = &countryStats{code: code}
+            country_map[code] = country
+        }
+
+        country.ips++
+        country.requests += count
+        if stats, exists := stats_map[ip]; exists {
+            country.parsed += stats.requests
+            country.errors += stats.errors
+        }
+        if isStringInArray(ip, blocked) {
+            country.blocked++
+        }
+    }
+
+    for _, country := range country_map {
+        result = append(result, *country)
+    }
+
+    // sort by requests, then code, so that the order is always the same
+    // between runs
+    sort.Slice(result, func(i, j int) bool {
+        if result[i].requests != result[j].requests {
+            return result[i].requests > result[j].requests
+        }
+        return result[i].code < result[j].code
+    })
+
+    return result
+}
+
+//! Read the country request counts of the previous periods
+/*
+ * The file holds the counts of the most recent period, and the period
+ * before it, like so:
+ *
+ * current 18/Oct/2017
+ * US 1234
+ * previous 17/Oct/2017
+ * US 1000
+ *
+ * @param     string    /path/to/file
+ *
+ * @return    string    period of the current counts
+ * @return    map       current string map of country codes and requests
+ * @return    string    period of the previous counts
+ * @return    map       previous string map of country codes and requests
+ */
+func readCountryCounts(path string) (string, map[string] int, string,
+  map[string] int) {
+
+    // variable declaration
+    var periods = []string{"", ""}
+    var counts = []map[string] int{make(map[string] int),
+      make(map[string] int)}
+    var section = -1
+
+    // a missing file simply means there is no history yet
+    byte_contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return "", counts[0], "", counts[1]
+    }
+
+    for _, line := range strings.Split(string(byte_contents), "\n") {
+
+        pieces := strings.Fields(line)
+        if len(pieces) != 2 {
+            continue
+        }
+
+        switch pieces[0] {
+        case "current":
+            section = 0
+            periods[0] = pieces[1]
+        case "previous":
+            section = 1
+            periods[1] = pieces[1]
+        default:
+            value, err := strconv.Atoi(pieces[1])
+            if err != nil || section < 0 {
+                continue
+            }
+            counts[section][pieces[0]] = value
+        }
+    }
+
+    return periods[0], counts[0], periods[1], counts[1]
+}
+
+//! Write the country request counts of the current and previous period
+/*
+ * @param     string    /path/to/file
+ * @param     string    period of the current counts
+ * @param     map       current string map of country codes and requests
+ * @param     string    period of the previous counts
+ * @param     map       previous string map of country codes and requests
+ *
+ * @return    error     error message, if any
+ */
+func writeCountryCounts(path string, period string, counts map[string] int,
+  previous_period string, previous map[string] int) error {
+
+    // variable declaration
+    var contents string = ""
+
+    contents += "current " + period + "\n"
+    for _, pair := range sortMapByCount(counts) {
+        contents += pair.name + " " + strconv.Itoa(pair.count) + "\n"
+    }
+
+    if len(previous_period) > 0 {
+        contents += "previous " + previous_period + "\n"
+        for _, pair := range sortMapByCount(previous) {
+            contents += pair.name + " " + strconv.Itoa(pair.count) + "\n"
+        }
+    }
+
+    return ioutil.WriteFile(path, []byte(contents), 0644)
+}
+
+//! Assemble the contents of the countries log
+/*
+ * @param     map       string map containing ip addresses and counts
+ * @param     map       string map containing ip/whois country data
+ * @param     map       map of ip addresses and their statistics
+ * @param     string[]  addresses that are to be blocked
+ * @param     string    period of the data; e.g. 18/Oct/2017
+ * @param     string    /path/to/file holding the counts of past periods
+ *
+ * @return    string    country report
+ * @return    error     error message, if any
+ */
+func obtainCountryReport(ip_map map[string] int,
+  whois_country_map map[string] string, stats_map map[string] *ipStats,
+  blocked []string, period string, history_path string) (string, error) {
+
+    // variable declaration
+    var country_strings string = ""
+    var counts = make(map[string] int)
+
+    countries := aggregateCountries(ip_map, whois_country_map, stats_map,
+      blocked)
+
+    // if no countries present, append a line about there being no data
+    if len(countries) < 1 {
+        return "No countries listed at this time.", nil
+    }
+
+    // obtain the counts of the previous period; if this period was
+    // already recorded by an earlier run today, then the previous
+    // period is the one recorded before it
+    stored_period, stored, previous_period, previous :=
+      readCountryCounts(history_path)
+    if stored_period != period {
+        previous_period, previous = stored_period, stored
+    }
+
+    // the largest country determines the scale of the bars
+    max := countries[0].requests
+
+    country_strings += fmt.Sprintf("%-2s | %5s | %8s | %6s | %7s | " +
+      "%8s | %s\n", "", "ips", "requests", "errors", "blocked",
+      "previous", "")
+
+    for _, country := range countries {
+
+        counts[country.code] = country.requests
+
+        // error rate of the requests that could be parsed
+        error_rate := "-"
+        if country.parsed > 0 {
+            error_rate = fmt.Sprintf("%.1f%%",
+              100 * float64(country.errors) / float64(country.parsed))
+        }
+
+        // compare against the previous period, to spot surges
+        previous_count := "-"
+        surge := ""
+        if len(previous_period) > 0 {
+            previous_count = strconv.Itoa(previous[country.code])
+            if previous[country.code] < 1 {
+                surge = " (new)"
+            } else if country.requests >= 2 * previous[country.code] {
+                surge = fmt.Sprintf(" (x%.1f)", float64(country.requests) /
+                  float64(previous[country.code]))
+            }
+        }
+
+        country_strings += fmt.Sprintf("%-2s | %5d | %8d | %6s | %7d | " +
+          "%8s | %s%s\n", country.code, country.ips, country.requests,
+          error_rate, country.blocked, previous_count,
+          asciiBar(country.requests, max, 40), surge)
+    }
+
+    if len(previous_period) > 0 {
+        country_strings += "\nPrevious period: " + previous_period + "\n"
+    }
+
+    // record the counts of this period for the next run, unless this is
+    // a dry run, which leaves the state as it was
+    if !dryRun {
+        err := writeCountryCounts(history_path, period, counts,
+          previous_period, previous)
+        if err != nil {
+            return country_strings, err
+        }
+    }
+
+    // everything worked fine, so return the completed string contents
+    return country_strings, nil
+}
@@ -0,0 +1,129 @@
+//
+// Per country summary tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestAggregateCountries(t *testing.T) {
+
+    ip_map := map[string] int{
+        "192.0.2.1":    10,
+        "192.0.2.2":    5,
+        "198.51.100.1": 15,
+        "203.0.113.1":  3,
+        "203.0.113.2":  2,
+        "203.0.113.3":  1,
+        "2001:db8::1":  1,
+    }
+    countries := map[string] string{
+        "192.0.2.1":    "NL",
+        "192.0.2.2":    "NL",
+        "198.51.100.1": "US",
+        "203.0.113.1":  "unknown",
+        "203.0.113.2":  "..",
+        "2001:db8::1":  "DE",
+    }
+    stats := map[string] *ipStats{
+        "192.0.2.1": {requests: 9, errors: 2},
+        "192.0.2.2": {requests: 5, errors: 1},
+    }
+    blocked := []string{"192.0.2.2", "203.0.113.3"}
+
+    tests := []struct {
+        name      string
+        ip_map    map[string] int
+        countries map[string] string
+        want      string
+    }{
+        {"no addresses", nil, countries, "[]"},
+        {"no countries known", map[string] int{"192.0.2.1": 4}, nil,
+          "[{-- 1 4 9 2 0}]"},
+        {
+            "most requests first, unknown codes grouped, ties by code",
+            ip_map, countries,
+            "[{NL 2 15 14 3 1} {US 1 15 0 0 0} {-- 3 6 0 0 1} " +
+              "{DE 1 1 0 0 0}]",
+        },
+    }
+
+    for _, test := range tests {
+        got := fmt.Sprint(aggregateCountries(test.ip_map, test.countries,
+          stats, blocked))
+        if got != test.want {
+            t.Errorf("%s: countries = %s, want %s", test.name, got,
+              test.want)
+        }
+    }
+}
+
+func TestCountryCountsRoundTrip(t *testing.T) {
+
+    path := filepath.Join(t.TempDir(), "countries.history")
+
+    // a missing file is an empty history
+    period, counts, previous_period, previous := readCountryCounts(path)
+    if period != "" || previous_period != "" || len(counts) != 0 ||
+      len(previous) != 0 {
+        t.Errorf("missing file read as %q %v %q %v", period, counts,
+          previous_period, previous)
+    }
+
+    tests := []struct {
+        name            string
+        period          string
+        counts          map[string] int
+        previous_period string
+        previous        map[string] int
+    }{
+        {"both periods", "18/Oct/2017", map[string] int{"US": 12, "NL": 3},
+          "17/Oct/2017", map[string] int{"US": 10, "--": 1}},
+        {"first period", "18/Oct/2017", map[string] int{"US": 12}, "",
+          map[string] int{}},
+        {"no requests", "18/Oct/2017", map[string] int{}, "17/Oct/2017",
+          map[string] int{"DE": 1}},
+    }
+
+    for _, test := range tests {
+
+        err := writeCountryCounts(path, test.period, test.counts,
+          test.previous_period, test.previous)
+        if err != nil {
+            t.Fatalf("%s: unable to write: %v", test.name, err)
+        }
+        period, counts, previous_period, previous = readCountryCounts(path)
+        if period != test.period || previous_period != test.previous_period ||
+          !reflect.DeepEqual(counts, test.counts) ||
+          !reflect.DeepEqual(previous, test.previous) {
+            t.Errorf("%s: read back %q %v %q %v", test.name, period, counts,
+              previous_period, previous)
+        }
+    }
+
+    // improper lines, and counts outside of a period, are skipped
+    err := ioutil.WriteFile(path, []byte("US 5\ncurrent 18/Oct/2017\n" +
+      "NL many\nDE 2 3\n\nFR 4\n"), 0644)
+    if err != nil {
+        t.Fatalf("unable to write: %v", err)
+    }
+    period, counts, previous_period, previous = readCountryCounts(path)
+    if period != "18/Oct/2017" || previous_period != "" ||
+      !reflect.DeepEqual(counts, map[string] int{"FR": 4}) ||
+      len(previous) != 0 {
+        t.Errorf("improper file read as %q %v %q %v", period, counts,
+          previous_period, previous)
+    }
+}
@@ -0,0 +1,317 @@
+//
+// Search engine crawler verification functions for ASCII-log
+//
+// Anyone can claim to be Googlebot in their user agent, so a claim is only
+// trusted if the reverse DNS of the address ends in one of the domains of
+// that crawler, and the forward DNS of that hostname leads back to the very
+// same address; i.e. forward-confirmed reverse DNS.
+//
+// A claim is only deemed fake once the DNS answers show that it does not
+// match; if the lookups fail, e.g. time out, the claim is left unverified,
+// so that a real crawler is not blocked whenever the resolver misbehaves.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "fmt"
+    "net"
+    "sort"
+    "strings"
+)
+
+// Results of a crawler verification.
+const (
+    crawlerVerified   = "verified"
+    crawlerFake       = "fake"
+    crawlerUnverified = "unverified"
+)
+
+// DNS lookup functions, kept as variables so that they can be swapped out
+// for a stand-in resolver.
+var (
+    lookupAddr = net.DefaultResolver.LookupAddr
+    lookupHost = net.DefaultResolver.LookupHost
+)
+
+//! A search engine crawler, as named in the user agent, and its domains
+type crawlerRule struct {
+    token    string
+    suffixes []string
+}
+
+//! Outcome of verifying a claimed crawler
+type crawlerCheck struct {
+    name     string
+    hostname string
+    status   string
+}
+
+//! Parse the list of crawlers and their reverse DNS domains
+/*
+ * @param     string           list of "token=domain|domain" entries,
+ *                             comma separated
+ *
+ * @return    crawlerRule[]    array of crawler rules
+ * @return    error            error message, if any
+ */
+func parseCrawlerRules(list string) ([]crawlerRule, error) {
+
+    // variable declaration
+    var rules = make([]crawlerRule, 0)
+
+    for _, piece := range strings.Split(list, ",") {
+
+        piece = strings.TrimSpace(piece)
+        if len(piece) < 1 {
+            continue
+        }
+
+        // each entry needs a user agent token and at least one domain
+        halves := strings.SplitN(piece, "=", 2)
+        if len(halves) != 2 || len(halves[0]) < 1 || len(halves[1]) < 1 {
+            return nil, fmt.Errorf("parseCrawlerRules() --> improper " +
+              "entry: %s", piece)
+        }
+
+        rule := crawlerRule{token: strings.TrimSpace(halves[0])}
+        for _, suffix := range strings.Split(halves[1], "|") {
+            suffix = strings.Trim(strings.TrimSpace(suffix), ".")
+            if len(suffix) > 0 {
+                rule.suffixes = append(rule.suffixes,
+                  strings.ToLower(suffix))
+            }
+        }
+        rules = append(rules, rule)
+    }
+
+    return rules, nil
+}
+
+//! Determine which crawler, if any, a user agent claims to be
+/*
+ * @param     string           user agent
+ * @param     crawlerRule[]    array of crawler rules
+ *
+ * @return    int              index of the claimed crawler, or -1 if none
+ */
+func obtainClaimedCrawler(user_agent string, rules []crawlerRule) int {
+
+    lowered := strings.ToLower(user_agent)
+    for i, rule := range rules {
+        if strings.Contains(lowered, strings.ToLower(rule.token)) {
+            return i
+        }
+    }
+    return -1
+}
+
+//! Check whether a hostname belongs to one of the given domains
+/*
+ * @param     string      hostname; e.g. crawl-1-2-3-4.googlebot.com.
+ * @param     string[]    domains; e.g. googlebot.com
+ *
+ * @return    bool        whether or not the hostname is within a domain
+ */
+func hostnameHasSuffix(hostname string, suffixes []string) bool {
+
+    hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+    for _, suffix := range suffixes {
+        if hostname == suffix || strings.HasSuffix(hostname, "." + suffix) {
+            return true
+        }
+    }
+    return false
+}
+
+//! Check whether a DNS error is a definite answer that the name does not
+//! exist, rather than a failure to get an answer at all
+/*
+ * @param     error    error of a DNS lookup
+ *
+ * @return    bool     whether or not the name is known not to exist
+ */
+func isDnsNotFound(err error) bool {
+
+    dns_err, ok := err.(*net.DNSError)
+    return ok && dns_err.IsNotFound
+}
+
+//! Forward-confirm the reverse DNS of an IP address against some domains
+/*
+ * @param     Context     context of the lookups
+ * @param     string      IP address
+ * @param     string[]    domains the hostname has to be within
+ *
+ * @return    string      confirmed hostname, or blank if none
+ * @return    error       error message, if no hostname was confirmed and a
+ *                        lookup failed, so that the answer is unknown
+ */
+func forwardConfirmHostname(ctx context.Context, ip string,
+  suffixes []string) (string, error) {
+
+    // variable declaration
+    var failure error = nil
+
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return "", nil
+    }
+
+    // reverse lookup; an address without a hostname cannot be confirmed
+    hostnames, err := lookupAddr(ctx, ip)
+    if err != nil && isDnsNotFound(err) {
+        return "", nil
+    } else if err != nil {
+        return "", err
+    }
+
+    for _, hostname := range hostnames {
+
+        // the hostname has to be within one of the domains
+        if !hostnameHasSuffix(hostname, suffixes) {
+            continue
+        }
+
+        // and it has to resolve back to the same address
+        addresses, err := lookupHost(ctx, strings.TrimSuffix(hostname, "."))
+        if err != nil {
+            if !isDnsNotFound(err) {
+                failure = err
+            }
+            continue
+        }
+        for _, address := range addresses {
+            if parsed.Equal(net.ParseIP(address)) {
+                return strings.TrimSuffix(hostname, "."), nil
+            }
+        }
+    }
+
+    return "", failure
+}
+
+//! Verify every IP address that claims to be a search engine crawler
+/*
+ * @param     Context          context of the lookups
+ * @param     map              map of ip addresses and their statistics
+ * @param     crawlerRule[]    array of crawler rules
+ *
+ * @return    map              map of ip addresses and verification results
+ */
+func obtainCrawlerChecks(ctx context.Context, stats_map map[string] *ipStats,
+  rules []crawlerRule) map[string] crawlerCheck {
+
+    // variable declaration
+    var checks = make(map[string] crawlerCheck)
+    var ips = make([]string, 0)
+    var suffixes = make([][]string, 0)
+    var claims = make([]int, 0)
+    var candidates = make([]string, 0, len(stats_map))
+
+    for ip, _ := range stats_map {
+        candidates = append(candidates, ip)
+    }
+    sort.Strings(candidates)
+
+    for _, ip := range candidates {
+
+        // the most frequent claim of an address decides which crawler it
+        // is checked against
+        claimed := -1
+        claimed_count := 0
+        for _, pair := range sortMapByCount(stats_map[ip].user_agents) {
+            index := obtainClaimedCrawler(pair.name, rules)
+            if index >= 0 && pair.count > claimed_count {
+                claimed = index
+                claimed_count = pair.count
+            }
+        }
+        if claimed < 0 {
+            continue
+        }
+
+        ips = append(ips, ip)
+        suffixes = append(suffixes, rules[claimed].suffixes)
+        claims = append(claims, claimed)
+    }
+
+    // the DNS lookups are made by the pool of workers
+    hostnames, errs := obtainForwardConfirmations(ctx, ips, suffixes)
+
+    for i, ip := range ips {
+        check := crawlerCheck{name: rules[claims[i]].token,
+          hostname: hostnames[i], status: crawlerFake}
+        if len(hostnames[i]) > 0 {
+            check.status = crawlerVerified
+        } else if errs[i] != nil {
+            check.status = crawlerUnverified
+        }
+        checks[ip] = check
+    }
+
+    return checks
+}
+
+//! Convert the crawler verification results into the crawlers log
+/*
+ * @param     map       map of ip addresses and verification results
+ *
+ * @return    string    crawler report
+ */
+func convertCrawlerChecksToString(checks map[string] crawlerCheck) string {
+
+    // variable declaration
+    var crawler_strings string = ""
+    var ips = make([]string, 0, len(checks))
+
+    // if no crawlers present, append a line about there being no data
+    if len(checks) < 1 {
+        return "No crawlers listed at this time."
+    }
+
+    for ip, _ := range checks {
+        ips = append(ips, ip)
+    }
+    sort.Strings(ips)
+
+    for _, status := range []string{crawlerVerified, crawlerFake,
+      crawlerUnverified} {
+
+        if status == crawlerVerified {
+            crawler_strings += "Verified Crawlers (exempt from blocking)\n\n"
+        } else if status == crawlerFake {
+            crawler_strings += "\nFake Crawlers (blocked)\n\n"
+        } else {
+            crawler_strings += "\nUnverified Crawlers (DNS lookups " +
+              "failed, retried next run)\n\n"
+        }
+
+        listed := 0
+        for _, ip := range ips {
+            check := checks[ip]
+            if check.status != status {
+                continue
+            }
+            hostname := check.hostname
+            if len(hostname) < 1 {
+                hostname = "N/A"
+            }
+            crawler_strings += fmt.Sprintf("%-39s | %-12s | %s\n", ip,
+              check.name, hostname)
+            listed++
+        }
+        if listed < 1 {
+            crawler_strings += "None.\n"
+        }
+    }
+
+    return crawler_strings
+}
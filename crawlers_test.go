@@ -0,0 +1,180 @@
+//
+// Search engine crawler verification tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "net"
+    "testing"
+    "time"
+)
+
+//! Swap the DNS lookups for canned answers, until the test is over
+/*
+ * Names without an answer are not found; an answer of "timeout" or
+ * "servfail" fails the lookup instead, and "hang" blocks until the lookup
+ * is given up on.
+ *
+ * @param     T*     test state
+ * @param     map    map of ip addresses and their hostnames
+ * @param     map    map of hostnames and their addresses
+ */
+func stubDnsLookups(t *testing.T, ptr map[string] []string,
+  hosts map[string] []string) {
+
+    saved_addr, saved_host := lookupAddr, lookupHost
+    t.Cleanup(func() { lookupAddr, lookupHost = saved_addr, saved_host })
+
+    answer := func(ctx context.Context, records map[string] []string,
+      name string) ([]string, error) {
+
+        answers, exists := records[name]
+        if !exists {
+            return nil, &net.DNSError{Err: "no such host", Name: name,
+              IsNotFound: true}
+        }
+        if len(answers) == 1 {
+            switch answers[0] {
+            case "timeout":
+                return nil, &net.DNSError{Err: "i/o timeout", Name: name,
+                  IsTimeout: true}
+            case "servfail":
+                return nil, &net.DNSError{Err: "server misbehaving",
+                  Name: name, IsTemporary: true}
+            case "hang":
+                <-ctx.Done()
+                return nil, ctx.Err()
+            }
+        }
+        return answers, nil
+    }
+
+    lookupAddr = func(ctx context.Context, ip string) ([]string, error) {
+        return answer(ctx, ptr, ip)
+    }
+    lookupHost = func(ctx context.Context, host string) ([]string, error) {
+        return answer(ctx, hosts, host)
+    }
+}
+
+func TestForwardConfirmHostname(t *testing.T) {
+
+    stubDnsLookups(t, map[string] []string{
+        "66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+        "66.249.66.2":  {"mail.example.com.", "crawl-2.googlebot.com."},
+        "192.0.2.1":    {"evil.example.com."},
+        "192.0.2.3":    {"timeout"},
+        "192.0.2.4":    {"crawl-4.googlebot.com."},
+        "192.0.2.5":    {"crawl-5.googlebot.com."},
+    }, map[string] []string{
+        "crawl-66-249-66-1.googlebot.com": {"66.249.66.1"},
+        "crawl-2.googlebot.com":           {"66.249.66.2"},
+        "crawl-4.googlebot.com":           {"servfail"},
+        "crawl-5.googlebot.com":           {"66.249.66.99"},
+    })
+
+    tests := []struct {
+        ip       string
+        hostname string
+        fails    bool
+    }{
+        {ip: "66.249.66.1", hostname: "crawl-66-249-66-1.googlebot.com"},
+        {ip: "66.249.66.2", hostname: "crawl-2.googlebot.com"},
+        {ip: "192.0.2.1"},
+        {ip: "192.0.2.2"},
+        {ip: "192.0.2.3", fails: true},
+        {ip: "192.0.2.4", fails: true},
+        {ip: "192.0.2.5"},
+        {ip: "not-an-ip"},
+    }
+
+    for _, test := range tests {
+        hostname, err := forwardConfirmHostname(context.Background(),
+          test.ip, []string{"googlebot.com", "google.com"})
+        if hostname != test.hostname || (err != nil) != test.fails {
+            t.Errorf("forwardConfirmHostname(%s) = %q, %v", test.ip,
+              hostname, err)
+        }
+    }
+}
+
+func TestObtainCrawlerChecks(t *testing.T) {
+
+    saved_timeout := lookupTimeout
+    lookupTimeout = 100 * time.Millisecond
+    defer func() { lookupTimeout = saved_timeout }()
+
+    stubDnsLookups(t, map[string] []string{
+        "66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+        "157.55.39.1":  {"msnbot-157-55-39-1.search.msn.com."},
+        "192.0.2.1":    {"evil.example.com."},
+        "192.0.2.3":    {"timeout"},
+        "192.0.2.4":    {"hang"},
+    }, map[string] []string{
+        "crawl-66-249-66-1.googlebot.com":   {"66.249.66.1"},
+        "msnbot-157-55-39-1.search.msn.com": {"157.55.39.1"},
+    })
+
+    rules, err := parseCrawlerRules("Googlebot=googlebot.com|google.com, " +
+      "bingbot=search.msn.com")
+    if err != nil {
+        t.Fatalf("unable to parse the crawler rules: %v", err)
+    }
+
+    googlebot := "Mozilla/5.0 (compatible; Googlebot/2.1; " +
+      "+http://www.google.com/bot.html)"
+    bingbot := "Mozilla/5.0 (compatible; bingbot/2.0; " +
+      "+http://www.bing.com/bingbot.htm)"
+    claims := func(user_agents map[string] int) *ipStats {
+        return &ipStats{user_agents: user_agents}
+    }
+
+    stats_map := map[string] *ipStats{
+        "66.249.66.1": claims(map[string] int{googlebot: 3}),
+        "157.55.39.1": claims(map[string] int{bingbot: 2,
+          "curl/8.0": 1}),
+        "192.0.2.1":   claims(map[string] int{googlebot: 1}),
+        "192.0.2.2":   claims(map[string] int{bingbot: 1}),
+        "192.0.2.3":   claims(map[string] int{googlebot: 1}),
+        "192.0.2.4":   claims(map[string] int{googlebot: 1}),
+        "192.0.2.9":   claims(map[string] int{"Mozilla/5.0": 9}),
+    }
+
+    want := map[string] string{
+        "66.249.66.1": crawlerVerified,
+        "157.55.39.1": crawlerVerified,
+        "192.0.2.1":   crawlerFake,
+        "192.0.2.2":   crawlerFake,
+        "192.0.2.3":   crawlerUnverified,
+        "192.0.2.4":   crawlerUnverified,
+    }
+
+    start := time.Now()
+    checks := obtainCrawlerChecks(context.Background(), stats_map, rules)
+    if time.Since(start) > 2 * time.Second {
+        t.Errorf("a hanging lookup held up the checks for %v",
+          time.Since(start))
+    }
+
+    if len(checks) != len(want) {
+        t.Errorf("checks = %+v", checks)
+    }
+    for ip, status := range want {
+        if checks[ip].status != status {
+            t.Errorf("%s: status = %q, want %q", ip, checks[ip].status,
+              status)
+        }
+    }
+    if checks["157.55.39.1"].name != "bingbot" ||
+      checks["66.249.66.1"].hostname != "crawl-66-249-66-1.googlebot.com" {
+        t.Errorf("checks = %+v", checks)
+    }
+}
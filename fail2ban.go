@@ -0,0 +1,250 @@
+//
+// fail2ban output functions for ASCII-log
+//
+// Rather than managing the firewall itself, this program can hand its
+// decisions over to fail2ban. Every block is appended to an event log:
+//
+// 2026-01-02 03:04:05 ascii-log blocked ip=192.0.2.1 reason=redirects
+//
+// which the generated filter.d and jail.d files have fail2ban watch. The
+// addresses can also be banned straight away with fail2ban-client.
+//
+// fail2ban decides the length of its bans itself, so the jail is given the
+// escalation of the block ledger as bantime.multipliers; fail2ban counts
+// the earlier bans from its own database, rather than from the ledger.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Layout of the timestamps of the fail2ban event log.
+const fail2banTimeLayout = "2006-01-02 15:04:05"
+
+//! Assemble the event log lines of the given blocks
+/*
+ * @param     blockEntry[]    blocks made or renewed this run
+ * @param     Time            current time
+ *
+ * @return    string          event log lines
+ */
+func assembleFail2banEvents(blocks []*blockEntry, now time.Time) string {
+
+    // variable declaration
+    var events string = ""
+
+    for _, block := range blocks {
+
+        // the reason is the last field, and kept to a single word so the
+        // filter stays simple
+        reason := strings.Join(strings.Fields(block.reason), "-")
+        if len(reason) < 1 {
+            reason = "-"
+        }
+
+        events += now.Format(fail2banTimeLayout) + " ascii-log blocked ip=" +
+          block.target + " reason=" + reason + "\n"
+    }
+
+    return events
+}
+
+//! Append event log lines to the fail2ban event log
+/*
+ * @param     string    /path/to/event.log
+ * @param     string    event log lines
+ *
+ * @return    error     error message, if any
+ */
+func appendFail2banEvents(path string, events string) error {
+
+    if len(events) < 1 {
+        return nil
+    }
+
+    file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+      0644)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    _, err = file.WriteString(events)
+    return err
+}
+
+//! Assemble the filter.d config matching the event log
+/*
+ * @return    string    filter config
+ */
+func assembleFail2banFilter() string {
+
+    // variable declaration
+    var config string = ""
+
+    config += "# Generated by ascii-log\n"
+    config += "[Definition]\n"
+    config += "datepattern = ^%%Y-%%m-%%d %%H:%%M:%%S\n"
+    config += "failregex = ^\\s*ascii-log blocked ip=<SUBNET> reason=\\S+$\n"
+    config += "ignoreregex =\n"
+
+    return config
+}
+
+//! Assemble the bantime multipliers matching the escalation of the ledger
+/*
+ * @param     Duration    base length of a ban
+ * @param     float64     factor to multiply by for every repeat offence
+ * @param     Duration    longest allowed ban, or 0 for no limit
+ *
+ * @return    string      space separated multipliers; e.g. "1 2 4 8", or
+ *                        blank if bans are never escalated
+ */
+func assembleFail2banMultipliers(ban_time time.Duration, factor float64,
+  max_ttl time.Duration) string {
+
+    // variable declaration
+    var multipliers = make([]string, 0)
+
+    if factor <= 1 || ban_time <= 0 {
+        return ""
+    }
+
+    // the last multiplier applies to every later ban, so stop once the
+    // longest ban is reached
+    for offences := 1; offences <= 20; offences++ {
+        ttl := obtainEscalatedTTL(ban_time, offences, factor, max_ttl)
+        multipliers = append(multipliers, strconv.FormatFloat(
+          float64(ttl) / float64(ban_time), 'g', 6, 64))
+        if max_ttl > 0 && ttl >= max_ttl {
+            break
+        }
+    }
+
+    return strings.Join(multipliers, " ")
+}
+
+//! Assemble the jail.d config watching the event log
+/*
+ * @param     string      jail name, also used as the filter name
+ * @param     string      /path/to/event.log
+ * @param     Duration    base length of a ban
+ * @param     float64     factor to multiply by for every repeat offence
+ * @param     Duration    longest allowed ban, or 0 for no limit
+ *
+ * @return    string      jail config
+ */
+func assembleFail2banJail(jail string, log_path string,
+  ban_time time.Duration, factor float64, max_ttl time.Duration) string {
+
+    // variable declaration
+    var config string = ""
+
+    // every event is a decision already, so a single one is enough
+    config += "# Generated by ascii-log\n"
+    config += "[" + jail + "]\n"
+    config += "enabled = true\n"
+    config += "filter = " + jail + "\n"
+    config += "logpath = " + log_path + "\n"
+    config += "maxretry = 1\n"
+    config += "findtime = 1d\n"
+    config += fmt.Sprintf("bantime = %d\n", int64(ban_time / time.Second))
+
+    // repeat offenders are banned for longer, as in the ledger
+    multipliers := assembleFail2banMultipliers(ban_time, factor, max_ttl)
+    if len(multipliers) > 0 {
+        config += "bantime.increment = true\n"
+        config += "bantime.multipliers = " + multipliers + "\n"
+        if max_ttl > 0 {
+            config += fmt.Sprintf("bantime.maxtime = %d\n",
+              int64(max_ttl / time.Second))
+        }
+    }
+
+    return config
+}
+
+//! Write the filter.d and jail.d configs, then reload fail2ban if they
+//! changed
+/*
+ * @param     commandRunner    runs fail2ban-client
+ * @param     string           fail2ban-client binary
+ * @param     string           /path/to/fail2ban config directory
+ * @param     string           jail name
+ * @param     string           /path/to/event.log
+ * @param     Duration         base length of a ban
+ * @param     float64          factor to multiply by for every repeat offence
+ * @param     Duration         longest allowed ban, or 0 for no limit
+ * @param     bool             whether to print the files, not write them
+ * @param     string           /path/to/history, to record changes in
+ *
+ * @return    error            error message, if any
+ */
+func writeFail2banConfig(runner commandRunner, client string, dir string,
+  jail string, log_path string, ban_time time.Duration, factor float64,
+  max_ttl time.Duration, dry_run bool, history_path string) error {
+
+    dir = strings.TrimSuffix(dir, "/")
+
+    // the filter is only checked, fail2ban is reloaded once the jail is
+    // in place as well
+    filter := &managedConfigFile{runner: runner, dry_run: dry_run,
+      path: dir + "/filter.d/" + jail + ".conf",
+      test_command: []string{client, "-t"}, history_path: history_path}
+    jail_file := &managedConfigFile{runner: runner, dry_run: dry_run,
+      path: dir + "/jail.d/" + jail + ".conf",
+      test_command: []string{client, "-t"}, history_path: history_path}
+
+    filter_changed, err := filter.apply(assembleFail2banFilter())
+    if err != nil {
+        return err
+    }
+    jail_changed, err := jail_file.apply(assembleFail2banJail(jail,
+      log_path, ban_time, factor, max_ttl))
+    if err != nil {
+        return err
+    }
+
+    if filter_changed || jail_changed {
+        _, err = runner.run(client, []string{"reload"}, "")
+    }
+    return err
+}
+
+//! Ban addresses in a fail2ban jail straight away; the length of the bans
+//! is up to the jail, as fail2ban-client cannot set it per address
+/*
+ * @param     commandRunner    runs fail2ban-client
+ * @param     string           fail2ban-client binary
+ * @param     string           jail name
+ * @param     blockEntry[]     blocks made or renewed this run
+ *
+ * @return    error            error message, if any
+ */
+func banWithFail2ban(runner commandRunner, client string, jail string,
+  blocks []*blockEntry) error {
+
+    if len(blocks) < 1 {
+        return nil
+    }
+
+    args := []string{"set", jail, "banip"}
+    for _, block := range blocks {
+        args = append(args, block.target)
+    }
+
+    _, err := runner.run(client, args, "")
+    return err
+}
@@ -0,0 +1,188 @@
+//
+// fail2ban output tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestAssembleFail2banEvents(t *testing.T) {
+
+    now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+    blocks := []*blockEntry{
+        {target: "192.0.2.1", reason: "redirects"},
+        {target: "2001:db8::/64", reason: "manual block"},
+        {target: "198.51.100.0/24"},
+    }
+
+    want := "2026-01-02 03:04:05 ascii-log blocked ip=192.0.2.1 " +
+      "reason=redirects\n" +
+      "2026-01-02 03:04:05 ascii-log blocked ip=2001:db8::/64 " +
+      "reason=manual-block\n" +
+      "2026-01-02 03:04:05 ascii-log blocked ip=198.51.100.0/24 " +
+      "reason=-\n"
+
+    got := assembleFail2banEvents(blocks, now)
+    if got != want {
+        t.Errorf("events:\n%s\nwant:\n%s", got, want)
+    }
+}
+
+func TestAssembleFail2banMultipliers(t *testing.T) {
+
+    tests := []struct {
+        ban_time time.Duration
+        factor   float64
+        max_ttl  time.Duration
+        want     string
+    }{
+        {time.Hour, 2, 8 * time.Hour, "1 2 4 8"},
+        {time.Hour, 2, 6 * time.Hour, "1 2 4 6"},
+        {time.Hour, 1.5, 2 * time.Hour, "1 1.5 2"},
+        {time.Hour, 1, 8 * time.Hour, ""},
+        {0, 2, 8 * time.Hour, ""},
+    }
+
+    for _, test := range tests {
+        got := assembleFail2banMultipliers(test.ban_time, test.factor,
+          test.max_ttl)
+        if got != test.want {
+            t.Errorf("assembleFail2banMultipliers(%v, %v, %v) = %q, " +
+              "want %q", test.ban_time, test.factor, test.max_ttl, got,
+              test.want)
+        }
+    }
+}
+
+func TestAssembleFail2banJail(t *testing.T) {
+
+    tests := []struct {
+        name    string
+        factor  float64
+        max_ttl time.Duration
+        want    string
+    }{
+        {
+            name:    "fixed bans",
+            factor:  1,
+            want:    "# Generated by ascii-log\n" +
+              "[ascii-log]\n" +
+              "enabled = true\n" +
+              "filter = ascii-log\n" +
+              "logpath = /var/log/ascii-log/events.log\n" +
+              "maxretry = 1\n" +
+              "findtime = 1d\n" +
+              "bantime = 3600\n",
+        },
+        {
+            name:    "escalating bans",
+            factor:  2,
+            max_ttl: 4 * time.Hour,
+            want:    "# Generated by ascii-log\n" +
+              "[ascii-log]\n" +
+              "enabled = true\n" +
+              "filter = ascii-log\n" +
+              "logpath = /var/log/ascii-log/events.log\n" +
+              "maxretry = 1\n" +
+              "findtime = 1d\n" +
+              "bantime = 3600\n" +
+              "bantime.increment = true\n" +
+              "bantime.multipliers = 1 2 4\n" +
+              "bantime.maxtime = 14400\n",
+        },
+    }
+
+    for _, test := range tests {
+        got := assembleFail2banJail("ascii-log",
+          "/var/log/ascii-log/events.log", time.Hour, test.factor,
+          test.max_ttl)
+        if got != test.want {
+            t.Errorf("%s: jail:\n%s\nwant:\n%s", test.name, got, test.want)
+        }
+    }
+}
+
+func TestWriteFail2banConfig(t *testing.T) {
+
+    dir := t.TempDir()
+    for _, subdir := range []string{"/filter.d", "/jail.d"} {
+        err := os.MkdirAll(dir + subdir, 0755)
+        if err != nil {
+            t.Fatalf("unable to create %s: %v", subdir, err)
+        }
+    }
+
+    // the first run writes both files, tests them and reloads fail2ban
+    runner := &recordingRunner{}
+    err := writeFail2banConfig(runner, "fail2ban-client", dir + "/",
+      "ascii-log", "/tmp/events.log", time.Hour, 2, 8 * time.Hour, false,
+      "")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    checkCommandLines(t, runner.lines(), []string{"fail2ban-client -t",
+      "fail2ban-client -t", "fail2ban-client reload"})
+
+    jail, err := ioutil.ReadFile(dir + "/jail.d/ascii-log.conf")
+    if err != nil {
+        t.Fatalf("jail not written: %v", err)
+    }
+    if !strings.Contains(string(jail), "bantime.multipliers = 1 2 4 8\n") {
+        t.Errorf("jail lacks the ledger's escalation:\n%s", jail)
+    }
+
+    // an unchanged config leaves fail2ban alone
+    runner = &recordingRunner{}
+    err = writeFail2banConfig(runner, "fail2ban-client", dir, "ascii-log",
+      "/tmp/events.log", time.Hour, 2, 8 * time.Hour, false, "")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    checkCommandLines(t, runner.lines(), []string{})
+
+    // a jail that fails the config test is rolled back, and fail2ban is
+    // not reloaded
+    runner = &recordingRunner{failures: map[string] bool{
+      "fail2ban-client -t": true}}
+    err = writeFail2banConfig(runner, "fail2ban-client", dir, "ascii-log",
+      "/tmp/events.log", 2 * time.Hour, 2, 8 * time.Hour, false, "")
+    if err == nil {
+        t.Errorf("expected the failed config test to be passed along")
+    }
+    checkCommandLines(t, runner.lines(), []string{"fail2ban-client -t"})
+
+    restored, _ := ioutil.ReadFile(dir + "/jail.d/ascii-log.conf")
+    if string(restored) != string(jail) {
+        t.Errorf("jail not restored:\n%s\nwant:\n%s", restored, jail)
+    }
+}
+
+func TestBanWithFail2ban(t *testing.T) {
+
+    runner := &recordingRunner{}
+    err := banWithFail2ban(runner, "fail2ban-client", "ascii-log", nil)
+    if err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+    checkCommandLines(t, runner.lines(), []string{})
+
+    blocks := []*blockEntry{{target: "192.0.2.1"}, {target: "2001:db8::1"}}
+    err = banWithFail2ban(runner, "fail2ban-client", "ascii-log", blocks)
+    if err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+    checkCommandLines(t, runner.lines(), []string{"fail2ban-client set " +
+      "ascii-log banip 192.0.2.1 2001:db8::1"})
+}
@@ -175,3 +175,31 @@ func obtainLatestDate(line_data string) (string, error) {
     return result, nil
 }
 
+
+//! Write the given contents to a log file, creating it if needed
+/*
+ * @param     string    /path/to/filename
+ * @param     string    contents to write
+ *
+ * @return    error     error message, if any
+ */
+func writeLogFile(path string, contents string) error {
+
+    // input validation
+    if len(path) < 1 {
+        return fmt.Errorf("writeLogFile() --> invalid input")
+    }
+
+    // attempt to stat() the file, else create it if it does not
+    // currently exist
+    err := statOrCreateFile(path)
+
+    // if an error occurred during stat(), yet the program was unable
+    // to recover or recreate the file, then pass back the error
+    if err != nil {
+        return err
+    }
+
+    // attempt to write the string contents to the file
+    return ioutil.WriteFile(path, []byte(contents), 0644)
+}
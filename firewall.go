@@ -0,0 +1,256 @@
+//
+// Firewall functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "bytes"
+    "fmt"
+    "net"
+    "os/exec"
+    "sort"
+    "strings"
+    "time"
+)
+
+//! Runs external commands, such as nft; swapped out for a stand-in
+//! during dry runs, or to test against a fake binary
+type commandRunner interface {
+    run(name string, args []string, stdin string) (string, error)
+}
+
+//! Runs commands for real, via os/exec
+type execRunner struct{}
+
+//! Prints the commands that would have been run, rather than running them
+type dryRunRunner struct{}
+
+//! An address or CIDR to block, along with how long to block it for
+type firewallEntry struct {
+    target  string
+    timeout time.Duration
+}
+
+//! A firewall that can be brought in line with the current block list
+type firewallBackend interface {
+    name() string
+    sync(entries []firewallEntry) error
+}
+
+//! Execute a command, passing along stdin and capturing the output
+/*
+ * @param     string      command name or /path/to/binary
+ * @param     string[]    list of arguments
+ * @param     string      data to pass via stdin, if any
+ *
+ * @return    string      combined stdout and stderr output
+ * @return    error       error message, if any
+ */
+func (execRunner) run(name string, args []string, stdin string) (string,
+  error) {
+
+    // variable declaration
+    var output bytes.Buffer
+
+    // assemble the command from the list of string arguments
+    cmd := exec.Command(name, args...)
+    cmd.Stdin = strings.NewReader(stdin)
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+
+    // attempt to execute the command
+    err := cmd.Run()
+    if err != nil {
+        return output.String(), fmt.Errorf("%s %s --> %s: %s", name,
+          strings.Join(args, " "), err.Error(),
+          strings.TrimSpace(output.String()))
+    }
+
+    return output.String(), nil
+}
+
+//! Print a command, and its stdin, instead of executing it
+/*
+ * @param     string      command name or /path/to/binary
+ * @param     string[]    list of arguments
+ * @param     string      data to pass via stdin, if any
+ *
+ * @return    string      always blank, as if nothing was found
+ * @return    error       always nil
+ */
+func (dryRunRunner) run(name string, args []string, stdin string) (string,
+  error) {
+
+    fmt.Println("# " + name + " " + strings.Join(args, " "))
+    if len(stdin) > 0 {
+        fmt.Print(stdin)
+    }
+    return "", nil
+}
+
+//! Format a duration the way nft and ipset expect; e.g. 172800s
+/*
+ * @param     Duration    timeout
+ *
+ * @return    string      whole seconds, at least 1
+ */
+func formatFirewallTimeout(timeout time.Duration) string {
+
+    seconds := int64(timeout / time.Second)
+    if seconds < 1 {
+        seconds = 1
+    }
+    return fmt.Sprintf("%ds", seconds)
+}
+
+//! Normalize an address or CIDR, so that entries can be compared
+/*
+ * @param     string    IP address or CIDR
+ *
+ * @return    string    e.g. 10.0.0.1/32 becomes 10.0.0.1
+ */
+func normalizeFirewallTarget(target string) string {
+
+    target = strings.TrimSpace(target)
+    if _, network, err := net.ParseCIDR(target); err == nil {
+        ones, bits := network.Mask.Size()
+        if ones == bits {
+            return network.IP.String()
+        }
+        return network.String()
+    }
+    if parsed := net.ParseIP(target); parsed != nil {
+        return parsed.String()
+    }
+    return target
+}
+
+//! Drop the entries already covered by a wider CIDR of the list, since
+//! interval sets refuse overlapping elements
+/*
+ * @param     firewallEntry[]    entries to check
+ *
+ * @return    firewallEntry[]    entries that are not covered by another
+ */
+func removeCoveredEntries(entries []firewallEntry) []firewallEntry {
+
+    // variable declaration
+    var networks = make([]*net.IPNet, 0)
+    var result = make([]firewallEntry, 0, len(entries))
+
+    for _, entry := range entries {
+        if _, network, err := net.ParseCIDR(entry.target); err == nil {
+            networks = append(networks, network)
+        }
+    }
+
+    for _, entry := range entries {
+
+        covered := false
+        parsed := net.ParseIP(entry.target)
+        _, own, _ := net.ParseCIDR(entry.target)
+
+        for _, network := range networks {
+
+            // a single address within the network
+            if parsed != nil && network.Contains(parsed) {
+                covered = true
+            }
+
+            // a narrower network within the network
+            if own != nil && own.String() != network.String() &&
+              network.Contains(own.IP) {
+                own_ones, _ := own.Mask.Size()
+                ones, _ := network.Mask.Size()
+                if ones < own_ones {
+                    covered = true
+                }
+            }
+        }
+
+        if !covered {
+            result = append(result, entry)
+        }
+    }
+
+    return result
+}
+
+//! Split firewall entries into their IPv4 and IPv6 halves
+/*
+ * @param     firewallEntry[]    entries to split
+ *
+ * @return    map                map of IPv4 targets and entries
+ * @return    map                map of IPv6 targets and entries
+ */
+func splitFirewallEntries(entries []firewallEntry) (map[string] firewallEntry,
+  map[string] firewallEntry) {
+
+    // variable declaration
+    var entries4 = make(map[string] firewallEntry)
+    var entries6 = make(map[string] firewallEntry)
+
+    for _, entry := range entries {
+        entry.target = normalizeFirewallTarget(entry.target)
+        if strings.Contains(entry.target, ":") {
+            entries6[entry.target] = entry
+        } else {
+            entries4[entry.target] = entry
+        }
+    }
+
+    return entries4, entries6
+}
+
+//! Obtain the sorted keys of a map of firewall entries
+/*
+ * @param     map         map of targets and entries
+ *
+ * @return    string[]    sorted targets
+ */
+func sortedFirewallTargets(entries map[string] firewallEntry) []string {
+
+    targets := make([]string, 0, len(entries))
+    for target, _ := range entries {
+        targets = append(targets, target)
+    }
+    sort.Strings(targets)
+    return targets
+}
+
+//! Assemble the firewall backend of a given name
+/*
+ * @param     string              backend name; e.g. nftables
+ * @param     commandRunner       runs the firewall commands
+ * @param     bool                whether the commands are only printed
+ * @param     Duration            longest block the ledger hands out
+ *
+ * @return    firewallBackend     backend, or nil if the name is blank
+ * @return    error               error message, if any
+ */
+func newFirewallBackend(backend string, runner commandRunner,
+  dry_run bool, max_ttl time.Duration) (firewallBackend, error) {
+
+    switch backend {
+    case "":
+        return nil, nil
+    case "nftables":
+        return &nftablesBackend{runner: runner, binary: nftBinary,
+          table: "ascii_log"}, nil
+    case "ipset":
+        return &ipsetBackend{runner: runner, ipset_binary: ipsetBinary,
+          iptables_binary: iptablesBinary,
+          ip6tables_binary: ip6tablesBinary, set_name: "ascii-log",
+          set_timeout: max_ttl, dry_run: dry_run}, nil
+    }
+
+    return nil, fmt.Errorf("newFirewallBackend() --> unknown firewall " +
+      "backend: %s", backend)
+}
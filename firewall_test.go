@@ -0,0 +1,159 @@
+//
+// Firewall test helpers for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+//! A command, as it was handed to the recording runner
+type recordedCommand struct {
+    line  string
+    stdin string
+}
+
+//! Records the commands instead of running them, answering with canned
+//! output, or failing, per command line
+type recordingRunner struct {
+    commands []recordedCommand
+    outputs  map[string] string
+    failures map[string] bool
+}
+
+//! Record a command, and answer it as if it had been run
+/*
+ * @param     string      command name or /path/to/binary
+ * @param     string[]    list of arguments
+ * @param     string      data to pass via stdin, if any
+ *
+ * @return    string      canned output of the command, if any
+ * @return    error       error message, if the command is set to fail
+ */
+func (r *recordingRunner) run(name string, args []string, stdin string) (string,
+  error) {
+
+    line := strings.TrimSpace(name + " " + strings.Join(args, " "))
+    r.commands = append(r.commands, recordedCommand{line, stdin})
+
+    if r.failures[line] {
+        return "", fmt.Errorf("%s --> exit status 1", line)
+    }
+    return r.outputs[line], nil
+}
+
+//! Obtain the command lines that were run, in order
+/*
+ * @return    string[]    command lines
+ */
+func (r *recordingRunner) lines() []string {
+
+    result := make([]string, 0, len(r.commands))
+    for _, command := range r.commands {
+        result = append(result, command.line)
+    }
+    return result
+}
+
+//! Check that the recorded command lines match the expected ones
+/*
+ * @param     T*          test state
+ * @param     string[]    command lines that were run
+ * @param     string[]    command lines that ought to have been run
+ */
+func checkCommandLines(t *testing.T, got []string, want []string) {
+
+    t.Helper()
+
+    if strings.Join(got, "\n") != strings.Join(want, "\n") {
+        t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"),
+          strings.Join(want, "\n"))
+    }
+}
+
+//! Capture what a function prints to stdout, e.g. the commands of a dry run
+/*
+ * @param     T*        test state
+ * @param     func      function to run
+ *
+ * @return    string    everything printed to stdout
+ */
+func captureStdout(t *testing.T, f func()) string {
+
+    t.Helper()
+
+    reader, writer, err := os.Pipe()
+    if err != nil {
+        t.Fatalf("unable to create a pipe: %v", err)
+    }
+
+    stdout := os.Stdout
+    os.Stdout = writer
+    defer func() { os.Stdout = stdout }()
+
+    f()
+
+    writer.Close()
+    output, err := ioutil.ReadAll(reader)
+    if err != nil {
+        t.Fatalf("unable to read the pipe: %v", err)
+    }
+    return string(output)
+}
+
+func TestFormatFirewallTimeout(t *testing.T) {
+
+    tests := []struct {
+        timeout time.Duration
+        want    string
+    }{
+        {48 * time.Hour, "172800s"},
+        {1500 * time.Millisecond, "1s"},
+        {0, "1s"},
+        {-time.Hour, "1s"},
+    }
+
+    for _, test := range tests {
+        got := formatFirewallTimeout(test.timeout)
+        if got != test.want {
+            t.Errorf("formatFirewallTimeout(%v) = %q, want %q",
+              test.timeout, got, test.want)
+        }
+    }
+}
+
+func TestNormalizeFirewallTarget(t *testing.T) {
+
+    tests := []struct {
+        target string
+        want   string
+    }{
+        {"10.0.0.1", "10.0.0.1"},
+        {"10.0.0.1/32", "10.0.0.1"},
+        {"10.0.0.7/24", "10.0.0.0/24"},
+        {" 2001:DB8::1 ", "2001:db8::1"},
+        {"2001:db8::1/128", "2001:db8::1"},
+        {"2001:db8:0:0::/64", "2001:db8::/64"},
+        {"not-an-ip", "not-an-ip"},
+    }
+
+    for _, test := range tests {
+        got := normalizeFirewallTarget(test.target)
+        if got != test.want {
+            t.Errorf("normalizeFirewallTarget(%q) = %q, want %q",
+              test.target, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,168 @@
+//
+// Offline GeoIP and ASN functions for ASCII-log
+//
+// Country codes and AS numbers are taken from local MaxMind DB files, e.g.
+// GeoLite2-Country and GeoLite2-ASN, or the compatible DB-IP lite files,
+// whenever they are configured. They answer straight away and never rate
+// limit, so they win over whois and RDAP, which are then only needed for
+// the details; e.g. the network name and allocated CIDR.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "strings"
+)
+
+//! Obtain the country code of an IP address from the country database
+/*
+ * @param     string    IP address
+ *
+ * @return    string    two letter country code, or blank if unknown
+ */
+func obtainGeoipCountry(ip string) string {
+
+    if geoipCountryDb == nil {
+        return ""
+    }
+
+    data, err := geoipCountryDb.lookup(ip)
+    if err != nil || data == nil {
+        return ""
+    }
+
+    // the country of the network itself, else the country it was
+    // registered in
+    code, _ := obtainMmdbPath(data, "country", "iso_code").(string)
+    if len(code) != 2 {
+        code, _ = obtainMmdbPath(data, "registered_country",
+          "iso_code").(string)
+    }
+    if len(code) != 2 {
+        return ""
+    }
+
+    return strings.ToUpper(code)
+}
+
+//! Obtain the AS number and organisation of an IP address from the ASN
+//! database
+/*
+ * @param     string    IP address
+ *
+ * @return    string    AS number; e.g. AS64500, or blank if unknown
+ * @return    string    AS organisation, if any
+ */
+func obtainGeoipAsn(ip string) (string, string) {
+
+    if geoipAsnDb == nil {
+        return "", ""
+    }
+
+    data, err := geoipAsnDb.lookup(ip)
+    if err != nil || data == nil {
+        return "", ""
+    }
+
+    number, ok := obtainMmdbPath(data, "autonomous_system_number").(uint64)
+    if !ok || number == 0 {
+        return "", ""
+    }
+    org, _ := obtainMmdbPath(data,
+      "autonomous_system_organization").(string)
+
+    return fmt.Sprintf("AS%d", number), org
+}
+
+//! Reload the databases that were replaced since they were last loaded
+func reloadGeoipDatabases() {
+
+    for _, reader := range []*mmdbReader{geoipCountryDb, geoipAsnDb} {
+        if reader == nil {
+            continue
+        }
+        if _, err := reader.reload(); err != nil {
+            fmt.Println("Warning: keeping the database loaded earlier, " +
+              "since " + err.Error())
+        }
+    }
+}
+
+//! Fill in the country codes and AS numbers of the given addresses from
+//! the databases, in place of those given by whois
+/*
+ * This runs after the whois lookups, rather than in place of them, since
+ * the databases lack the rest of the network details.
+ *
+ * @param     string[]    IP addresses
+ * @param     map         string map containing whois country data
+ * @param     map         string map containing parsed whois records
+ */
+func applyGeoipLookups(ips []string, whois_country_map map[string] string,
+  whois_record_map map[string] whoisRecord) {
+
+    if geoipCountryDb == nil && geoipAsnDb == nil {
+        return
+    }
+
+    // pick up any database replaced since the last run
+    reloadGeoipDatabases()
+
+    for _, ip := range ips {
+
+        if len(ip) < 1 {
+            continue
+        }
+
+        record := whois_record_map[ip]
+
+        if code := obtainGeoipCountry(ip); len(code) > 0 {
+            whois_country_map[ip] = code
+            record.country = code
+        }
+
+        if asn, org := obtainGeoipAsn(ip); len(asn) > 0 {
+            record.asn = asn
+            if len(org) > 0 {
+                record.as_name = org
+            }
+        }
+
+        whois_record_map[ip] = record
+    }
+}
+
+//! Load the databases configured via the flags
+/*
+ * @return    error    error message, if any
+ */
+func loadGeoipDatabases() error {
+
+    // variable declaration
+    var err error
+
+    geoipCountryDb, geoipAsnDb = nil, nil
+
+    if len(geoipCountryFile) > 0 {
+        geoipCountryDb, err = newMmdbReader(geoipCountryFile)
+        if err != nil {
+            return err
+        }
+    }
+
+    if len(geoipAsnFile) > 0 {
+        geoipAsnDb, err = newMmdbReader(geoipAsnFile)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
@@ -11,10 +11,8 @@ package main
 // Imports
 //
 import (
-    "bytes"
+    "context"
     "fmt"
-    "net"
-    "os/exec"
     "regexp"
     "strings"
     "sort"
@@ -23,17 +21,22 @@ import (
 
 //! Convert the global IP address map to an array of sorted ipEntry objects
 /*
+ * @param     Context    context of the reverse DNS lookups
  * @param     map        string map containing ip addresses and counts
- * @param     map        string map containing ip/whois country data
+ * @param     map        string map containing ip/whois country data; "--"
+ *                       is listed for the addresses missing from it
+ * @param     map        string map of allowlisted ip addresses
  *
  * @return    string     lines that contain "count | ip | country | host \n"
  *            error      error message, if any
  */
-func convertIpAddressMapToString(ip_map map[string] int,
-  whois_country_map map[string] string) (string, error) {
+func convertIpAddressMapToString(ctx context.Context, ip_map map[string] int,
+  whois_country_map map[string] string,
+  allowlisted map[string] string) (string, error) {
 
-    // input validation
-    if len(ip_map) < 1 || len(whois_country_map) < 1 {
+    // input validation; the country map may well be empty, e.g. once the
+    // lookup deadline passed before any whois lookup was made
+    if len(ip_map) < 1 {
         return "", fmt.Errorf("convertIpAddressMapToString() --> " +
           "invalid input")
     }
@@ -42,7 +45,6 @@ func convertIpAddressMapToString(ip_map map[string] int,
     var ip_strings string     = ""
     var tmp_str_array         = make([]string, 0)
     var lines_appended uint   = 0
-    var first_hostname string = ""
 
     // for every IPv4 address in the given map...
     for ip, _ := range ip_map {
@@ -58,14 +60,19 @@ func convertIpAddressMapToString(ip_map map[string] int,
         tmp_str_array = append(tmp_str_array, ip)
     }
 
-    // sort the given list of IPv4 addresses
+    // sort the given list of IPv4 addresses, then workaround, trim away
+    // any LHS zeros
     sort.Strings(tmp_str_array)
+    for i, ip := range tmp_str_array {
+        tmp_str_array[i] = strings.TrimLeft(ip, "0")
+    }
 
-    // for every ip address
-    for _, ip := range tmp_str_array {
+    // take the given IP addresses and attempt to grab their hostnames;
+    // they come back in the same order as the addresses
+    hostnames := obtainHostnames(ctx, tmp_str_array)
 
-        // workaround, trim away any LHS zeros
-        ip = strings.TrimLeft(ip, "0")
+    // for every ip address
+    for i, ip := range tmp_str_array {
 
         // grab the count
         count := ip_map[ip]
@@ -79,27 +86,17 @@ func convertIpAddressMapToString(ip_map map[string] int,
             country_code = "--"
         }
 
-        // take the given IP address and attempt to grab the hostname
-        hostnames, err := net.LookupAddr(ip)
-
-        // default to "N/A" as the default hostname if an error occurred
-        // or no hostnames could be currently found...
-        if err != nil || len(hostnames) < 1 {
-            first_hostname = "N/A"
-
-        // default to "N/A" as the default hostname if the hostname is
-        // blank or currently NXDOMAIN and etc.
-        } else if len(hostnames[0]) < 1 {
+        // default to "N/A" as the default hostname if an error occurred,
+        // no hostnames could be currently found, or the hostname is blank
+        // or currently NXDOMAIN and etc.
+        first_hostname := hostnames[i]
+        if len(first_hostname) < 1 {
             first_hostname = "N/A"
-
-        // Otherwise go ahead and use the first available hostname
-        } else {
-            first_hostname = hostnames[0]
         }
 
         // since the \t character tends to get mangled easily, add a buffer
-        // of single-space characters instead to the IPv4 addresses
-        space_formatted_ip_address, err := spaceFormatIPv4(ip)
+        // of single-space characters instead to the IP addresses
+        space_formatted_ip_address, err := spaceFormatIPAddress(ip)
 
         // if an error occurs, skip to the next element
         if err != nil {
@@ -114,6 +111,11 @@ func convertIpAddressMapToString(ip_map map[string] int,
         ip_strings += country_code
         ip_strings += " | "
         ip_strings += first_hostname
+
+        // mark the addresses that can never be blocked
+        if _, exists := allowlisted[ip]; exists {
+            ip_strings += " [allowlisted]"
+        }
         ip_strings += "\n"
 
         // add a line counter for internal use
@@ -130,31 +132,282 @@ func convertIpAddressMapToString(ip_map map[string] int,
     return ip_strings, nil
 }
 
+//! Network details gathered from the whois record of an IP address
+type whoisRecord struct {
+    country     string
+    asn         string
+    as_name     string
+    netname     string
+    org         string
+    cidr        string
+    abuse_email string
+}
+
+//! Outcome of a whois or RDAP lookup
+type whoisResult struct {
+
+    // whois record text, or the URL of the RDAP network object
+    text   string
+    rdap   bool
+    record whoisRecord
+}
+
+//! Obtain the value of the last line of a whois record with a given key
+/*
+ * Records often contain several objects, e.g. the ARIN allocation followed
+ * by the RIPE assignment, so the last one tends to be the most specific.
+ *
+ * @param     string      whois record text
+ * @param     string[]    keys to look for, case insensitive
+ *
+ * @return    string      value of the last matching line, if any
+ */
+func obtainWhoisValue(text string, keys []string) string {
+
+    // variable declaration
+    var result string = ""
+
+    // for every line of the record...
+    for _, line := range strings.Split(text, "\n") {
+
+        colon := strings.Index(line, ":")
+        if colon < 1 {
+            continue
+        }
+
+        key := strings.ToLower(strings.TrimSpace(line[:colon]))
+        value := strings.TrimSpace(line[colon+1:])
+        if len(value) < 1 || !isStringInArray(key, keys) {
+            continue
+        }
+
+        result = value
+    }
+
+    return result
+}
+
+//! Parse the network details out of a whois record
+/*
+ * @param     string         whois record text
+ *
+ * @return    whoisRecord    network details; fields are blank if absent
+ */
+func parseWhoisRecord(text string) whoisRecord {
+
+    // variable declaration
+    var record whoisRecord
+
+    // origin AS number, of RIPE / APNIC routes, ARIN and LACNIC
+    record.asn = strings.ToUpper(obtainWhoisValue(text,
+      []string{"origin", "originas", "aut-num"}))
+
+    // some records list several origins; e.g. "AS1, AS2"
+    if i := strings.IndexAny(record.asn, ", "); i > 0 {
+        record.asn = record.asn[:i]
+    }
+
+    // ensure the AS number uses the "AS1234" form
+    if len(record.asn) > 0 && !strings.HasPrefix(record.asn, "AS") {
+        record.asn = "AS" + record.asn
+    }
+
+    record.as_name = obtainWhoisValue(text, []string{"as-name", "asname"})
+    record.netname = obtainWhoisValue(text, []string{"netname"})
+
+    // the organisation goes by many different names, the descr field
+    // being the last resort
+    record.org = obtainWhoisValue(text, []string{"orgname", "org-name",
+      "owner", "organization", "organisation"})
+    if len(record.org) < 1 {
+        record.org = obtainWhoisValue(text, []string{"descr"})
+    }
+
+    // the allocated CIDR; ARIN lists "CIDR: a/b, c/d", while the others
+    // usually list a range of "first - last"; the route objects are only
+    // used if there is no allocation, since the announced prefix is often
+    // much wider than the network of a single customer
+    cidr := obtainWhoisValue(text, []string{"cidr", "netrange",
+      "inet6num", "inetnum"})
+    if len(cidr) < 1 {
+        cidr = obtainWhoisValue(text, []string{"route", "route6"})
+    }
+    if i := strings.Index(cidr, ","); i > 0 {
+        cidr = cidr[:i]
+    }
+    if pieces := strings.Split(cidr, " - "); len(pieces) == 2 {
+        cidrs, err := convertRangeToCidrs(pieces[0], pieces[1])
+        cidr = ""
+        if err == nil && len(cidrs) > 0 {
+            cidr = strings.Join(cidrs, ",")
+        }
+    }
+    record.cidr = strings.TrimSpace(cidr)
+
+    record.abuse_email = obtainAbuseContact(text)
+    record.country = obtainWhoisCountry(text)
+
+    return record
+}
+
+//! Obtain the country code of a whois record
+/*
+ * @param     string    whois record text
+ *
+ * @return    string    two letter country code, or "--" if unknown
+ */
+func obtainWhoisCountry(text string) string {
+
+    // compile a regex that looks for "country: XX\n" or "Country: XX\n"
+    re := regexp.MustCompile("[cC]ountry:[^\n]{2,32}\n")
+
+    // variable to hold the country result
+    whois_regex_country_result := ""
+
+    // attempt to obtain the country of a given IP address, look for at
+    // least two matches in case of alt
+    whois_regex_country_results := re.FindAllString(text, 2)
+
+    // if there is more than one entry, take the last one since
+    // the others are likely ARIN/RIPE/etc data and therefore not
+    // quite as useful as the actual origin country network.
+    for _, wrc := range whois_regex_country_results {
+        whois_regex_country_result = wrc
+    }
+
+    // trim the result
+    whois_regex_country_result =
+      strings.Trim(whois_regex_country_result, " ")
+    whois_regex_country_result =
+      strings.Trim(whois_regex_country_result, "\n")
+
+    // ensure that the result still has 2 letters
+    if len(whois_regex_country_result) < 2 {
+        whois_regex_country_result = "--"
+    }
+
+    // certain Brazilian authorities follow an alternate regex,
+    // so as a workaround for now, go ahead and test for this
+    re_br := regexp.MustCompile("whois.registro.br")
+    verify_br := re_br.FindAllString(text, 1)
+
+    // if the Brazilian registro is found, go ahead and assign it a
+    // country code of BR since this domain probably belongs to Brazil
+    if len(verify_br) > 0 {
+        whois_regex_country_result = "BR"
+    }
+
+    // split up the string using spaces
+    wr_pieces := strings.Split(whois_regex_country_result, " ")
+
+    // safety check, ensure there are one or more pieces
+    if len(wr_pieces) < 1 {
+        whois_regex_country_result = "--"
+    }
+
+    // assemble a regex to test the country code
+    re_country_code := regexp.MustCompile("^[A-Za-z]{2}$")
+
+    // search thru the pieces for the country code result
+    for _, code := range wr_pieces {
+
+        // if the code is not equal to 2
+        if len(code) != 2 {
+            continue
+        }
+
+        // ensure the code is actually two alphabet characters
+        verify := re_country_code.FindString(code)
+
+        // skip a line if the entry is not the latest date
+        if len(verify) != 2 {
+            continue
+        }
+
+        // assign the code to the whois country result
+        whois_regex_country_result = code
+
+        // leave the loop
+        break
+    }
+
+    return strings.ToUpper(whois_regex_country_result)
+}
+
+//! Convert a whois or RDAP result into the text listed in the whois log
+/*
+ * @param     whoisResult    whois or RDAP result
+ *
+ * @return    string         whois record text, or the RDAP network details
+ */
+func convertWhoisResultToString(result whoisResult) string {
+
+    if result.rdap {
+        return convertRdapRecordToString(result.record, result.text)
+    }
+    return result.text
+}
+
+//! Obtain the abuse mailbox of a whois record
+/*
+ * @param     string    whois record text
+ *
+ * @return    string    abuse email address, or blank if there is none
+ */
+func obtainAbuseContact(text string) string {
+
+    // RIPE / APNIC / AFRINIC list an abuse-mailbox, ARIN an OrgAbuseEmail
+    contact := obtainWhoisValue(text, []string{"abuse-mailbox",
+      "orgabuseemail", "abuse-email"})
+
+    // RIPE also notes it in a comment, when the abuse-c object is omitted
+    if len(contact) < 1 {
+        re := regexp.MustCompile("% Abuse contact for '[^']*' is " +
+          "'([^']+)'")
+        if matches := re.FindAllStringSubmatch(text, -1); len(matches) >
+          0 {
+            contact = matches[len(matches)-1][1]
+        }
+    }
+
+    // some registries list several, or wrap them in angle brackets
+    if fields := strings.Fields(contact); len(fields) > 0 {
+        contact = fields[0]
+    }
+    contact = strings.Trim(contact, "<>,;")
+    if strings.Count(contact, "@") != 1 || strings.HasPrefix(contact,
+      "@") || strings.HasSuffix(contact, "@") {
+        return ""
+    }
+
+    return strings.ToLower(contact)
+}
+
 //! Convert the global IP address map to string containing whois entries
 /*
+ * @param     Context   context of the lookups
  * @param     map       string map containing ip addresses and counts
  *
  * @return    string    whois data of every given ip
  * @return    map       string map containing whois country data
+ * @return    map       string map containing parsed whois records
  * @return    error     error message, if any
  */
-func obtainWhoisEntries(ip_map map[string] int) (string, map[string] string,
-  error) {
+func obtainWhoisEntries(ctx context.Context, ip_map map[string] int) (string,
+  map[string] string, map[string] whoisRecord, error) {
 
     // input validation
     if len(ip_map) < 1 {
-        return "", nil, fmt.Errorf("obtainWhoisEntries() --> invalid input")
+        return "", nil, nil,
+          fmt.Errorf("obtainWhoisEntries() --> invalid input")
     }
 
     // variable declaration
     var whois_strings string  = ""
     var whois_summary_map     = make(map[string] string)
+    var whois_record_map      = make(map[string] whoisRecord)
     var entries_appended uint = 0
     var tmp_str_array         = make([]string, 0)
-    var tmp_str_buffer        = ""
-    var trimmed_string        = ""
-    var err error
-    var result bytes.Buffer
 
     // for every IPv4 address in the given map...
     for ip, _ := range ip_map {
@@ -170,14 +423,25 @@ func obtainWhoisEntries(ip_map map[string] int) (string, map[string] string,
         tmp_str_array = append(tmp_str_array, ip)
     }
 
-    // sort the given list of IPv4 addresses
+    // sort the given list of IPv4 addresses, then workaround, trim away
+    // any LHS zeros
     sort.Strings(tmp_str_array)
+    for i, ip := range tmp_str_array {
+        tmp_str_array[i] = strings.TrimLeft(ip, "0")
+    }
 
-    // for every ip address
-    for _, ip := range tmp_str_array {
+    // attempt to obtain the whois records, from the cache if possible;
+    // they come back in the same order as the addresses
+    //
+    // addresses the GeoIP databases know the country of are looked up
+    // all the same, since whois.log lists the full records, and since the
+    // allocated CIDR, network name and abuse contact, as needed by
+    // networks.log, the whois cache and the abuse reports, only ever come
+    // from whois or RDAP; the cache keeps the repeat lookups cheap
+    whois_results, whois_errs := obtainWhoisResults(ctx, tmp_str_array)
 
-        // workaround, trim away any LHS zeros
-        ip = strings.TrimLeft(ip, "0")
+    // for every ip address
+    for i, ip := range tmp_str_array {
 
         // safety check, skip to the next entry if this one is of length
         // zero
@@ -185,44 +449,15 @@ func obtainWhoisEntries(ip_map map[string] int) (string, map[string] string,
             continue
         }
 
-        // attempt to obtain the whois record
-        result, err = runWhoisCommand(ip)
+        result := whois_results[i]
 
-        // if an error occurs at this point...
-        if err != nil {
-
-            // dump the error code to a string
-            error_code := err.Error()
-
-            // if the error code was not 2, then move on to the next IP
-            if error_code != "exit status 2" {
-                continue
-            }
-
-            // if there is no partial output, then proceed to the next IP
-            if len(result.String()) < 1 {
-                continue
-            }
-        }
-
-        // convert the byte buffer to a string
-        tmp_str_buffer = result.String()
-
-        // if no record is present, pass back a "N/A"
-        if len(tmp_str_buffer) < 1 || tmp_str_buffer == "<nil>" {
-            whois_strings += "Whois Entry for the following: "
-            whois_strings += ip
-            whois_strings += "\n"
-            whois_strings += "N/A\n\n"
-            whois_strings += "---------------------\n\n"
+        // if an error occurs at this point, then move on to the next IP
+        if whois_errs[i] != nil {
             continue
         }
 
-        // trim it to remove potential whitespace
-        trimmed_string = strings.Trim(tmp_str_buffer, " ")
-
-        // ensure it still has a length of zero
-        if len(trimmed_string) < 1 {
+        // if no record is present, pass back a "N/A"
+        if !result.rdap && len(result.text) < 1 {
             whois_strings += "Whois Entry for the following: "
             whois_strings += ip
             whois_strings += "\n"
@@ -231,87 +466,20 @@ func obtainWhoisEntries(ip_map map[string] int) (string, map[string] string,
             continue
         }
 
-        // compile a regex that looks for "country: XX\n" or "Country: XX\n"
-        re := regexp.MustCompile("[cC]ountry:[^\n]{2,32}\n")
-
-        // variable to hold the country result
-        whois_regex_country_result := ""
-
-        // attempt to obtain the country of a given IP address, look for at
-        // least two matches in case of alt
-        whois_regex_country_results := re.FindAllString(trimmed_string, 2)
-
-        // if there is more than one entry, take the last one since
-        // the others are likely ARIN/RIPE/etc data and therefore not
-        // quite as useful as the actual origin country network.
-        for _, wrc := range whois_regex_country_results {
-            whois_regex_country_result = wrc
-        }
-
-        // trim the result
-        whois_regex_country_result =
-          strings.Trim(whois_regex_country_result, " ")
-        whois_regex_country_result =
-          strings.Trim(whois_regex_country_result, "\n")
-
-        // ensure that the result still has 2 letters
-        if len(whois_regex_country_result) < 2 {
-            whois_regex_country_result = "--"
-        }
-
-        // certain Brazilian authorities follow an alternate regex,
-        // so as a workaround for now, go ahead and test for this
-        re_br := regexp.MustCompile("whois.registro.br")
-        verify_br := re_br.FindAllString(trimmed_string, 1)
-
-        // if the Brazilian registro is found, go ahead and assign it a
-        // country code of BR since this domain probably belongs to Brazil
-        if len(verify_br) > 0 {
-            whois_regex_country_result = "BR"
-        }
-
-        // split up the string using spaces
-        wr_pieces := strings.Split(whois_regex_country_result, " ")
-
-        // safety check, ensure there are one or more pieces
-        if len(wr_pieces) < 1 {
-            whois_regex_country_result = "--"
+        // the country, and the rest of the network details, were
+        // parsed during the lookup
+        record := result.record
+        if len(record.country) != 2 {
+            record.country = "--"
         }
-
-        // assemble a regex to test the country code
-        re_country_code := regexp.MustCompile("^[A-Za-z]{2}$")
-
-        // search thru the pieces for the country code result
-        for _, code := range wr_pieces {
-
-            // if the code is not equal to 2
-            if len(code) != 2 {
-                continue
-            }
-
-            // ensure the code is actually two alphabet characters
-            verify := re_country_code.FindString(code)
-
-            // skip a line if the entry is not the latest date
-            if len(verify) != 2 {
-                continue
-            }
-
-            // assign the code to the whois country result
-            whois_regex_country_result = code
-
-            // leave the loop
-            break
-        }
-
-        // append it to the whois map
-        whois_summary_map[ip] = strings.ToUpper(whois_regex_country_result)
+        whois_summary_map[ip] = record.country
+        whois_record_map[ip] = record
 
         // otherwise it's probably good, then go ahead and append it
         whois_strings += "Whois Entry for the following: "
         whois_strings += ip
         whois_strings += "\n"
-        whois_strings += trimmed_string
+        whois_strings += convertWhoisResultToString(result)
         whois_strings += "\n\n"
         whois_strings += "---------------------\n\n"
 
@@ -325,35 +493,10 @@ func obtainWhoisEntries(ip_map map[string] int) (string, map[string] string,
         whois_strings += "No whois entries given at this time."
     }
 
-    // everything worked fine, so return the completed string contents
-    return whois_strings, whois_summary_map, nil
-}
+    // the local GeoIP databases, if any, are trusted over whois for the
+    // country codes and AS numbers
+    applyGeoipLookups(tmp_str_array, whois_summary_map, whois_record_map)
 
-//! Attempt to execute the whois command.
-/*
- *  @param    ...string    list of arguments
- *
- *  @return   bytes[]      array of byte buffer data
- */
-func runWhoisCommand(args ...string) (bytes.Buffer, error) {
-
-    // variable declaration
-    var output bytes.Buffer
-
-    // assemble the command from the list of string arguments
-    cmd := exec.Command("whois", args...)
-    cmd.Stdout = &output
-    cmd.Stderr = &output
-
-    // attempt to execute the command
-    err := cmd.Run()
-
-    // if an error occurred, go ahead and pass it back
-    if err != nil {
-        return output, err
-    }
-
-    // having ran the command, pass back the result if no error has
-    // occurred
-    return output, nil
+    // everything worked fine, so return the completed string contents
+    return whois_strings, whois_summary_map, whois_record_map, nil
 }
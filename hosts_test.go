@@ -0,0 +1,131 @@
+//
+// Host and whois report tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "strings"
+    "testing"
+)
+
+func TestConvertIpAddressMapToString(t *testing.T) {
+
+    // a run past its lookup deadline resolves neither hostnames nor
+    // countries, yet still lists every address
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    ip_map := map[string] int{"192.0.2.1": 7, "198.51.100.2": 3}
+
+    tests := []struct {
+        name      string
+        countries map[string] string
+        want      []string
+    }{
+        {"no country map", nil, []string{"--", "--"}},
+        {"empty country map", map[string] string{}, []string{"--", "--"}},
+        {"some countries", map[string] string{"192.0.2.1": "NL",
+          "198.51.100.2": "unknown"}, []string{"NL", "--"}},
+    }
+
+    for _, test := range tests {
+
+        output, err := convertIpAddressMapToString(ctx, ip_map,
+          test.countries, nil)
+        if err != nil {
+            t.Errorf("%s: unexpected error: %v", test.name, err)
+            continue
+        }
+
+        lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+        if len(lines) != 2 {
+            t.Errorf("%s: %d lines, want 2:\n%s", test.name, len(lines),
+              output)
+            continue
+        }
+        for i, line := range lines {
+            fields := strings.Split(line, " | ")
+            if len(fields) != 4 || strings.TrimSpace(fields[2]) !=
+              test.want[i] || fields[3] != "N/A" {
+                t.Errorf("%s: line %q, want country %s and no hostname",
+                  test.name, line, test.want[i])
+            }
+        }
+    }
+
+    // there has to be at least a single address to list
+    _, err := convertIpAddressMapToString(ctx, nil, nil, nil)
+    if err == nil {
+        t.Errorf("expected an error for no addresses")
+    }
+}
+
+func TestParseWhoisRecord(t *testing.T) {
+
+    tests := []struct {
+        name string
+        text string
+        want whoisRecord
+    }{
+        {"empty", "", whoisRecord{country: "--"}},
+        {
+            "RIPE allocation and route",
+            "inetnum:        192.0.2.0 - 192.0.2.255\n" +
+              "netname:        EXAMPLE-NET\n" +
+              "descr:          Example hosting\n" +
+              "country:        NL\n" +
+              "route:          192.0.0.0/16\n" +
+              "origin:        as64500\n",
+            whoisRecord{country: "NL", asn: "AS64500",
+              netname: "EXAMPLE-NET", org: "Example hosting",
+              cidr: "192.0.2.0/24"},
+        },
+        {
+            "ARIN, several CIDRs and origins",
+            "NetRange:       198.51.100.0 - 198.51.101.255\n" +
+              "CIDR:           198.51.100.0/24, 198.51.101.0/24\n" +
+              "NetName:        EXAMPLE-2\n" +
+              "OriginAS:       64501, AS64502\n" +
+              "OrgName:        Example Networks\n" +
+              "Country:        US\n",
+            whoisRecord{country: "US", asn: "AS64501",
+              netname: "EXAMPLE-2", org: "Example Networks",
+              cidr: "198.51.100.0/24"},
+        },
+        {
+            "unaligned range",
+            "inetnum: 203.0.113.1 - 203.0.113.6\n" +
+              "org-name: Example Org\ndescr: ignored\n",
+            whoisRecord{country: "--", org: "Example Org",
+              cidr: "203.0.113.1/32,203.0.113.2/31,203.0.113.4/31," +
+              "203.0.113.6/32"},
+        },
+        {
+            "route only, with an AS name",
+            "route6: 2001:db8::/32\norigin: AS64503\nas-name: EXAMPLE-AS\n",
+            whoisRecord{country: "--", asn: "AS64503",
+              as_name: "EXAMPLE-AS", cidr: "2001:db8::/32"},
+        },
+        {
+            "improper range",
+            "inetnum: 203.0.113.9 - 203.0.113.1\nnetname: BACKWARDS\n",
+            whoisRecord{country: "--", netname: "BACKWARDS"},
+        },
+    }
+
+    for _, test := range tests {
+        got := parseWhoisRecord(test.text)
+        if got != test.want {
+            t.Errorf("%s: record = %+v, want %+v", test.name, got,
+              test.want)
+        }
+    }
+}
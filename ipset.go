@@ -0,0 +1,216 @@
+//
+// iptables + ipset firewall backend for ASCII-log
+//
+// For hosts without nftables, the blocked addresses are kept in two
+// hash:net sets, one per address family, each referenced by a single DROP
+// rule at the top of the INPUT chain:
+//
+// ipset create ascii-log4 hash:net family inet timeout 2147483
+// iptables -I INPUT -m set --match-set ascii-log4 src -j DROP
+//
+// The sets are created with the longest block of the ledger, --block-max-ttl,
+// as their timeout, or the ipset maximum if that is shorter. Changes to the
+// sets are passed to `ipset restore` as add / del lines, so the sets are
+// never flushed and blocked addresses never slip thru.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "strings"
+    "time"
+)
+
+// Largest timeout, in seconds, that ipset accepts.
+const ipsetMaxTimeout = 2147483
+
+//! iptables + ipset firewall backend
+type ipsetBackend struct {
+    runner           commandRunner
+    ipset_binary     string
+    iptables_binary  string
+    ip6tables_binary string
+    set_name         string
+    set_timeout      time.Duration
+    dry_run          bool
+}
+
+//! Obtain the name of the backend
+/*
+ * @return    string    backend name
+ */
+func (i *ipsetBackend) name() string {
+    return "ipset"
+}
+
+//! Format a timeout as the whole seconds ipset expects
+/*
+ * @param     Duration    timeout
+ *
+ * @return    string      seconds, between 1 and the ipset maximum
+ */
+func formatIpsetTimeout(timeout time.Duration) string {
+
+    if timeout > ipsetMaxTimeout * time.Second {
+        timeout = ipsetMaxTimeout * time.Second
+    }
+    return strings.TrimSuffix(formatFirewallTimeout(timeout), "s")
+}
+
+//! Obtain the default timeout of the sets, which every block fits within
+/*
+ * @return    Duration    longest block the ledger hands out, or the ipset
+ *                        maximum if the blocks have no maximum
+ */
+func (i *ipsetBackend) obtainSetTimeout() time.Duration {
+
+    if i.set_timeout <= 0 {
+        return ipsetMaxTimeout * time.Second
+    }
+    return i.set_timeout
+}
+
+//! Ensure a set exists, along with the DROP rule that references it
+/*
+ * @param     string    set name
+ * @param     string    set family; e.g. inet or inet6
+ * @param     string    iptables or ip6tables binary
+ *
+ * @return    error     error message, if any
+ */
+func (i *ipsetBackend) ensureSet(set string, family string,
+  iptables string) error {
+
+    // the set is only created if it is missing, since create -exist fails
+    // once the timeout of an existing set differs, e.g. after a change of
+    // --block-max-ttl; a dry run cannot tell, so it prints the create
+    _, err := i.runner.run(i.ipset_binary, []string{"list", "-name", set},
+      "")
+    if err != nil || i.dry_run {
+        _, err = i.runner.run(i.ipset_binary, []string{"create", set,
+          "hash:net", "family", family, "timeout",
+          formatIpsetTimeout(i.obtainSetTimeout()), "-exist"}, "")
+        if err != nil {
+            return err
+        }
+    }
+
+    // check for the rule first, so that it is only ever inserted once;
+    // likewise, a dry run prints the insert either way
+    rule := []string{"INPUT", "-m", "set", "--match-set", set, "src", "-j",
+      "DROP"}
+    _, err = i.runner.run(iptables, append([]string{"-C"}, rule...), "")
+    if err == nil && !i.dry_run {
+        return nil
+    }
+    _, err = i.runner.run(iptables, append([]string{"-I"}, rule...), "")
+    return err
+}
+
+//! Obtain the members currently present in a set
+/*
+ * @param     string      set name
+ *
+ * @return    string[]    members of the set; empty if the set is missing
+ */
+func (i *ipsetBackend) listMembers(set string) []string {
+
+    // variable declaration
+    var result = make([]string, 0)
+
+    output, err := i.runner.run(i.ipset_binary, []string{"list", set}, "")
+    if err != nil {
+        return result
+    }
+
+    // the members are listed one per line, after the "Members:" line;
+    // e.g. "10.0.0.0/24 timeout 86400"
+    members := false
+    for _, line := range strings.Split(output, "\n") {
+        if strings.HasPrefix(line, "Members:") {
+            members = true
+            continue
+        }
+        fields := strings.Fields(line)
+        if members && len(fields) > 0 {
+            result = append(result, normalizeFirewallTarget(fields[0]))
+        }
+    }
+
+    return result
+}
+
+//! Assemble the ipset restore lines that bring a set in line with the
+//! entries
+/*
+ * @param     string      set name
+ * @param     string[]    members currently in the set
+ * @param     map         map of targets and entries that ought to be in it
+ *
+ * @return    string      ipset restore lines
+ */
+func (i *ipsetBackend) assembleSetChanges(set string, current []string,
+  wanted map[string] firewallEntry) string {
+
+    // variable declaration
+    var script string = ""
+
+    // members that are no longer blocked are deleted
+    for _, member := range current {
+        if _, exists := wanted[member]; !exists {
+            script += "del " + set + " " + member + "\n"
+        }
+    }
+
+    // new entries are added, and existing ones have their timeout
+    // refreshed, since add -exist replaces the timeout
+    for _, target := range sortedFirewallTargets(wanted) {
+        script += "add " + set + " " + target + " timeout " +
+          formatIpsetTimeout(wanted[target].timeout) + "\n"
+    }
+
+    return script
+}
+
+//! Bring the firewall in line with the given entries
+/*
+ * @param     firewallEntry[]    entries to block
+ *
+ * @return    error              error message, if any
+ */
+func (i *ipsetBackend) sync(entries []firewallEntry) error {
+
+    set4 := i.set_name + "4"
+    set6 := i.set_name + "6"
+
+    // ensure both sets, and their rules, are present
+    err := i.ensureSet(set4, "inet", i.iptables_binary)
+    if err != nil {
+        return err
+    }
+    err = i.ensureSet(set6, "inet6", i.ip6tables_binary)
+    if err != nil {
+        return err
+    }
+
+    entries4, entries6 := splitFirewallEntries(entries)
+
+    script := i.assembleSetChanges(set4, i.listMembers(set4), entries4)
+    script += i.assembleSetChanges(set6, i.listMembers(set6), entries6)
+
+    // nothing to do if the sets are empty and ought to stay that way
+    if len(script) < 1 {
+        return nil
+    }
+
+    // apply the changes in a single batch
+    _, err = i.runner.run(i.ipset_binary, []string{"restore", "-exist"},
+      script)
+    return err
+}
@@ -0,0 +1,180 @@
+//
+// iptables + ipset firewall backend tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "testing"
+    "time"
+)
+
+func TestFormatIpsetTimeout(t *testing.T) {
+
+    tests := []struct {
+        timeout time.Duration
+        want    string
+    }{
+        {time.Hour, "3600"},
+        {0, "1"},
+        {ipsetMaxTimeout * time.Second, "2147483"},
+        {365 * 24 * time.Hour, "2147483"},
+    }
+
+    for _, test := range tests {
+        got := formatIpsetTimeout(test.timeout)
+        if got != test.want {
+            t.Errorf("formatIpsetTimeout(%v) = %q, want %q", test.timeout,
+              got, test.want)
+        }
+    }
+}
+
+func TestIpsetSync(t *testing.T) {
+
+    exists4 := "ipset list -name ascii-log4"
+    exists6 := "ipset list -name ascii-log6"
+    create4 := "ipset create ascii-log4 hash:net family inet timeout " +
+      "1209600 -exist"
+    create6 := "ipset create ascii-log6 hash:net family inet6 timeout " +
+      "1209600 -exist"
+    rule4 := "INPUT -m set --match-set ascii-log4 src -j DROP"
+    rule6 := "INPUT -m set --match-set ascii-log6 src -j DROP"
+    list4 := "ipset list ascii-log4"
+    list6 := "ipset list ascii-log6"
+    restore := "ipset restore -exist"
+
+    tests := []struct {
+        name     string
+        max_ttl  time.Duration
+        entries  []firewallEntry
+        outputs  map[string] string
+        failures map[string] bool
+        commands []string
+        script   string
+    }{
+        {
+            name:     "fresh host, rules inserted once",
+            max_ttl:  14 * 24 * time.Hour,
+            entries:  []firewallEntry{
+                {"10.0.0.1", 48 * time.Hour},
+                {"2001:db8::/64", time.Hour},
+            },
+            failures: map[string] bool{
+                exists4:                 true,
+                exists6:                 true,
+                "iptables -C " + rule4:  true,
+                "ip6tables -C " + rule6: true,
+                list4:                   true,
+                list6:                   true,
+            },
+            commands: []string{exists4, create4, "iptables -C " + rule4,
+              "iptables -I " + rule4, exists6, create6,
+              "ip6tables -C " + rule6, "ip6tables -I " + rule6, list4,
+              list6, restore},
+            script:   "add ascii-log4 10.0.0.1 timeout 172800\n" +
+              "add ascii-log6 2001:db8::/64 timeout 3600\n",
+        },
+        {
+            name:     "stale members deleted, kept ones refreshed",
+            max_ttl:  14 * 24 * time.Hour,
+            entries:  []firewallEntry{
+                {"10.0.0.1", time.Hour},
+            },
+            outputs:  map[string] string{
+                list4: "Name: ascii-log4\nType: hash:net\n" +
+                  "Header: family inet hashsize 1024 maxelem 65536 " +
+                  "timeout 86400\nMembers:\n" +
+                  "10.0.0.1 timeout 1200\n192.0.2.0/24 timeout 500\n",
+            },
+            commands: []string{exists4, "iptables -C " + rule4, exists6,
+              "ip6tables -C " + rule6, list4, list6, restore},
+            script:   "del ascii-log4 192.0.2.0/24\n" +
+              "add ascii-log4 10.0.0.1 timeout 3600\n",
+        },
+        {
+            name:     "nothing blocked, nothing to restore",
+            max_ttl:  14 * 24 * time.Hour,
+            commands: []string{exists4, "iptables -C " + rule4, exists6,
+              "ip6tables -C " + rule6, list4, list6},
+        },
+        {
+            name:     "blocks without a maximum fit the ipset maximum",
+            entries:  []firewallEntry{{"10.0.0.1", 400 * 24 * time.Hour}},
+            failures: map[string] bool{exists4: true, exists6: true},
+            commands: []string{exists4, "ipset create ascii-log4 hash:net " +
+              "family inet timeout 2147483 -exist", "iptables -C " + rule4,
+              exists6, "ipset create ascii-log6 hash:net family inet6 " +
+              "timeout 2147483 -exist", "ip6tables -C " + rule6, list4,
+              list6, restore},
+            script:   "add ascii-log4 10.0.0.1 timeout 2147483\n",
+        },
+        {
+            name:     "failed create stops the sync",
+            max_ttl:  14 * 24 * time.Hour,
+            entries:  []firewallEntry{{"10.0.0.1", time.Hour}},
+            failures: map[string] bool{exists4: true, create4: true},
+            commands: []string{exists4, create4},
+        },
+    }
+
+    for _, test := range tests {
+
+        runner := &recordingRunner{outputs: test.outputs,
+          failures: test.failures}
+        backend, err := newFirewallBackend("ipset", runner, false,
+          test.max_ttl)
+        if err != nil {
+            t.Fatalf("unexpected error: %v", err)
+        }
+        ipset := backend.(*ipsetBackend)
+        ipset.ipset_binary = "ipset"
+        ipset.iptables_binary = "iptables"
+        ipset.ip6tables_binary = "ip6tables"
+
+        err = ipset.sync(test.entries)
+        if test.failures[create4] != (err != nil) {
+            t.Errorf("%s: unexpected error: %v", test.name, err)
+        }
+
+        checkCommandLines(t, runner.lines(), test.commands)
+
+        last := runner.commands[len(runner.commands)-1]
+        if last.line == restore && last.stdin != test.script {
+            t.Errorf("%s: restore script:\n%s\nwant:\n%s", test.name,
+              last.stdin, test.script)
+        }
+    }
+}
+
+func TestIpsetEnsureSetDryRun(t *testing.T) {
+
+    // the checks of a dry run always succeed, yet the create and insert
+    // must still be shown, since a dry run cannot tell whether they are
+    // needed
+    backend := &ipsetBackend{runner: dryRunRunner{}, ipset_binary: "ipset",
+      iptables_binary: "iptables", set_name: "ascii-log",
+      set_timeout: 48 * time.Hour, dry_run: true}
+
+    output := captureStdout(t, func() {
+        err := backend.ensureSet("ascii-log4", "inet", "iptables")
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    })
+
+    want := "# ipset list -name ascii-log4\n" +
+      "# ipset create ascii-log4 hash:net family inet timeout " +
+      "172800 -exist\n" +
+      "# iptables -C INPUT -m set --match-set ascii-log4 src -j DROP\n" +
+      "# iptables -I INPUT -m set --match-set ascii-log4 src -j DROP\n"
+    if output != want {
+        t.Errorf("dry run output:\n%s\nwant:\n%s", output, want)
+    }
+}
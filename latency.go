@@ -0,0 +1,315 @@
+//
+// Response time functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Maximum number of paths listed in the per path section of the report.
+const latencyPathLimit = 50
+
+// Minimum number of requests a path needs to be ranked as a slow endpoint.
+const latencySlowMinRequests = 5
+
+//! Percentile summary of a group of response times
+type latencyStats struct {
+    count int
+    p50   float64
+    p90   float64
+    p99   float64
+    max   float64
+}
+
+//! Obtain a percentile of an already sorted array of response times
+/*
+ * @param     float64[]    sorted response times
+ * @param     float64      percentile, between 0 and 100
+ *
+ * @return    float64      value at the given percentile (nearest rank)
+ */
+func percentile(sorted []float64, p float64) float64 {
+
+    // input validation
+    if len(sorted) < 1 {
+        return 0
+    }
+
+    // nearest rank method
+    rank := int(p / 100 * float64(len(sorted)) + 0.999999)
+    if rank < 1 {
+        rank = 1
+    }
+    if rank > len(sorted) {
+        rank = len(sorted)
+    }
+    return sorted[rank-1]
+}
+
+//! Summarize a group of response times
+/*
+ * @param     float64[]       response times, in seconds
+ *
+ * @return    latencyStats    percentile summary
+ */
+func summarizeLatencies(times []float64) latencyStats {
+
+    // sort a copy, leaving the original order intact
+    sorted := make([]float64, len(times))
+    copy(sorted, times)
+    sort.Float64s(sorted)
+
+    return latencyStats{
+        count: len(sorted),
+        p50:   percentile(sorted, 50),
+        p90:   percentile(sorted, 90),
+        p99:   percentile(sorted, 99),
+        max:   percentile(sorted, 100),
+    }
+}
+
+//! Format a response time in seconds as milliseconds
+/*
+ * @param     float64    response time, in seconds
+ *
+ * @return    string     e.g. "  42.0ms"
+ */
+func formatLatency(seconds float64) string {
+    return fmt.Sprintf("%8.1fms", seconds * 1000)
+}
+
+//! Convert a map of grouped response times into report lines
+/*
+ * @param     map         string map containing groups and response times
+ * @param     string[]    order in which the groups are listed
+ *
+ * @return    string      lines that contain "group | count | p50 | ..."
+ */
+func convertLatencyGroupsToString(groups map[string] []float64,
+  order []string) string {
+
+    // variable declaration
+    var latency_strings string = ""
+
+    // column headings
+    latency_strings += fmt.Sprintf("%-40s | %6s | %10s | %10s | %10s | " +
+      "%10s\n", "", "count", "p50", "p90", "p99", "max")
+
+    // for every group, in the given order...
+    for _, name := range order {
+
+        times, exists := groups[name]
+        if !exists || len(times) < 1 {
+            continue
+        }
+
+        stats := summarizeLatencies(times)
+
+        // long paths would push the columns out of line
+        if len(name) > 40 {
+            name = name[:37] + "..."
+        }
+
+        latency_strings += fmt.Sprintf("%-40s | %6d | %s | %s | %s | %s\n",
+          name, stats.count, formatLatency(stats.p50),
+          formatLatency(stats.p90), formatLatency(stats.p99),
+          formatLatency(stats.max))
+    }
+
+    return latency_strings
+}
+
+//! Convert the parsed entries into the contents of the latency log
+/*
+ * @param     logEntry[]    array of parsed log entries
+ *
+ * @return    string        latency report
+ */
+func convertLatenciesToString(entries []logEntry) string {
+
+    // variable declaration
+    var latency_strings string = ""
+    var all_times = make([]float64, 0)
+    var upstream_times = make([]float64, 0)
+    var by_path = make(map[string] []float64)
+    var by_status = make(map[string] []float64)
+    var by_hour = make(map[string] []float64)
+    var path_counts = make(map[string] int)
+
+    // group the response times of every entry
+    for _, entry := range entries {
+
+        if entry.upstream_time >= 0 {
+            upstream_times = append(upstream_times, entry.upstream_time)
+        }
+
+        if entry.request_time < 0 {
+            continue
+        }
+        rt := entry.request_time
+        all_times = append(all_times, rt)
+
+        // group by path, without the query string
+        request_path := entry.path
+        if i := strings.Index(request_path, "?"); i >= 0 {
+            request_path = request_path[:i]
+        }
+        if len(request_path) < 1 {
+            request_path = "(none)"
+        }
+        by_path[request_path] = append(by_path[request_path], rt)
+        path_counts[request_path]++
+
+        // group by status class, e.g. 2xx
+        status_class := strconv.Itoa(entry.status / 100) + "xx"
+        by_status[status_class] = append(by_status[status_class], rt)
+
+        // group by hour of the day
+        hour := fmt.Sprintf("%02d:00", entry.timestamp.Hour())
+        by_hour[hour] = append(by_hour[hour], rt)
+    }
+
+    // if no response times were present, say so
+    if len(all_times) < 1 {
+        return "No response times present in the log at this time."
+    }
+
+    // overall summary
+    overall := summarizeLatencies(all_times)
+    latency_strings += "Requests timed:  " + strconv.Itoa(overall.count) +
+      "\n"
+    latency_strings += "p50 / p90 / p99: " +
+      strings.TrimSpace(formatLatency(overall.p50)) + " / " +
+      strings.TrimSpace(formatLatency(overall.p90)) + " / " +
+      strings.TrimSpace(formatLatency(overall.p99)) + "\n"
+    latency_strings += "Max:             " +
+      strings.TrimSpace(formatLatency(overall.max)) + "\n"
+    if len(upstream_times) > 0 {
+        upstream := summarizeLatencies(upstream_times)
+        latency_strings += "Upstream p50 / p90 / p99: " +
+          strings.TrimSpace(formatLatency(upstream.p50)) + " / " +
+          strings.TrimSpace(formatLatency(upstream.p90)) + " / " +
+          strings.TrimSpace(formatLatency(upstream.p99)) + "\n"
+    }
+
+    // histogram of all response times
+    latency_strings += "\nLatency Histogram\n\n"
+    latency_strings += convertLatencyHistogramToString(all_times)
+
+    // slowest endpoints, by p90, of paths with enough requests to matter
+    slow_paths := make([]string, 0)
+    for name, times := range by_path {
+        if len(times) >= latencySlowMinRequests {
+            slow_paths = append(slow_paths, name)
+        }
+    }
+    slow_p90 := make(map[string] float64, len(slow_paths))
+    for _, name := range slow_paths {
+        slow_p90[name] = summarizeLatencies(by_path[name]).p90
+    }
+    sort.Slice(slow_paths, func(i, j int) bool {
+        if slow_p90[slow_paths[i]] != slow_p90[slow_paths[j]] {
+            return slow_p90[slow_paths[i]] > slow_p90[slow_paths[j]]
+        }
+        return slow_paths[i] < slow_paths[j]
+    })
+    if len(slow_paths) > 10 {
+        slow_paths = slow_paths[:10]
+    }
+    latency_strings += "\nSlowest Endpoints (by p90, at least " +
+      strconv.Itoa(latencySlowMinRequests) + " requests)\n\n"
+    if len(slow_paths) < 1 {
+        latency_strings += "None.\n"
+    } else {
+        latency_strings += convertLatencyGroupsToString(by_path, slow_paths)
+    }
+
+    // per path, busiest first
+    path_order := make([]string, 0)
+    for i, pair := range sortMapByCount(path_counts) {
+        if i >= latencyPathLimit {
+            break
+        }
+        path_order = append(path_order, pair.name)
+    }
+    latency_strings += "\nPer Path (busiest " +
+      strconv.Itoa(len(path_order)) + ")\n\n"
+    latency_strings += convertLatencyGroupsToString(by_path, path_order)
+
+    // per status class
+    status_order := make([]string, 0)
+    for name, _ := range by_status {
+        status_order = append(status_order, name)
+    }
+    sort.Strings(status_order)
+    latency_strings += "\nPer Status Class\n\n"
+    latency_strings += convertLatencyGroupsToString(by_status, status_order)
+
+    // per hour
+    hour_order := make([]string, 0)
+    for name, _ := range by_hour {
+        hour_order = append(hour_order, name)
+    }
+    sort.Strings(hour_order)
+    latency_strings += "\nPer Hour\n\n"
+    latency_strings += convertLatencyGroupsToString(by_hour, hour_order)
+
+    // everything worked fine, so return the completed string contents
+    return latency_strings
+}
+
+//! Draw an ASCII histogram of response times
+/*
+ * @param     float64[]    response times, in seconds
+ *
+ * @return    string       lines that contain "bucket | count | ####"
+ */
+func convertLatencyHistogramToString(times []float64) string {
+
+    // variable declaration
+    var histogram_strings string = ""
+
+    // upper limits of the buckets, in seconds
+    limits := []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+    labels := []string{"< 10ms", "10 - 50ms", "50 - 100ms", "100 - 250ms",
+      "250 - 500ms", "0.5 - 1s", "1 - 2.5s", "2.5 - 5s", "> 5s"}
+    counts := make([]int, len(labels))
+
+    // place every time into the first bucket it fits
+    for _, t := range times {
+        bucket := len(limits)
+        for i, limit := range limits {
+            if t < limit {
+                bucket = i
+                break
+            }
+        }
+        counts[bucket]++
+    }
+
+    // the largest bucket determines the scale of the bars
+    max := 0
+    for _, count := range counts {
+        if count > max {
+            max = count
+        }
+    }
+
+    for i, label := range labels {
+        histogram_strings += fmt.Sprintf("%-12s | %6d | %s\n", label,
+          counts[i], asciiBar(counts[i], max, 50))
+    }
+
+    return histogram_strings
+}
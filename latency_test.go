@@ -0,0 +1,69 @@
+//
+// Response time tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "testing"
+)
+
+func TestPercentile(t *testing.T) {
+
+    ten := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+    tests := []struct {
+        name   string
+        sorted []float64
+        p      float64
+        want   float64
+    }{
+        {"empty", nil, 50, 0},
+        {"empty slice", []float64{}, 99, 0},
+        {"single value, median", []float64{0.3}, 50, 0.3},
+        {"single value, minimum", []float64{0.3}, 0, 0.3},
+        {"single value, maximum", []float64{0.3}, 100, 0.3},
+        {"minimum", ten, 0, 1},
+        {"median", ten, 50, 5},
+        {"just past the median", ten, 51, 6},
+        {"90th", ten, 90, 9},
+        {"99th", ten, 99, 10},
+        {"maximum", ten, 100, 10},
+        {"below the range", ten, -5, 1},
+        {"above the range", ten, 150, 10},
+        {"two values, median", []float64{1, 2}, 50, 1},
+        {"ties", []float64{1, 1, 1, 5}, 75, 1},
+    }
+
+    for _, test := range tests {
+        got := percentile(test.sorted, test.p)
+        if got != test.want {
+            t.Errorf("%s: percentile(%v, %v) = %v, want %v", test.name,
+              test.sorted, test.p, got, test.want)
+        }
+    }
+}
+
+func TestSummarizeLatencies(t *testing.T) {
+
+    // the times are summarized in sorted order, yet left as they were
+    times := []float64{0.5, 0.1, 0.9, 0.3}
+    stats := summarizeLatencies(times)
+    if stats != (latencyStats{count: 4, p50: 0.3, p90: 0.9, p99: 0.9,
+      max: 0.9}) {
+        t.Errorf("stats = %+v", stats)
+    }
+    if times[0] != 0.5 || times[3] != 0.3 {
+        t.Errorf("times reordered: %v", times)
+    }
+
+    if stats = summarizeLatencies(nil); stats != (latencyStats{}) {
+        t.Errorf("stats of no times = %+v", stats)
+    }
+}
@@ -0,0 +1,315 @@
+//
+// Block ledger functions for ASCII-log
+//
+// The ledger keeps track of every blocked address or CIDR between runs, one
+// per line, with tab separated columns:
+//
+// # target  first blocked  expires  last seen  hits  offences  source  reason
+//
+// The source is either auto, for blocks made by the policy, or manual, for
+// blocks made via the block subcommand; manual blocks are left alone by the
+// automated runs.
+//
+// Blocks stay in force until they expire, even if the address is quiet in
+// the meantime. Expired blocks are remembered for a while longer, so that an
+// address that offends again is blocked for longer than the time before.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Layout of the times stored in the ledger.
+const ledgerTimeLayout = time.RFC3339
+
+//! A single blocked address or CIDR
+type blockEntry struct {
+    target        string
+    reason        string
+    first_blocked time.Time
+    expires       time.Time
+    last_seen     time.Time
+    hits          int
+    offences      int
+    manual        bool
+}
+
+//! Check whether a block is still in force
+/*
+ * @param     Time    current time
+ *
+ * @return    bool    whether or not the block has yet to expire
+ */
+func (b *blockEntry) active(now time.Time) bool {
+    return now.Before(b.expires)
+}
+
+//! Read the block ledger
+/*
+ * @param     string    /path/to/file
+ *
+ * @return    map       map of targets and their blocks
+ * @return    error     error message, if any
+ */
+func readBlockLedger(path string) (map[string] *blockEntry, error) {
+
+    // variable declaration
+    var ledger = make(map[string] *blockEntry)
+
+    // a missing file simply means nothing has been blocked yet
+    byte_contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return ledger, nil
+    }
+
+    for i, line := range strings.Split(string(byte_contents), "\n") {
+
+        if len(strings.TrimSpace(line)) < 1 ||
+          strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        pieces := strings.SplitN(line, "\t", 8)
+        if len(pieces) < 8 {
+            return nil, fmt.Errorf("readBlockLedger() --> line %d of %s " +
+              "has too few columns", i+1, path)
+        }
+
+        entry := &blockEntry{target: pieces[0], reason: pieces[7],
+          manual: pieces[6] == "manual"}
+        entry.first_blocked, err = time.Parse(ledgerTimeLayout, pieces[1])
+        if err == nil {
+            entry.expires, err = time.Parse(ledgerTimeLayout, pieces[2])
+        }
+        if err == nil {
+            entry.last_seen, err = time.Parse(ledgerTimeLayout, pieces[3])
+        }
+        if err == nil {
+            entry.hits, err = strconv.Atoi(pieces[4])
+        }
+        if err == nil {
+            entry.offences, err = strconv.Atoi(pieces[5])
+        }
+        if err != nil {
+            return nil, fmt.Errorf("readBlockLedger() --> line %d of %s " +
+              "is improper: %s", i+1, path, err.Error())
+        }
+
+        ledger[normalizeFirewallTarget(entry.target)] = entry
+    }
+
+    return ledger, nil
+}
+
+//! Write the block ledger
+/*
+ * @param     string    /path/to/file
+ * @param     map       map of targets and their blocks
+ *
+ * @return    error     error message, if any
+ */
+func writeBlockLedger(path string, ledger map[string] *blockEntry) error {
+
+    // variable declaration
+    var contents string = ""
+
+    contents += "# target\tfirst blocked\texpires\tlast seen\thits\t" +
+      "offences\tsource\treason\n"
+
+    for _, entry := range sortBlockEntries(ledger) {
+        source := "auto"
+        if entry.manual {
+            source = "manual"
+        }
+        contents += strings.Join([]string{entry.target,
+          entry.first_blocked.Format(ledgerTimeLayout),
+          entry.expires.Format(ledgerTimeLayout),
+          entry.last_seen.Format(ledgerTimeLayout),
+          strconv.Itoa(entry.hits), strconv.Itoa(entry.offences), source,
+          entry.reason}, "\t") + "\n"
+    }
+
+    return writeLogFile(path, contents)
+}
+
+//! Obtain the blocks of the ledger, sorted by target
+/*
+ * @param     map             map of targets and their blocks
+ *
+ * @return    blockEntry[]    sorted blocks
+ */
+func sortBlockEntries(ledger map[string] *blockEntry) []*blockEntry {
+
+    // variable declaration
+    var result = make([]*blockEntry, 0, len(ledger))
+
+    for _, entry := range ledger {
+        result = append(result, entry)
+    }
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].target < result[j].target
+    })
+
+    return result
+}
+
+//! Obtain the length of a block, taking earlier offences into account
+/*
+ * @param     Duration    base length of the block
+ * @param     int         offences so far, including this one
+ * @param     float64     factor to multiply by for every repeat offence
+ * @param     Duration    longest allowed block, or 0 for no limit
+ *
+ * @return    Duration    length of the block
+ */
+func obtainEscalatedTTL(ttl time.Duration, offences int, factor float64,
+  max_ttl time.Duration) time.Duration {
+
+    escalated := float64(ttl)
+    for i := 1; i < offences; i++ {
+        escalated *= factor
+        if max_ttl > 0 && escalated >= float64(max_ttl) {
+            return max_ttl
+        }
+    }
+
+    if max_ttl > 0 && time.Duration(escalated) > max_ttl {
+        return max_ttl
+    }
+    return time.Duration(escalated)
+}
+
+//! Record that an address or CIDR ought to be blocked
+/*
+ * @param     map         map of targets and their blocks
+ * @param     string      IP address or CIDR
+ * @param     string      reason; e.g. the name of the policy rule
+ * @param     Duration    base length of the block
+ * @param     Time        current time
+ *
+ * @return    blockEntry  updated block
+ */
+func recordBlock(ledger map[string] *blockEntry, target string,
+  reason string, ttl time.Duration, now time.Time) *blockEntry {
+
+    target = normalizeFirewallTarget(target)
+    entry, exists := ledger[target]
+
+    // first offence
+    if !exists {
+        entry = &blockEntry{target: target, reason: reason,
+          first_blocked: now, offences: 1}
+        ledger[target] = entry
+
+    // a repeat offence, after an earlier block expired, so escalate
+    } else if !entry.active(now) {
+        entry.offences++
+        entry.reason = reason
+    }
+
+    entry.hits++
+    entry.last_seen = now
+
+    // manual blocks keep the length and reason they were given
+    if entry.manual && entry.active(now) {
+        return entry
+    }
+    entry.manual = false
+
+    // a block still in force is extended, but never shortened
+    expires := now.Add(obtainEscalatedTTL(ttl, entry.offences,
+      blockTTLFactor, blockMaxTTL))
+    if expires.After(entry.expires) {
+        entry.expires = expires
+    }
+
+    return entry
+}
+
+//! Block an address or CIDR by hand, for a given length of time
+/*
+ * @param     map         map of targets and their blocks
+ * @param     string      IP address or CIDR
+ * @param     string      reason
+ * @param     Duration    length of the block
+ * @param     Time        current time
+ *
+ * @return    blockEntry  new or updated block
+ */
+func recordManualBlock(ledger map[string] *blockEntry, target string,
+  reason string, ttl time.Duration, now time.Time) *blockEntry {
+
+    target = normalizeFirewallTarget(target)
+    entry, exists := ledger[target]
+    if !exists {
+        entry = &blockEntry{target: target, first_blocked: now}
+        ledger[target] = entry
+    }
+
+    // unlike automated blocks, the given length is used as is, since it
+    // was chosen by hand
+    entry.reason = reason
+    entry.manual = true
+    entry.offences++
+    entry.hits++
+    entry.last_seen = now
+    entry.expires = now.Add(ttl)
+
+    return entry
+}
+
+//! Forget the blocks that expired long enough ago
+/*
+ * @param     map         map of targets and their blocks
+ * @param     Time        current time
+ * @param     Duration    how long expired blocks are remembered for
+ *
+ * @return    int         number of blocks forgotten
+ */
+func pruneBlockLedger(ledger map[string] *blockEntry, now time.Time,
+  memory time.Duration) int {
+
+    pruned := 0
+    for target, entry := range ledger {
+        if now.After(entry.expires.Add(memory)) {
+            delete(ledger, target)
+            pruned++
+        }
+    }
+    return pruned
+}
+
+//! Obtain the blocks still in force, sorted by target
+/*
+ * @param     map             map of targets and their blocks
+ * @param     Time            current time
+ *
+ * @return    blockEntry[]    active blocks
+ */
+func obtainActiveBlocks(ledger map[string] *blockEntry,
+  now time.Time) []*blockEntry {
+
+    // variable declaration
+    var result = make([]*blockEntry, 0)
+
+    for _, entry := range sortBlockEntries(ledger) {
+        if entry.active(now) {
+            result = append(result, entry)
+        }
+    }
+
+    return result
+}
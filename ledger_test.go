@@ -0,0 +1,212 @@
+//
+// Block ledger tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestObtainEscalatedTTL(t *testing.T) {
+
+    tests := []struct {
+        name     string
+        ttl      time.Duration
+        offences int
+        factor   float64
+        max_ttl  time.Duration
+        want     time.Duration
+    }{
+        {"first offence", time.Hour, 1, 2, 0, time.Hour},
+        {"no offences yet", time.Hour, 0, 2, 0, time.Hour},
+        {"third offence", time.Hour, 3, 2, 0, 4 * time.Hour},
+        {"fractional factor", time.Hour, 2, 1.5, 0, 90 * time.Minute},
+        {"factor of one", time.Hour, 9, 1, 0, time.Hour},
+        {"capped once past the max", time.Hour, 4, 2, 6 * time.Hour,
+          6 * time.Hour},
+        {"exactly the max", time.Hour, 4, 2, 8 * time.Hour, 8 * time.Hour},
+        {"base longer than the max", 2 * time.Hour, 1, 2, time.Hour,
+          time.Hour},
+        {"many offences stay capped", time.Hour, 1000, 2, 48 * time.Hour,
+          48 * time.Hour},
+    }
+
+    for _, test := range tests {
+        got := obtainEscalatedTTL(test.ttl, test.offences, test.factor,
+          test.max_ttl)
+        if got != test.want {
+            t.Errorf("%s: obtainEscalatedTTL() = %v, want %v", test.name,
+              got, test.want)
+        }
+    }
+}
+
+func TestRecordBlock(t *testing.T) {
+
+    // the settings of a real run, put back once done
+    saved_factor, saved_max := blockTTLFactor, blockMaxTTL
+    defer func() { blockTTLFactor, blockMaxTTL = saved_factor, saved_max }()
+    blockTTLFactor, blockMaxTTL = 2, 6 * time.Hour
+
+    now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+
+    // every step records the block at the given time since the start
+    tests := []struct {
+        name     string
+        after    time.Duration
+        reason   string
+        offences int
+        hits     int
+        expires  time.Duration
+    }{
+        {"first offence", 0, "busy", 1, 1, time.Hour},
+        {"seen again while blocked, no escalation", 30 * time.Minute,
+          "redirects", 1, 2, 90 * time.Minute},
+        {"offending after the block expired", 2 * time.Hour, "busy", 2, 3,
+          4 * time.Hour},
+        {"third offence", 5 * time.Hour, "busy", 3, 4, 9 * time.Hour},
+        {"fourth offence, capped", 10 * time.Hour, "busy", 4, 5,
+          16 * time.Hour},
+    }
+
+    ledger := make(map[string] *blockEntry)
+    for _, test := range tests {
+
+        entry := recordBlock(ledger, "192.0.2.1", test.reason, time.Hour,
+          now.Add(test.after))
+
+        if entry.offences != test.offences || entry.hits != test.hits {
+            t.Errorf("%s: offences, hits = %d, %d, want %d, %d", test.name,
+              entry.offences, entry.hits, test.offences, test.hits)
+        }
+        if !entry.expires.Equal(now.Add(test.expires)) {
+            t.Errorf("%s: expires = %v, want %v", test.name, entry.expires,
+              now.Add(test.expires))
+        }
+        if !entry.first_blocked.Equal(now) {
+            t.Errorf("%s: first blocked = %v, want %v", test.name,
+              entry.first_blocked, now)
+        }
+    }
+
+    // the reason is only replaced by a new offence
+    if ledger["192.0.2.1"].reason != "busy" {
+        t.Errorf("reason = %q, want %q", ledger["192.0.2.1"].reason, "busy")
+    }
+
+    // a block still in force is never shortened
+    entry := recordBlock(ledger, "192.0.2.1", "busy", time.Minute,
+      now.Add(11 * time.Hour))
+    if !entry.expires.Equal(now.Add(16 * time.Hour)) {
+        t.Errorf("block shortened to %v", entry.expires)
+    }
+}
+
+func TestPruneBlockLedger(t *testing.T) {
+
+    now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+
+    tests := []struct {
+        name    string
+        expires time.Duration
+        memory  time.Duration
+        kept    bool
+    }{
+        {"still in force", time.Hour, 0, true},
+        {"expired, no memory", -time.Minute, 0, false},
+        {"expired, still remembered", -time.Hour, 24 * time.Hour, true},
+        {"expired, remembered until now", -time.Hour, time.Hour, true},
+        {"expired, forgotten", -25 * time.Hour, 24 * time.Hour, false},
+    }
+
+    for _, test := range tests {
+
+        ledger := map[string] *blockEntry{"192.0.2.1": {target: "192.0.2.1",
+          expires: now.Add(test.expires)}}
+
+        pruned := pruneBlockLedger(ledger, now, test.memory)
+        _, kept := ledger["192.0.2.1"]
+        if kept != test.kept || (pruned == 0) != test.kept {
+            t.Errorf("%s: kept = %v, pruned %d, want kept = %v", test.name,
+              kept, pruned, test.kept)
+        }
+    }
+}
+
+func TestBlockLedgerRoundTrip(t *testing.T) {
+
+    path := filepath.Join(t.TempDir(), "blocks.ledger")
+    now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+    // a missing ledger is an empty one
+    ledger, err := readBlockLedger(path)
+    if err != nil || len(ledger) != 0 {
+        t.Fatalf("missing ledger = %v, %v, want empty", ledger, err)
+    }
+
+    ledger = map[string] *blockEntry{
+        "192.0.2.1": {target: "192.0.2.1", reason: "busy",
+          first_blocked: now, expires: now.Add(time.Hour),
+          last_seen: now.Add(time.Minute), hits: 3, offences: 2},
+        "2001:db8::/64": {target: "2001:db8::/64",
+          reason: "blocked by hand, with spaces", first_blocked: now,
+          expires: now.Add(48 * time.Hour), last_seen: now, hits: 1,
+          offences: 1, manual: true},
+    }
+
+    err = writeBlockLedger(path, ledger)
+    if err != nil {
+        t.Fatalf("unable to write the ledger: %v", err)
+    }
+    read, err := readBlockLedger(path)
+    if err != nil {
+        t.Fatalf("unable to read the ledger back: %v", err)
+    }
+
+    if len(read) != len(ledger) {
+        t.Fatalf("%d blocks read back, want %d", len(read), len(ledger))
+    }
+    for target, want := range ledger {
+        got, exists := read[target]
+        if !exists {
+            t.Errorf("%s not read back", target)
+            continue
+        }
+        if got.target != want.target || got.reason != want.reason ||
+          got.hits != want.hits || got.offences != want.offences ||
+          got.manual != want.manual ||
+          !got.first_blocked.Equal(want.first_blocked) ||
+          !got.expires.Equal(want.expires) ||
+          !got.last_seen.Equal(want.last_seen) {
+            t.Errorf("%s read back as %+v, want %+v", target, got, want)
+        }
+    }
+
+    // improper lines are an error, rather than silently dropped
+    for _, contents := range []string{
+        "192.0.2.1\tnot enough columns\n",
+        "192.0.2.1\tyesterday\t2026-01-02T03:04:05Z\t" +
+          "2026-01-02T03:04:05Z\t1\t1\tauto\tbusy\n",
+        "192.0.2.1\t2026-01-02T03:04:05Z\t2026-01-02T03:04:05Z\t" +
+          "2026-01-02T03:04:05Z\tmany\t1\tauto\tbusy\n",
+    } {
+        err = ioutil.WriteFile(path, []byte(contents), 0644)
+        if err != nil {
+            t.Fatalf("unable to write the ledger: %v", err)
+        }
+        _, err = readBlockLedger(path)
+        if err == nil {
+            t.Errorf("improper ledger accepted: %q", contents)
+        }
+    }
+}
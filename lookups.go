@@ -0,0 +1,291 @@
+//
+// Concurrent lookup functions for ASCII-log
+//
+// Whois, RDAP and reverse DNS lookups, including the forward-confirmed ones
+// of claimed crawlers and allowlisted domains, spend nearly all of their
+// time waiting on the network, so they are made by a bounded pool of
+// workers rather than one at a time. Every backend has its own number of
+// workers, and its own rate limit, so that a busy day does not get the
+// server blocked by the registries. Every lookup has a timeout, and the run
+// as a whole can be given a deadline, after which the lookups not yet made
+// are given up on.
+//
+// The results are passed back in the order of the given items, so that the
+// reports come out the same no matter which lookup finished first.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "net"
+    "sync"
+    "time"
+)
+
+//! Spaces out the lookups made to a backend, shared by all of its workers
+type rateLimiter struct {
+    mutex    sync.Mutex
+    interval time.Duration
+    next     time.Time
+}
+
+//! Assemble a rate limiter
+/*
+ * @param     float64        most lookups per second, or 0 for no limit
+ *
+ * @return    rateLimiter    rate limiter, or nil if there is no limit
+ */
+func newRateLimiter(rate float64) *rateLimiter {
+
+    if rate <= 0 {
+        return nil
+    }
+    return &rateLimiter{interval: time.Duration(float64(time.Second) /
+      rate)}
+}
+
+//! Wait until the next lookup may be made
+/*
+ * @param     Context    context of the lookup
+ *
+ * @return    error      error message, if the lookup was cancelled
+ */
+func (r *rateLimiter) wait(ctx context.Context) error {
+
+    if r == nil {
+        return ctx.Err()
+    }
+
+    // reserve the next free moment, so that waiting workers are spaced
+    // out rather than all let go at once
+    r.mutex.Lock()
+    now := time.Now()
+    start := r.next
+    if start.Before(now) {
+        start = now
+    }
+    r.next = start.Add(r.interval)
+    r.mutex.Unlock()
+
+    delay := start.Sub(now)
+    if delay <= 0 {
+        return ctx.Err()
+    }
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+//! Run a lookup for every item, with a bounded number of workers
+/*
+ * @param     Context     context of the run; once done, the lookups not
+ *                        yet started are given up on
+ * @param     int         number of items to look up
+ * @param     int         number of workers
+ * @param     Duration    timeout of every lookup, or 0 for none
+ * @param     func        lookup function, given the index of the item; it
+ *                        keeps its own result, in the order of the items
+ *
+ * @return    error[]     errors, in the order of the items
+ */
+func runLookups(ctx context.Context, count int, workers int,
+  timeout time.Duration, lookup func(context.Context, int) error) []error {
+
+    // variable declaration
+    var errs = make([]error, count)
+    var jobs = make(chan int)
+    var wg sync.WaitGroup
+
+    if workers < 1 {
+        workers = 1
+    }
+    if workers > count {
+        workers = count
+    }
+
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range jobs {
+                var lookup_ctx context.Context = ctx
+                var cancel context.CancelFunc = func() {}
+                if timeout > 0 {
+                    lookup_ctx, cancel = context.WithTimeout(ctx, timeout)
+                }
+                errs[i] = lookup(lookup_ctx, i)
+                cancel()
+            }
+        }()
+    }
+
+    // hand out the items in order, until the run is cancelled
+    for i := 0; i < count; i++ {
+        if ctx.Err() == nil {
+            select {
+            case jobs <- i:
+                continue
+            case <-ctx.Done():
+            }
+        }
+        errs[i] = ctx.Err()
+    }
+    close(jobs)
+    wg.Wait()
+
+    return errs
+}
+
+//! Look up the first hostname of every IP address
+/*
+ * @param     Context     context of the run
+ * @param     string[]    IP addresses
+ *
+ * @return    string[]    hostnames, in the order of the addresses; blank if
+ *                        none could be found
+ */
+func obtainHostnames(ctx context.Context, ips []string) []string {
+
+    // variable declaration
+    var hostnames = make([]string, len(ips))
+
+    limiter := newRateLimiter(rdnsRate)
+
+    runLookups(ctx, len(ips), rdnsWorkers, lookupTimeout,
+      func(ctx context.Context, i int) error {
+        if err := limiter.wait(ctx); err != nil {
+            return err
+        }
+        names, err := net.DefaultResolver.LookupAddr(ctx, ips[i])
+        if err != nil || len(names) < 1 {
+            return err
+        }
+        hostnames[i] = names[0]
+        return nil
+    })
+
+    return hostnames
+}
+
+//! Forward-confirm the reverse DNS of every IP address against some domains
+/*
+ * @param     Context       context of the run
+ * @param     string[]      IP addresses
+ * @param     string[][]    domains the hostname of each address has to be
+ *                          within
+ *
+ * @return    string[]      confirmed hostnames, in the order of the
+ *                          addresses; blank if none
+ * @return    error[]       errors, in the order of the addresses; set if no
+ *                          hostname was confirmed and a lookup failed
+ */
+func obtainForwardConfirmations(ctx context.Context, ips []string,
+  suffixes [][]string) ([]string, []error) {
+
+    // variable declaration
+    var hostnames = make([]string, len(ips))
+
+    limiter := newRateLimiter(rdnsRate)
+
+    errs := runLookups(ctx, len(ips), rdnsWorkers, lookupTimeout,
+      func(ctx context.Context, i int) error {
+        if err := limiter.wait(ctx); err != nil {
+            return err
+        }
+        var err error
+        hostnames[i], err = forwardConfirmHostname(ctx, ips[i], suffixes[i])
+        return err
+    })
+
+    return hostnames, errs
+}
+
+//! Look up the whois record of every IP address, from the cache if possible
+/*
+ * @param     Context        context of the run
+ * @param     string[]       IP addresses
+ *
+ * @return    whoisResult[]  whois or RDAP records, in the order of the
+ *                           addresses
+ * @return    error[]        errors, in the order of the addresses
+ */
+func obtainWhoisResults(ctx context.Context, ips []string) ([]whoisResult,
+  []error) {
+
+    // variable declaration
+    var results = make([]whoisResult, len(ips))
+
+    limiter := newRateLimiter(whoisRate)
+    now := time.Now()
+
+    errs := runLookups(ctx, len(ips), whoisWorkers, lookupTimeout,
+      func(ctx context.Context, i int) error {
+        var err error
+        results[i], _, err = obtainWhoisResult(ctx, whoisCacheEntries,
+          limiter, ips[i], now)
+        return err
+    })
+
+    return results, errs
+}
+
+//! Obtain the context of a single lookup, which times out after
+//! --lookup-timeout, if set
+/*
+ * @param     Context       context of the run
+ *
+ * @return    Context       context of the lookup
+ * @return    CancelFunc    releases the context once the lookup is done
+ */
+func obtainSingleLookupContext(ctx context.Context) (context.Context,
+  context.CancelFunc) {
+
+    if lookupTimeout > 0 {
+        return context.WithTimeout(ctx, lookupTimeout)
+    }
+    return context.WithCancel(ctx)
+}
+
+//! Obtain the context of the lookups of a run
+/*
+ * @return    Context       context, done once the lookup deadline passes
+ * @return    CancelFunc    releases the context once the lookups are done
+ */
+func obtainLookupContext() (context.Context, context.CancelFunc) {
+
+    if lookupDeadline > 0 {
+        return context.WithTimeout(context.Background(), lookupDeadline)
+    }
+    return context.WithCancel(context.Background())
+}
+
+//! Check whether a lookup was cut short by its context, rather than failed
+//! by the server
+/*
+ * @param     Context    context of the lookup
+ *
+ * @return    bool       whether or not the context is done, or its deadline
+ *                       has passed
+ */
+func lookupCutShort(ctx context.Context) bool {
+
+    // a connection deadline taken from the context can pass a moment
+    // before the context itself is marked done
+    deadline, has_deadline := ctx.Deadline()
+    return ctx.Err() != nil || (has_deadline &&
+      !time.Now().Before(deadline))
+}
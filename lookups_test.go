@@ -0,0 +1,192 @@
+//
+// Concurrent lookup tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestRunLookups(t *testing.T) {
+
+    // variable declaration
+    var mutex sync.Mutex
+    var running, most int
+    var results = make([]string, 20)
+
+    // every item is looked up once, its result kept in order, with no more
+    // than the given workers at once
+    errs := runLookups(context.Background(), len(results), 3, 0,
+      func(ctx context.Context, i int) error {
+        mutex.Lock()
+        running++
+        if running > most {
+            most = running
+        }
+        mutex.Unlock()
+
+        time.Sleep(5 * time.Millisecond)
+        results[i] = fmt.Sprintf("item %d", i)
+
+        mutex.Lock()
+        running--
+        mutex.Unlock()
+
+        if i % 5 == 0 {
+            return fmt.Errorf("item %d failed", i)
+        }
+        return nil
+    })
+
+    if most > 3 || most < 2 {
+        t.Errorf("%d lookups ran at once, want 2 to 3", most)
+    }
+    for i := range results {
+        if results[i] != fmt.Sprintf("item %d", i) {
+            t.Errorf("result %d = %q", i, results[i])
+        }
+        if (errs[i] != nil) != (i % 5 == 0) {
+            t.Errorf("error %d = %v", i, errs[i])
+        }
+    }
+}
+
+func TestRunLookupsEdgeCases(t *testing.T) {
+
+    // nothing to look up
+    errs := runLookups(context.Background(), 0, 4, 0,
+      func(ctx context.Context, i int) error {
+        t.Errorf("lookup %d made for no items", i)
+        return nil
+    })
+    if len(errs) != 0 {
+        t.Errorf("%d errors for no items", len(errs))
+    }
+
+    // no workers still means one
+    calls := 0
+    errs = runLookups(context.Background(), 3, 0, 0,
+      func(ctx context.Context, i int) error {
+        calls++
+        return nil
+    })
+    if calls != 3 || len(errs) != 3 {
+        t.Errorf("%d lookups, %d errors, want 3 of each", calls, len(errs))
+    }
+
+    // every lookup has its own timeout, which a slow one runs into
+    start := time.Now()
+    errs = runLookups(context.Background(), 2, 2, 20 * time.Millisecond,
+      func(ctx context.Context, i int) error {
+        if i == 0 {
+            return nil
+        }
+        <-ctx.Done()
+        return ctx.Err()
+    })
+    if errs[0] != nil || errs[1] != context.DeadlineExceeded {
+        t.Errorf("errors = %v, want only the slow lookup timed out", errs)
+    }
+    if time.Since(start) > time.Second {
+        t.Errorf("timed out lookup took %v", time.Since(start))
+    }
+}
+
+func TestRunLookupsCancelled(t *testing.T) {
+
+    // a run past its deadline makes no lookups at all
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    errs := runLookups(ctx, 3, 2, 0, func(ctx context.Context, i int) error {
+        t.Errorf("lookup %d made after the deadline", i)
+        return nil
+    })
+    for i, err := range errs {
+        if err != context.Canceled {
+            t.Errorf("error %d = %v, want %v", i, err, context.Canceled)
+        }
+    }
+
+    // a run cut short gives up on the items not yet handed out
+    ctx, cancel = context.WithCancel(context.Background())
+    defer cancel()
+    made := make([]bool, 10)
+    errs = runLookups(ctx, len(made), 1, 0,
+      func(ctx context.Context, i int) error {
+        made[i] = true
+        if i == 2 {
+            cancel()
+        }
+        return nil
+    })
+    for i := range made {
+        if i <= 2 && (!made[i] || errs[i] != nil) {
+            t.Errorf("item %d: made = %v, error = %v", i, made[i], errs[i])
+        }
+        if i > 3 && (made[i] || errs[i] != context.Canceled) {
+            t.Errorf("item %d: made = %v, error = %v", i, made[i], errs[i])
+        }
+    }
+}
+
+func TestRateLimiter(t *testing.T) {
+
+    // no limit, unless the lookup is cancelled
+    limiter := newRateLimiter(0)
+    if limiter != nil {
+        t.Fatalf("rate of 0 gave a limiter")
+    }
+    if err := limiter.wait(context.Background()); err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+    cancelled, cancel := context.WithCancel(context.Background())
+    cancel()
+    if err := limiter.wait(cancelled); err != context.Canceled {
+        t.Errorf("cancelled wait = %v, want %v", err, context.Canceled)
+    }
+
+    // waiting workers are spaced out, rather than let go at once
+    limiter = newRateLimiter(50)
+    var wg sync.WaitGroup
+    start := time.Now()
+    for w := 0; w < 5; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := limiter.wait(context.Background()); err != nil {
+                t.Errorf("unexpected error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+    if elapsed := time.Since(start); elapsed < 75 * time.Millisecond ||
+      elapsed > time.Second {
+        t.Errorf("5 lookups at 50 per second took %v, want about 80ms",
+          elapsed)
+    }
+
+    // a cancelled lookup stops waiting straight away
+    limiter = newRateLimiter(0.5)
+    limiter.wait(context.Background())
+    ctx, cancel := context.WithTimeout(context.Background(),
+      20 * time.Millisecond)
+    defer cancel()
+    start = time.Now()
+    if err := limiter.wait(ctx); err != context.DeadlineExceeded {
+        t.Errorf("wait = %v, want %v", err, context.DeadlineExceeded)
+    }
+    if time.Since(start) > time.Second {
+        t.Errorf("cancelled wait took %v", time.Since(start))
+    }
+}
@@ -0,0 +1,220 @@
+//
+// HTTP method and protocol functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "strings"
+)
+
+// HTTP methods listed individually in the methods log, all others are
+// grouped together as "other".
+var knownMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE",
+  "OPTIONS", "CONNECT", "PROPFIND"}
+
+// Kinds of odd requests, as flagged in the methods log.
+const (
+    oddConnect         = "CONNECT attempt"
+    oddBinary          = "binary / TLS handshake"
+    oddEmpty           = "empty request line"
+    oddMissingProtocol = "missing protocol"
+    oddBadMethod       = "malformed method"
+)
+
+//! Determine whether a request line is odd, and if so, in what way
+/*
+ * @param     logEntry    parsed log entry
+ *
+ * @return    string      kind of oddity, or blank if it is a normal request
+ */
+func classifyRequest(entry logEntry) string {
+
+    request := entry.request
+
+    // nothing at all was sent; e.g. a port scanner opening a connection
+    if len(request) < 1 || request == "-" {
+        return oddEmpty
+    }
+
+    // servers log raw bytes as \xNN escapes; a TLS handshake sent to a
+    // plain HTTP port starts with \x16\x03
+    if strings.Contains(request, "\\x") {
+        return oddBinary
+    }
+    for i := 0; i < len(request); i++ {
+        if request[i] < 0x20 || request[i] > 0x7e {
+            return oddBinary
+        }
+    }
+
+    // methods are always upper case letters
+    if len(entry.method) < 1 ||
+      strings.Trim(entry.method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+        return oddBadMethod
+    }
+
+    // CONNECT is used to probe for open proxies
+    if entry.method == "CONNECT" {
+        return oddConnect
+    }
+
+    // every request since HTTP/1.0 names the protocol
+    if len(entry.protocol) < 1 ||
+      !strings.HasPrefix(entry.protocol, "HTTP/") {
+        return oddMissingProtocol
+    }
+
+    return ""
+}
+
+//! Normalize the protocol version of a request
+/*
+ * @param     string    protocol; e.g. HTTP/2.0
+ *
+ * @return    string    normalized protocol; e.g. HTTP/2
+ */
+func normalizeProtocol(protocol string) string {
+
+    switch protocol {
+    case "":
+        return "none"
+    case "HTTP/1.0", "HTTP/1.1":
+        return protocol
+    case "HTTP/2", "HTTP/2.0":
+        return "HTTP/2"
+    case "HTTP/3", "HTTP/3.0":
+        return "HTTP/3"
+    }
+    return "other"
+}
+
+//! Convert a distribution of counts into lines of the methods log
+/*
+ * @param     string[]    names, in the order they are listed
+ * @param     map         string map containing names and counts
+ * @param     int         total count
+ *
+ * @return    string      lines that contain "name | count | % | ####"
+ */
+func convertDistributionToString(order []string, counts map[string] int,
+  total int) string {
+
+    // variable declaration
+    var distribution_strings string = ""
+    var max int = 0
+
+    for _, name := range order {
+        if counts[name] > max {
+            max = counts[name]
+        }
+    }
+
+    for _, name := range order {
+        percent := 0.0
+        if total > 0 {
+            percent = 100 * float64(counts[name]) / float64(total)
+        }
+        distribution_strings += fmt.Sprintf("%-10s | %7d | %5.1f%% | %s\n",
+          name, counts[name], percent, asciiBar(counts[name], max, 40))
+    }
+
+    return distribution_strings
+}
+
+//! Assemble the contents of the methods log
+/*
+ * @param     logEntry[]    array of parsed log entries
+ *
+ * @return    string        methods report
+ */
+func obtainMethodReport(entries []logEntry) string {
+
+    // variable declaration
+    var method_strings string = ""
+    var methods = make(map[string] int)
+    var protocols = make(map[string] int)
+    var oddities = make(map[string] map[string] int)
+    var odd_samples = make(map[string] string)
+
+    // if no entries present, append a line about there being no data
+    if len(entries) < 1 {
+        return "No requests listed at this time."
+    }
+
+    // tally the method, protocol and oddity of every request
+    for _, entry := range entries {
+
+        oddity := classifyRequest(entry)
+
+        // malformed requests do not have a meaningful method or protocol
+        if oddity == "" || oddity == oddConnect ||
+          oddity == oddMissingProtocol {
+            method := entry.method
+            if !isStringInArray(method, knownMethods) {
+                method = "other"
+            }
+            methods[method]++
+            protocols[normalizeProtocol(entry.protocol)]++
+        } else {
+            methods["malformed"]++
+            protocols["none"]++
+        }
+
+        if oddity == "" {
+            continue
+        }
+
+        if oddities[oddity] == nil {
+            oddities[oddity] = make(map[string] int)
+            odd_samples[oddity] = entry.request
+        }
+        oddities[oddity][entry.ip]++
+    }
+
+    // method distribution
+    method_strings += "Methods\n\n"
+    method_strings += convertDistributionToString(
+      append(append([]string{}, knownMethods...), "other", "malformed"),
+      methods, len(entries))
+
+    // protocol distribution
+    method_strings += "\nProtocols\n\n"
+    method_strings += convertDistributionToString([]string{"HTTP/1.0",
+      "HTTP/1.1", "HTTP/2", "HTTP/3", "other", "none"}, protocols,
+      len(entries))
+
+    // oddities, along with the addresses responsible for them
+    method_strings += "\nOdd Requests\n\n"
+    if len(oddities) < 1 {
+        method_strings += "None.\n"
+    }
+    for _, oddity := range []string{oddConnect, oddBinary, oddEmpty,
+      oddMissingProtocol, oddBadMethod} {
+
+        ips, exists := oddities[oddity]
+        if !exists {
+            continue
+        }
+
+        sample := odd_samples[oddity]
+        if len(sample) > 60 {
+            sample = sample[:57] + "..."
+        }
+        method_strings += oddity + " (e.g. \"" + sample + "\")\n"
+        for _, pair := range sortMapByCount(ips) {
+            method_strings += fmt.Sprintf("%7d | %s\n", pair.count,
+              pair.name)
+        }
+        method_strings += "\n"
+    }
+
+    return strings.TrimRight(method_strings, "\n") + "\n"
+}
@@ -0,0 +1,132 @@
+//
+// HTTP method and protocol tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "strings"
+    "testing"
+)
+
+//! Assemble a log entry from a request line, split as parseLogLine does
+/*
+ * @param     string      request line; e.g. GET / HTTP/1.1
+ *
+ * @return    logEntry    entry holding the request, method and protocol
+ */
+func assembleMethodTestEntry(request string) logEntry {
+
+    entry := logEntry{ip: "192.0.2.1", request: request}
+    request_pieces := strings.Split(request, " ")
+    if len(request_pieces) >= 2 {
+        entry.method = request_pieces[0]
+        entry.path = request_pieces[1]
+    }
+    if len(request_pieces) >= 3 {
+        entry.protocol = request_pieces[len(request_pieces)-1]
+    }
+    return entry
+}
+
+func TestClassifyRequest(t *testing.T) {
+
+    tests := []struct {
+        request string
+        want    string
+    }{
+        {"GET / HTTP/1.1", ""},
+        {"POST /login HTTP/2.0", ""},
+        {"PROPFIND /dav/ HTTP/1.1", ""},
+        {"GET /a b HTTP/1.1", ""},
+        {"", oddEmpty},
+        {"-", oddEmpty},
+        {"\\x16\\x03\\x01\\x00\\xa5\\x01", oddBinary},
+        {"GET /\x00 HTTP/1.1", oddBinary},
+        {"GET /caf\xc3\xa9 HTTP/1.1", oddBinary},
+        {"GET\t/ HTTP/1.1", oddBinary},
+        {"/", oddBadMethod},
+        {"get / HTTP/1.1", oddBadMethod},
+        {"G3T / HTTP/1.1", oddBadMethod},
+        {" / HTTP/1.1", oddBadMethod},
+        {"CONNECT example.com:443 HTTP/1.1", oddConnect},
+        {"CONNECT example.com:443", oddConnect},
+        {"GET /", oddMissingProtocol},
+        {"GET / FTP/1.0", oddMissingProtocol},
+        {"GET / http/1.1", oddMissingProtocol},
+    }
+
+    for _, test := range tests {
+        got := classifyRequest(assembleMethodTestEntry(test.request))
+        if got != test.want {
+            t.Errorf("classifyRequest(%q) = %q, want %q", test.request,
+              got, test.want)
+        }
+    }
+}
+
+func TestNormalizeProtocol(t *testing.T) {
+
+    tests := []struct {
+        protocol string
+        want     string
+    }{
+        {"", "none"},
+        {"HTTP/1.0", "HTTP/1.0"},
+        {"HTTP/1.1", "HTTP/1.1"},
+        {"HTTP/2", "HTTP/2"},
+        {"HTTP/2.0", "HTTP/2"},
+        {"HTTP/3.0", "HTTP/3"},
+        {"HTTP/0.9", "other"},
+        {"http/1.1", "other"},
+    }
+
+    for _, test := range tests {
+        got := normalizeProtocol(test.protocol)
+        if got != test.want {
+            t.Errorf("normalizeProtocol(%q) = %q, want %q", test.protocol,
+              got, test.want)
+        }
+    }
+}
+
+func TestObtainMethodReport(t *testing.T) {
+
+    if report := obtainMethodReport(nil); report !=
+      "No requests listed at this time." {
+        t.Errorf("report of no requests = %q", report)
+    }
+
+    // malformed requests are counted apart, and the oddities name the
+    // addresses that sent them
+    entries := make([]logEntry, 0)
+    for _, request := range []string{"GET / HTTP/1.1", "GET / HTTP/2.0",
+      "MKCOL /x HTTP/1.1", "CONNECT example.com:443 HTTP/1.1",
+      "\\x16\\x03\\x01"} {
+        entries = append(entries, assembleMethodTestEntry(request))
+    }
+    report := obtainMethodReport(entries)
+
+    for _, line := range []string{
+        "GET        |       2 |  40.0% | ",
+        "CONNECT    |       1 |  20.0% | ",
+        "other      |       1 |  20.0% | ",
+        "malformed  |       1 |  20.0% | ",
+        "HTTP/1.1   |       3 |  60.0% | ",
+        "none       |       1 |  20.0% | ",
+        "CONNECT attempt (e.g. \"CONNECT example.com:443 HTTP/1.1\")\n" +
+          "      1 | 192.0.2.1\n",
+        "binary / TLS handshake (e.g. \"\\x16\\x03\\x01\")\n" +
+          "      1 | 192.0.2.1\n",
+    } {
+        if !strings.Contains(report, line) {
+            t.Errorf("report lacks %q:\n%s", line, report)
+        }
+    }
+}
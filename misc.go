@@ -12,57 +12,34 @@ package main
 //
 import (
     "fmt"
+    "net"
+    "sort"
     "strings"
     "strconv"
 )
 
 //! Validate an IPv6 address
 /*
- * @param     string    /path/to/file
+ * @param     string    IPv6 address
  *
  * @return    bool      whether or not this is true
- *
- * TODO: add more logic to this function
  */
 func isValidIPv6Address(ip string) (bool) {
 
-    // input validation
-    if len(ip) < 1 {
+    // input validation, the shortest IPv6 address is "::"
+    if len(ip) < 2 || len(ip) > 39 {
         return false
     }
 
-    // attempt to split the string into pieces via the ':' char
-    ip_pieces := strings.Split(ip, ":")
-
-    // safety check, ensure there is at least one piece
-    if len(ip_pieces) < 1 {
+    // IPv6 addresses always contain at least one ':' char, which also
+    // rules out IPv4 addresses, since ParseIP() accepts those too
+    if !strings.Contains(ip, ":") {
         return false
     }
 
-    // for every hexadecimal piece of the IPv6 address...
-    for _, hexa := range ip_pieces {
-
-        // ensure it has a length between 1 and 4
-        if len(hexa) < 1 || len(hexa) > 4 {
-            return false
-        }
-
-        // convert the ip_piece string to an integer
-        hexa_as_uint, err := strconv.ParseUint(hexa, 0, 16)
-
-        // if an error occurs, go ahead and return false
-        if err != nil {
-            return false
-        }
-
-        // if greater than 0xFFFF pass back a false
-        if hexa_as_uint > 65535 {
-            return false
-        }
-    }
-
-    // if all the tests passed, go ahead and return true
-    return true
+    // use the standard library to take care of the "::" shorthand and the
+    // mixed IPv4 suffix notation
+    return net.ParseIP(ip) != nil
 }
 
 //! Validate an IPv4 address
@@ -154,6 +131,49 @@ func spaceFormatIPv4(ip string) (string, error) {
     return space_formatted_ip_address, nil
 }
 
+//! Take a given IPv6 address and space buffer it so that it is always 40
+//! characters long.
+/*
+ * @param    string    IPv6 address
+ *
+ * @param    string    space-formatted IPv6 address
+ * @param    error     error message, if any
+ */
+func spaceFormatIPv6(ip string) (string, error) {
+
+    // ensure this is actually a IPv6 address
+    if !isValidIPv6Address(ip) {
+        return "", fmt.Errorf("spaceFormatIPv6() --> given IP is not " +
+          "an IPv6 address")
+    }
+
+    // attempt to format the IPv6 address
+    space_formatted_ip_address := ip
+    for len(space_formatted_ip_address) < 40 {
+        space_formatted_ip_address += " "
+    }
+
+    // return the formatted IPv6 string
+    return space_formatted_ip_address, nil
+}
+
+//! Space buffer a given IPv4 or IPv6 address
+/*
+ * @param    string    IP address
+ *
+ * @param    string    space-formatted IP address
+ * @param    error     error message, if any
+ */
+func spaceFormatIPAddress(ip string) (string, error) {
+
+    // IPv6 addresses need a wider buffer
+    if strings.Contains(ip, ":") {
+        return spaceFormatIPv6(ip)
+    }
+
+    return spaceFormatIPv4(ip)
+}
+
 //! Convert a given IPv4 address to a x.x.x.0/24 CIDR notation
 /*
  * @param    string    an IPv4 address
@@ -199,6 +219,92 @@ func obtainSlash24FromIpv4(ip string) (string, error) {
     return ipv4_slash24_cidr, nil
 }
 
+//! Convert a given IPv6 address to a x:x:x:x::/64 CIDR notation
+/*
+ * @param    string    an IPv6 address
+ *
+ * @return   string    result as a /64
+ * @return   error     error message, if any
+ */
+func obtainSlash64FromIpv6(ip string) (string, error) {
+
+    // ensure the given value is actually an IPv6 address
+    if !isValidIPv6Address(ip) {
+        return "", fmt.Errorf("obtainSlash64FromIpv6() --> improper " +
+          "IPv6 address given")
+    }
+
+    return obtainPrefixFromIp(ip, 64)
+}
+
+//! Convert a given IP address to the CIDR notation of a given prefix
+/*
+ * @param    string    an IPv4 or IPv6 address
+ * @param    int       prefix length; e.g. 16
+ *
+ * @return   string    result as a CIDR; e.g. 10.1.0.0/16
+ * @return   error     error message, if any
+ */
+func obtainPrefixFromIp(ip string, prefix_len int) (string, error) {
+
+    // attempt to parse the address
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return "", fmt.Errorf("obtainPrefixFromIp() --> improper IP " +
+          "address given")
+    }
+
+    // IPv4 addresses use a 32-bit mask, IPv6 a 128-bit one
+    bits := 128
+    if !strings.Contains(ip, ":") {
+        parsed = parsed.To4()
+        bits = 32
+    }
+
+    // ensure the prefix length fits the address
+    if prefix_len < 0 || prefix_len > bits {
+        return "", fmt.Errorf("obtainPrefixFromIp() --> improper prefix " +
+          "length given")
+    }
+
+    // mask away the host bits
+    mask := net.CIDRMask(prefix_len, bits)
+    network := net.IPNet{IP: parsed.Mask(mask), Mask: mask}
+
+    return network.String(), nil
+}
+
+//! Convert a comma separated list of integers into an array
+/*
+ *  @param    string    list of integers; e.g. "16,20"
+ *
+ *  @return   int[]     array of integers
+ *  @return   error     error message, if any
+ */
+func parseIntList(list string) ([]int, error) {
+
+    // variable declaration
+    var result = make([]int, 0)
+
+    // an empty list is valid, it simply has no entries
+    if len(strings.TrimSpace(list)) < 1 {
+        return result, nil
+    }
+
+    // for every comma separated piece...
+    for _, piece := range strings.Split(list, ",") {
+
+        value, err := strconv.Atoi(strings.TrimSpace(piece))
+        if err != nil {
+            return nil, fmt.Errorf("parseIntList() --> improper " +
+              "integer: %s", piece)
+        }
+        result = append(result, value)
+    }
+
+    return result, nil
+}
+
 //! Check if a given string value is present in a string array
 /*
  *  @param    string      string value in question
@@ -223,3 +329,65 @@ func isStringInArray(str string, stringArray []string) bool {
     // otherwise assume it is not present
     return false
 }
+
+//! Name and count pair, used when sorting the contents of a count map
+type countPair struct {
+    name  string
+    count int
+}
+
+//! Sort a map of counts into an array, highest count first
+/*
+ *  @param    map            string map containing names and counts
+ *
+ *  @return   countPair[]    sorted array of name / count pairs
+ */
+func sortMapByCount(counts map[string] int) []countPair {
+
+    // variable declaration
+    var pairs = make([]countPair, 0, len(counts))
+
+    // copy the map entries into the array
+    for name, count := range counts {
+        pairs = append(pairs, countPair{name, count})
+    }
+
+    // sort by count, and by name if the counts are equal so that the
+    // order is always the same between runs
+    sort.Slice(pairs, func(i, j int) bool {
+        if pairs[i].count != pairs[j].count {
+            return pairs[i].count > pairs[j].count
+        }
+        return pairs[i].name < pairs[j].name
+    })
+
+    // pass back the sorted pairs
+    return pairs
+}
+
+//! Assemble a horizontal ASCII bar proportional to the given value
+/*
+ *  @param    int       value to draw
+ *  @param    int       largest value in the set
+ *  @param    int       width of the largest bar, in characters
+ *
+ *  @return   string    bar made up of '#' characters
+ */
+func asciiBar(value int, max int, width int) string {
+
+    // input validation
+    if value < 1 || max < 1 || width < 1 {
+        return ""
+    }
+
+    // scale the value to the bar width
+    length := value * width / max
+
+    // ensure non-zero values always show up as something
+    if length < 1 {
+        length = 1
+    }
+
+    // pass back the assembled bar
+    return strings.Repeat("#", length)
+}
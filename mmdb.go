@@ -0,0 +1,522 @@
+//
+// MaxMind DB reader functions for ASCII-log
+//
+// GeoLite2 and DB-IP databases come as .mmdb files, which hold a binary
+// search tree over the bits of an address, followed by a data section the
+// leaves of the tree point into, followed by the metadata:
+//
+// [ search tree ][ 16 zero bytes ][ data section ][ marker ][ metadata ]
+//
+// Both the data section and the metadata use the same self describing
+// encoding, of maps, arrays, strings and numbers, so a single decoder
+// reads both. The whole file is kept in memory, and read again whenever it
+// is replaced, so a database can be updated without a restart.
+//
+// See https://maxmind.github.io/MaxMind-DB/ for the format.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io/ioutil"
+    "math"
+    "net"
+    "os"
+    "sync"
+    "time"
+)
+
+// Deepest nesting of values decoded, well beyond that of any real database.
+const mmdbMaxDepth = 32
+
+// Marker that the metadata of a database follows.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// Types of the values of the data section.
+const (
+    mmdbExtended  = 0
+    mmdbPointer   = 1
+    mmdbString    = 2
+    mmdbDouble    = 3
+    mmdbBytes     = 4
+    mmdbUint16    = 5
+    mmdbUint32    = 6
+    mmdbMap       = 7
+    mmdbInt32     = 8
+    mmdbUint64    = 9
+    mmdbUint128   = 10
+    mmdbArray     = 11
+    mmdbContainer = 12
+    mmdbEnd       = 13
+    mmdbBoolean   = 14
+    mmdbFloat     = 15
+)
+
+//! A loaded MaxMind DB file
+type mmdbDatabase struct {
+    contents      []byte
+    node_count    uint
+    record_size   uint
+    ip_version    uint
+    database_type string
+    data_start    uint
+    ipv4_start    uint
+}
+
+//! A MaxMind DB file, read again whenever it is replaced
+type mmdbReader struct {
+    path     string
+    mutex    sync.RWMutex
+    database *mmdbDatabase
+    mod_time time.Time
+    size     int64
+}
+
+//! Decode a value of the data section
+/*
+ * @param     byte[]         data section
+ * @param     uint           offset of the value
+ *
+ * @return    interface{}    value
+ * @return    uint           offset after the value
+ * @return    error          error message, if any
+ */
+func decodeMmdbValue(data []byte, offset uint) (interface{}, uint, error) {
+    return decodeMmdbNestedValue(data, offset, 0)
+}
+
+//! Decode a value of the data section, nested within others
+/*
+ * @param     byte[]         data section
+ * @param     uint           offset of the value
+ * @param     int            number of maps, arrays and pointers it is in
+ *
+ * @return    interface{}    value
+ * @return    uint           offset after the value
+ * @return    error          error message, if any
+ */
+func decodeMmdbNestedValue(data []byte, offset uint, depth int) (interface{},
+  uint, error) {
+
+    // a pointer back into an enclosing map would otherwise never end
+    if depth > mmdbMaxDepth {
+        return nil, 0, fmt.Errorf("decodeMmdbValue() --> values are " +
+          "nested too deep")
+    }
+
+    if offset >= uint(len(data)) {
+        return nil, 0, fmt.Errorf("decodeMmdbValue() --> offset %d is " +
+          "past the end of the data", offset)
+    }
+
+    control := data[offset]
+    offset++
+    kind := uint(control >> 5)
+
+    // pointers carry their own size, and point to another value of the
+    // data section
+    if kind == mmdbPointer {
+        size := uint(control >> 3) & 0x3
+        if offset + size + 1 > uint(len(data)) {
+            return nil, 0, fmt.Errorf("decodeMmdbValue() --> pointer is " +
+              "cut short")
+        }
+        var target uint
+        switch size {
+        case 0:
+            target = uint(control & 0x7) << 8 | uint(data[offset])
+        case 1:
+            target = (uint(control & 0x7) << 16 | uint(data[offset]) << 8 |
+              uint(data[offset+1])) + 2048
+        case 2:
+            target = (uint(control & 0x7) << 24 | uint(data[offset]) << 16 |
+              uint(data[offset+1]) << 8 | uint(data[offset+2])) + 526336
+        case 3:
+            target = uint(binary.BigEndian.Uint32(data[offset:offset+4]))
+        }
+
+        // pointers never point to pointers, which would allow loops
+        if target < uint(len(data)) && uint(data[target] >> 5) ==
+          mmdbPointer {
+            return nil, 0, fmt.Errorf("decodeMmdbValue() --> pointer " +
+              "points to a pointer")
+        }
+        value, _, err := decodeMmdbNestedValue(data, target, depth+1)
+        return value, offset + size + 1, err
+    }
+
+    if kind == mmdbExtended {
+        if offset >= uint(len(data)) {
+            return nil, 0, fmt.Errorf("decodeMmdbValue() --> extended " +
+              "type is cut short")
+        }
+        kind = 7 + uint(data[offset])
+        offset++
+    }
+
+    // the size follows the control byte, if it does not fit in it
+    size := uint(control & 0x1f)
+    if size >= 29 {
+        extra := size - 28
+        if offset + extra > uint(len(data)) {
+            return nil, 0, fmt.Errorf("decodeMmdbValue() --> size is cut " +
+              "short")
+        }
+        value := uint(0)
+        for i := uint(0); i < extra; i++ {
+            value = value << 8 | uint(data[offset+i])
+        }
+        offset += extra
+        switch extra {
+        case 1:
+            size = 29 + value
+        case 2:
+            size = 285 + value
+        case 3:
+            size = 65821 + value
+        }
+    }
+
+    // maps and arrays count entries, booleans hold their value in the
+    // size, while the others count bytes
+    switch kind {
+    case mmdbMap:
+        result := make(map[string] interface{}, size)
+        for i := uint(0); i < size; i++ {
+            key, next, err := decodeMmdbNestedValue(data, offset, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            key_string, ok := key.(string)
+            if !ok {
+                return nil, 0, fmt.Errorf("decodeMmdbValue() --> map " +
+                  "key is not a string")
+            }
+            value, after, err := decodeMmdbNestedValue(data, next, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            result[key_string] = value
+            offset = after
+        }
+        return result, offset, nil
+
+    case mmdbArray:
+        result := make([]interface{}, 0, size)
+        for i := uint(0); i < size; i++ {
+            value, after, err := decodeMmdbNestedValue(data, offset, depth+1)
+            if err != nil {
+                return nil, 0, err
+            }
+            result = append(result, value)
+            offset = after
+        }
+        return result, offset, nil
+
+    case mmdbBoolean:
+        return size != 0, offset, nil
+
+    case mmdbContainer, mmdbEnd:
+        return nil, offset, nil
+    }
+
+    if offset + size > uint(len(data)) {
+        return nil, 0, fmt.Errorf("decodeMmdbValue() --> value of type " +
+          "%d is cut short", kind)
+    }
+    raw := data[offset:offset+size]
+    offset += size
+
+    switch kind {
+    case mmdbString:
+        return string(raw), offset, nil
+    case mmdbBytes:
+        return append([]byte{}, raw...), offset, nil
+    case mmdbDouble:
+        if size != 8 {
+            return nil, 0, fmt.Errorf("decodeMmdbValue() --> double of " +
+              "%d bytes", size)
+        }
+        return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset,
+          nil
+    case mmdbFloat:
+        if size != 4 {
+            return nil, 0, fmt.Errorf("decodeMmdbValue() --> float of %d " +
+              "bytes", size)
+        }
+        return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))),
+          offset, nil
+    case mmdbInt32:
+        value := uint32(0)
+        for _, b := range raw {
+            value = value << 8 | uint32(b)
+        }
+        return int64(int32(value)), offset, nil
+    case mmdbUint16, mmdbUint32, mmdbUint64:
+        value := uint64(0)
+        for _, b := range raw {
+            value = value << 8 | uint64(b)
+        }
+        return value, offset, nil
+    case mmdbUint128:
+        return append([]byte{}, raw...), offset, nil
+    }
+
+    return nil, 0, fmt.Errorf("decodeMmdbValue() --> unknown type %d", kind)
+}
+
+//! Obtain an unsigned number of the metadata
+/*
+ * @param     map       metadata
+ * @param     string    key
+ *
+ * @return    uint      value, or 0 if absent
+ */
+func obtainMmdbUint(metadata map[string] interface{}, key string) uint {
+
+    if value, ok := metadata[key].(uint64); ok {
+        return uint(value)
+    }
+    return 0
+}
+
+//! Parse the contents of a MaxMind DB file
+/*
+ * @param     byte[]          contents of the file
+ *
+ * @return    mmdbDatabase    loaded database
+ * @return    error           error message, if any
+ */
+func parseMmdbDatabase(contents []byte) (*mmdbDatabase, error) {
+
+    marker := bytes.LastIndex(contents, mmdbMetadataMarker)
+    if marker < 0 {
+        return nil, fmt.Errorf("parseMmdbDatabase() --> no metadata " +
+          "marker found")
+    }
+
+    metadata_start := uint(marker + len(mmdbMetadataMarker))
+    raw, _, err := decodeMmdbValue(contents[metadata_start:], 0)
+    if err != nil {
+        return nil, err
+    }
+    metadata, ok := raw.(map[string] interface{})
+    if !ok {
+        return nil, fmt.Errorf("parseMmdbDatabase() --> metadata is not " +
+          "a map")
+    }
+
+    db := &mmdbDatabase{contents: contents,
+      node_count: obtainMmdbUint(metadata, "node_count"),
+      record_size: obtainMmdbUint(metadata, "record_size"),
+      ip_version: obtainMmdbUint(metadata, "ip_version")}
+    db.database_type, _ = metadata["database_type"].(string)
+
+    if db.record_size != 24 && db.record_size != 28 &&
+      db.record_size != 32 {
+        return nil, fmt.Errorf("parseMmdbDatabase() --> unsupported " +
+          "record size: %d", db.record_size)
+    }
+    if db.ip_version != 4 && db.ip_version != 6 {
+        return nil, fmt.Errorf("parseMmdbDatabase() --> unsupported IP " +
+          "version: %d", db.ip_version)
+    }
+
+    // the data section follows the search tree and 16 zero bytes
+    tree_size := db.node_count * db.record_size / 4
+    db.data_start = tree_size + 16
+    if db.data_start > uint(marker) {
+        return nil, fmt.Errorf("parseMmdbDatabase() --> search tree is " +
+          "larger than the file")
+    }
+
+    // IPv4 addresses live under the first 96 zero bits of an IPv6 tree
+    if db.ip_version == 6 {
+        node := uint(0)
+        for i := 0; i < 96 && node < db.node_count; i++ {
+            node = db.readRecord(node, 0)
+        }
+        db.ipv4_start = node
+    }
+
+    return db, nil
+}
+
+//! Read one of the two records of a node of the search tree
+/*
+ * @param     uint    node number
+ * @param     uint    0 for the left record, 1 for the right one
+ *
+ * @return    uint    record value
+ */
+func (db *mmdbDatabase) readRecord(node uint, side uint) uint {
+
+    b := db.contents[node * db.record_size / 4:]
+
+    switch db.record_size {
+    case 24:
+        b = b[side*3:]
+        return uint(b[0]) << 16 | uint(b[1]) << 8 | uint(b[2])
+    case 28:
+        if side == 0 {
+            return uint(b[3] & 0xf0) << 20 | uint(b[0]) << 16 |
+              uint(b[1]) << 8 | uint(b[2])
+        }
+        return uint(b[3] & 0x0f) << 24 | uint(b[4]) << 16 |
+          uint(b[5]) << 8 | uint(b[6])
+    }
+    return uint(binary.BigEndian.Uint32(b[side*4:]))
+}
+
+//! Look up the data of an IP address
+/*
+ * @param     IP             IP address
+ *
+ * @return    interface{}    data of the address, or nil if there is none
+ * @return    error          error message, if any
+ */
+func (db *mmdbDatabase) lookup(ip net.IP) (interface{}, error) {
+
+    // variable declaration
+    var node uint = 0
+    var address []byte
+
+    if ipv4 := ip.To4(); ipv4 != nil {
+        address = ipv4
+        if db.ip_version == 6 {
+            node = db.ipv4_start
+        }
+    } else if db.ip_version == 6 {
+        address = ip.To16()
+    } else {
+        return nil, nil
+    }
+
+    for i := 0; i < len(address) * 8 && node < db.node_count; i++ {
+        bit := uint(address[i / 8] >> (7 - uint(i % 8))) & 1
+        node = db.readRecord(node, bit)
+    }
+
+    // the node count itself stands for "not found", anything past it
+    // points into the data section
+    if node <= db.node_count {
+        return nil, nil
+    }
+
+    offset := node - db.node_count - 16
+    if db.data_start + offset >= uint(len(db.contents)) {
+        return nil, fmt.Errorf("mmdbDatabase.lookup() --> record points " +
+          "past the end of the file")
+    }
+
+    value, _, err := decodeMmdbValue(db.contents[db.data_start:], offset)
+    return value, err
+}
+
+//! Obtain a value nested within maps; e.g. country, iso_code
+/*
+ * @param     interface{}    decoded data
+ * @param     string[]       keys, outermost first
+ *
+ * @return    interface{}    value, or nil if absent
+ */
+func obtainMmdbPath(value interface{}, keys ...string) interface{} {
+
+    for _, key := range keys {
+        fields, ok := value.(map[string] interface{})
+        if !ok {
+            return nil
+        }
+        value = fields[key]
+    }
+    return value
+}
+
+//! Assemble a MaxMind DB reader and load its file
+/*
+ * @param     string        /path/to/file.mmdb
+ *
+ * @return    mmdbReader    reader
+ * @return    error         error message, if any
+ */
+func newMmdbReader(path string) (*mmdbReader, error) {
+
+    reader := &mmdbReader{path: path}
+    if _, err := reader.reload(); err != nil {
+        return nil, err
+    }
+    return reader, nil
+}
+
+//! Load the file again if it was replaced since it was last loaded
+/*
+ * The database already loaded is kept if the new file is improper, e.g.
+ * since it is still being written.
+ *
+ * @return    bool     whether or not the file was loaded again
+ * @return    error    error message, if any
+ */
+func (r *mmdbReader) reload() (bool, error) {
+
+    info, err := os.Stat(r.path)
+    if err != nil {
+        return false, fmt.Errorf("mmdbReader.reload() --> %s", err.Error())
+    }
+
+    r.mutex.RLock()
+    unchanged := r.database != nil && info.ModTime().Equal(r.mod_time) &&
+      info.Size() == r.size
+    r.mutex.RUnlock()
+    if unchanged {
+        return false, nil
+    }
+
+    contents, err := ioutil.ReadFile(r.path)
+    if err != nil {
+        return false, fmt.Errorf("mmdbReader.reload() --> %s", err.Error())
+    }
+    database, err := parseMmdbDatabase(contents)
+    if err != nil {
+        return false, fmt.Errorf("mmdbReader.reload() --> %s is improper: " +
+          "%s", r.path, err.Error())
+    }
+
+    r.mutex.Lock()
+    r.database = database
+    r.mod_time = info.ModTime()
+    r.size = info.Size()
+    r.mutex.Unlock()
+
+    return true, nil
+}
+
+//! Look up the data of an IP address
+/*
+ * @param     string         IP address
+ *
+ * @return    interface{}    data of the address, or nil if there is none
+ * @return    error          error message, if any
+ */
+func (r *mmdbReader) lookup(ip string) (interface{}, error) {
+
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return nil, fmt.Errorf("mmdbReader.lookup() --> '%s' is not an IP " +
+          "address", ip)
+    }
+
+    r.mutex.RLock()
+    database := r.database
+    r.mutex.RUnlock()
+
+    return database.lookup(parsed)
+}
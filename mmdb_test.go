@@ -0,0 +1,427 @@
+//
+// MaxMind DB reader tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "encoding/binary"
+    "io/ioutil"
+    "net"
+    "path/filepath"
+    "testing"
+)
+
+//! Node of a search tree, as assembled for a fixture
+type mmdbFixtureNode struct {
+    children [2]*mmdbFixtureNode
+    leaf     bool
+    offset   uint
+}
+
+//! Encode a string of the data section, of fewer than 29 bytes
+/*
+ * @param     string    value
+ *
+ * @return    byte[]    encoded value
+ */
+func encodeMmdbString(value string) []byte {
+    return append([]byte{byte(mmdbString << 5 | len(value))}, value...)
+}
+
+//! Encode a 32-bit unsigned number of the data section
+/*
+ * @param     uint32    value
+ *
+ * @return    byte[]    encoded value
+ */
+func encodeMmdbUint32(value uint32) []byte {
+
+    encoded := []byte{mmdbUint32 << 5 | 4, 0, 0, 0, 0}
+    binary.BigEndian.PutUint32(encoded[1:], value)
+    return encoded
+}
+
+//! Encode the start of a map of the data section, of fewer than 29 entries
+/*
+ * @param     int       number of entries, which follow as key, value
+ *
+ * @return    byte[]    encoded map header
+ */
+func encodeMmdbMap(entries int) []byte {
+    return []byte{byte(mmdbMap << 5 | entries)}
+}
+
+//! Encode a pointer of the data section, to an offset below 2048
+/*
+ * @param     uint      offset pointed to
+ *
+ * @return    byte[]    encoded pointer
+ */
+func encodeMmdbPointer(target uint) []byte {
+    return []byte{byte(mmdbPointer << 5 | (target >> 8) & 0x7),
+      byte(target & 0xff)}
+}
+
+//! Assemble the bytes of an encoded value
+/*
+ * @param     byte[][]    encoded pieces, in order
+ *
+ * @return    byte[]      pieces joined
+ */
+func joinMmdbPieces(pieces ...[]byte) []byte {
+
+    // variable declaration
+    var result = make([]byte, 0)
+
+    for _, piece := range pieces {
+        result = append(result, piece...)
+    }
+    return result
+}
+
+//! Insert a network into the search tree of a fixture
+/*
+ * @param     mmdbFixtureNode*    root of the tree
+ * @param     IPNet*              network
+ * @param     int                 leading zero bits to add; 96 for IPv4
+ *                                networks in an IPv6 tree
+ * @param     uint                offset of its data
+ */
+func insertMmdbNetwork(root *mmdbFixtureNode, network *net.IPNet,
+  padding int, offset uint) {
+
+    address := network.IP.To4()
+    if address == nil {
+        address = network.IP.To16()
+    }
+    ones, _ := network.Mask.Size()
+
+    node := root
+    for i := 0; i < padding + ones; i++ {
+        bit := 0
+        if i >= padding {
+            j := i - padding
+            bit = int(address[j / 8] >> (7 - uint(j % 8))) & 1
+        }
+        if node.children[bit] == nil {
+            node.children[bit] = &mmdbFixtureNode{}
+        }
+        node = node.children[bit]
+    }
+    node.leaf, node.offset = true, offset
+}
+
+//! Assemble the contents of a MaxMind DB file
+/*
+ * @param     T*          test state
+ * @param     int         record size; 24, 28 or 32
+ * @param     int         IP version of the tree; 4 or 6
+ * @param     map         data offsets, by network
+ * @param     byte[]      data section
+ *
+ * @return    byte[]      contents of the file
+ */
+func assembleMmdbFixture(t *testing.T, record_size int, ip_version int,
+  networks map[string] uint, data []byte) []byte {
+
+    t.Helper()
+
+    // variable declaration
+    var root = &mmdbFixtureNode{}
+    var nodes = make([]*mmdbFixtureNode, 0)
+    var numbers = make(map[*mmdbFixtureNode] uint)
+
+    for cidr, offset := range networks {
+        _, network, err := net.ParseCIDR(cidr)
+        if err != nil {
+            t.Fatalf("improper fixture network %s: %v", cidr, err)
+        }
+        padding := 0
+        if ip_version == 6 && network.IP.To4() != nil {
+            padding = 96
+        }
+        insertMmdbNetwork(root, network, padding, offset)
+    }
+
+    // number the nodes in the order they are written
+    var number func(node *mmdbFixtureNode)
+    number = func(node *mmdbFixtureNode) {
+        if node == nil || node.leaf {
+            return
+        }
+        numbers[node] = uint(len(nodes))
+        nodes = append(nodes, node)
+        number(node.children[0])
+        number(node.children[1])
+    }
+    number(root)
+
+    node_count := uint(len(nodes))
+    tree := make([]byte, 0)
+    for _, node := range nodes {
+
+        var records [2]uint
+        for side, child := range node.children {
+            switch {
+            case child == nil:
+                records[side] = node_count
+            case child.leaf:
+                records[side] = node_count + 16 + child.offset
+            default:
+                records[side] = numbers[child]
+            }
+        }
+
+        left, right := records[0], records[1]
+        switch record_size {
+        case 24:
+            tree = append(tree, byte(left >> 16), byte(left >> 8),
+              byte(left), byte(right >> 16), byte(right >> 8), byte(right))
+        case 28:
+            tree = append(tree, byte(left >> 16), byte(left >> 8),
+              byte(left), byte(left >> 24 << 4 | right >> 24 & 0x0f),
+              byte(right >> 16), byte(right >> 8), byte(right))
+        case 32:
+            tree = append(tree, 0, 0, 0, 0, 0, 0, 0, 0)
+            binary.BigEndian.PutUint32(tree[len(tree)-8:], uint32(left))
+            binary.BigEndian.PutUint32(tree[len(tree)-4:], uint32(right))
+        }
+    }
+
+    metadata := joinMmdbPieces(encodeMmdbMap(4),
+      encodeMmdbString("node_count"), encodeMmdbUint32(uint32(node_count)),
+      encodeMmdbString("record_size"),
+      encodeMmdbUint32(uint32(record_size)),
+      encodeMmdbString("ip_version"), encodeMmdbUint32(uint32(ip_version)),
+      encodeMmdbString("database_type"), encodeMmdbString("Test-Country"))
+
+    return joinMmdbPieces(tree, make([]byte, 16), data, mmdbMetadataMarker,
+      metadata)
+}
+
+//! Assemble the data section of a fixture: a country map shared via
+//! pointers, and the records of two networks
+/*
+ * @return    byte[]    data section
+ * @return    uint      offset of the record pointing to the shared map
+ * @return    uint      offset of the record holding its own map
+ */
+func assembleMmdbFixtureData() ([]byte, uint, uint) {
+
+    shared := joinMmdbPieces(encodeMmdbMap(1), encodeMmdbString("iso_code"),
+      encodeMmdbString("DE"))
+
+    own := joinMmdbPieces(encodeMmdbMap(2), encodeMmdbString("country"),
+      encodeMmdbMap(1), encodeMmdbString("iso_code"),
+      encodeMmdbString("US"),
+      encodeMmdbString("autonomous_system_number"),
+      encodeMmdbUint32(64500))
+
+    pointing := joinMmdbPieces(encodeMmdbMap(2), encodeMmdbString("country"),
+      encodeMmdbPointer(0), encodeMmdbString("registered_country"),
+      encodeMmdbPointer(0))
+
+    data := joinMmdbPieces(shared, own, pointing)
+    return data, uint(len(shared) + len(own)), uint(len(shared))
+}
+
+func TestMmdbReadRecord(t *testing.T) {
+
+    // the 28-bit layout keeps the top bits of both records in the middle
+    tests := []struct {
+        record_size uint
+        contents    []byte
+        left        uint
+        right       uint
+    }{
+        {24, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}, 0x010203,
+          0x040506},
+        {28, []byte{0x01, 0x02, 0x03, 0xab, 0x04, 0x05, 0x06}, 0xa010203,
+          0xb040506},
+        {32, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+          0x01020304, 0x05060708},
+    }
+
+    for _, test := range tests {
+        db := &mmdbDatabase{contents: test.contents,
+          record_size: test.record_size}
+        left, right := db.readRecord(0, 0), db.readRecord(0, 1)
+        if left != test.left || right != test.right {
+            t.Errorf("%d-bit records = %#x %#x, want %#x %#x",
+              test.record_size, left, right, test.left, test.right)
+        }
+    }
+}
+
+func TestMmdbReaderLookup(t *testing.T) {
+
+    data, pointing, own := assembleMmdbFixtureData()
+
+    tests := []struct {
+        ip      string
+        country string
+        found   bool
+    }{
+        {"192.0.2.1", "US", true},
+        {"192.0.2.255", "US", true},
+        {"198.51.100.7", "DE", true},
+        {"203.0.113.1", "", false},
+        {"2001:db8::1", "DE", true},
+        {"2001:db9::1", "", false},
+    }
+
+    for _, record_size := range []int{24, 28, 32} {
+        for _, ip_version := range []int{4, 6} {
+
+            networks := map[string] uint{"192.0.2.0/24": own,
+              "198.51.100.0/25": pointing}
+            if ip_version == 6 {
+                networks["2001:db8::/32"] = pointing
+            }
+
+            path := filepath.Join(t.TempDir(), "test.mmdb")
+            err := ioutil.WriteFile(path, assembleMmdbFixture(t,
+              record_size, ip_version, networks, data), 0644)
+            if err != nil {
+                t.Fatalf("unable to write the fixture: %v", err)
+            }
+
+            reader, err := newMmdbReader(path)
+            if err != nil {
+                t.Fatalf("%d-bit IPv%d: unexpected error: %v", record_size,
+                  ip_version, err)
+            }
+
+            for _, test := range tests {
+
+                // an IPv4 tree knows nothing of IPv6
+                found := test.found
+                if ip_version == 4 && net.ParseIP(test.ip).To4() == nil {
+                    found = false
+                }
+
+                value, err := reader.lookup(test.ip)
+                if err != nil {
+                    t.Errorf("%d-bit IPv%d %s: unexpected error: %v",
+                      record_size, ip_version, test.ip, err)
+                    continue
+                }
+                if (value != nil) != found {
+                    t.Errorf("%d-bit IPv%d %s: found = %v, want %v",
+                      record_size, ip_version, test.ip, value != nil, found)
+                    continue
+                }
+                if !found {
+                    continue
+                }
+
+                country, _ := obtainMmdbPath(value, "country",
+                  "iso_code").(string)
+                if country != test.country {
+                    t.Errorf("%d-bit IPv%d %s: country = %q, want %q",
+                      record_size, ip_version, test.ip, country,
+                      test.country)
+                }
+            }
+        }
+    }
+}
+
+func TestParseMmdbDatabaseImproper(t *testing.T) {
+
+    data, _, own := assembleMmdbFixtureData()
+    contents := assembleMmdbFixture(t, 24, 6,
+      map[string] uint{"192.0.2.0/24": own}, data)
+
+    // every truncation of the file is an error, rather than a panic
+    for size := 0; size < len(contents); size++ {
+        _, err := parseMmdbDatabase(contents[:size])
+        if err == nil {
+            t.Errorf("file truncated to %d bytes accepted", size)
+        }
+    }
+
+    tests := []struct {
+        name     string
+        contents []byte
+    }{
+        {"no metadata marker", []byte("not a database")},
+        {"metadata not a map", joinMmdbPieces(mmdbMetadataMarker,
+          encodeMmdbString("oops"))},
+        {"unsupported record size", joinMmdbPieces(mmdbMetadataMarker,
+          encodeMmdbMap(3), encodeMmdbString("node_count"),
+          encodeMmdbUint32(0), encodeMmdbString("record_size"),
+          encodeMmdbUint32(20), encodeMmdbString("ip_version"),
+          encodeMmdbUint32(4))},
+        {"tree larger than the file", joinMmdbPieces(mmdbMetadataMarker,
+          encodeMmdbMap(3), encodeMmdbString("node_count"),
+          encodeMmdbUint32(1000), encodeMmdbString("record_size"),
+          encodeMmdbUint32(24), encodeMmdbString("ip_version"),
+          encodeMmdbUint32(4))},
+    }
+
+    for _, test := range tests {
+        _, err := parseMmdbDatabase(test.contents)
+        if err == nil {
+            t.Errorf("%s: expected an error", test.name)
+        }
+    }
+}
+
+func TestMmdbLookupCorrupt(t *testing.T) {
+
+    data, pointing, own := assembleMmdbFixtureData()
+    networks := map[string] uint{"192.0.2.0/24": own,
+      "198.51.100.0/25": pointing, "2001:db8::/32": pointing}
+    contents := assembleMmdbFixture(t, 28, 6, networks, data)
+
+    // any byte of the file may be corrupt; lookups either fail or give an
+    // answer, yet never panic
+    for i := range contents {
+        corrupt := append([]byte{}, contents...)
+        corrupt[i] ^= 0xff
+
+        db, err := parseMmdbDatabase(corrupt)
+        if err != nil {
+            continue
+        }
+        for _, ip := range []string{"192.0.2.1", "198.51.100.7",
+          "2001:db8::1", "203.0.113.1"} {
+            db.lookup(net.ParseIP(ip))
+        }
+    }
+}
+
+func TestDecodeMmdbValueImproper(t *testing.T) {
+
+    tests := []struct {
+        name string
+        data []byte
+    }{
+        {"empty", []byte{}},
+        {"string cut short", encodeMmdbString("iso_code")[:4]},
+        {"pointer cut short", encodeMmdbPointer(0)[:1]},
+        {"pointer to a pointer", joinMmdbPieces(encodeMmdbPointer(2),
+          encodeMmdbPointer(0))},
+        {"pointer past the end", encodeMmdbPointer(100)},
+        {"map key not a string", joinMmdbPieces(encodeMmdbMap(1),
+          encodeMmdbUint32(1), encodeMmdbUint32(2))},
+        {"map pointing back into itself", joinMmdbPieces(encodeMmdbMap(1),
+          encodeMmdbString("loop"), encodeMmdbPointer(0))},
+        {"double of the wrong size", []byte{mmdbDouble << 5 | 2, 0, 0}},
+    }
+
+    for _, test := range tests {
+        _, _, err := decodeMmdbValue(test.data, 0)
+        if err == nil {
+            t.Errorf("%s: expected an error", test.name)
+        }
+    }
+}
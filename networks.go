@@ -0,0 +1,164 @@
+//
+// Network owner aggregation functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+//! Traffic totals of every address belonging to a given network owner
+type networkStats struct {
+    name     string
+    detail   string
+    ips      int
+    requests int
+    blocked  int
+    cidrs    []string
+}
+
+//! Group IP address counts by a key taken from their whois records
+/*
+ * @param     map               string map containing ip addresses and counts
+ * @param     map               string map containing parsed whois records
+ * @param     string[]          addresses that are to be blocked
+ * @param     func              obtains the group name and detail of a record
+ *
+ * @return    networkStats[]    groups, most requests first
+ */
+func aggregateNetworks(ip_map map[string] int,
+  records map[string] whoisRecord, blocked []string,
+  key func(whoisRecord) (string, string)) []networkStats {
+
+    // variable declaration
+    var group_map = make(map[string] *networkStats)
+    var result = make([]networkStats, 0)
+
+    // for every IP address...
+    for ip, count := range ip_map {
+
+        // addresses without a whois record are grouped as unknown
+        name, detail := key(records[ip])
+        if len(name) < 1 {
+            name = "unknown"
+        }
+
+        group, exists := group_map[name]
+        if !exists {
+            group = &networkStats{name: name, detail: detail}
+            group_map[name] = group
+        }
+        if len(group.detail) < 1 {
+            group.detail = detail
+        }
+
+        group.ips++
+        group.requests += count
+        if isStringInArray(ip, blocked) {
+            group.blocked++
+        }
+
+        cidr := records[ip].cidr
+        if len(cidr) > 0 && !isStringInArray(cidr, group.cidrs) {
+            group.cidrs = append(group.cidrs, cidr)
+        }
+    }
+
+    for _, group := range group_map {
+        sort.Strings(group.cidrs)
+        result = append(result, *group)
+    }
+
+    // sort by requests, then name, so that the order is always the same
+    // between runs
+    sort.Slice(result, func(i, j int) bool {
+        if result[i].requests != result[j].requests {
+            return result[i].requests > result[j].requests
+        }
+        return result[i].name < result[j].name
+    })
+
+    return result
+}
+
+//! Convert a list of network groups into lines of the networks log
+/*
+ * @param     networkStats[]    groups to list
+ *
+ * @return    string            lines of "requests | ips | blocked | name"
+ */
+func convertNetworksToString(groups []networkStats) string {
+
+    // variable declaration
+    var network_strings string = ""
+
+    // if no groups present, append a line about there being no data
+    if len(groups) < 1 {
+        return "No networks listed at this time.\n"
+    }
+
+    network_strings += fmt.Sprintf("%8s | %5s | %7s | %s\n", "requests",
+      "ips", "blocked", "network")
+
+    for _, group := range groups {
+
+        name := group.name
+        if len(group.detail) > 0 {
+            name += " (" + group.detail + ")"
+        }
+
+        network_strings += fmt.Sprintf("%8d | %5d | %7d | %s\n",
+          group.requests, group.ips, group.blocked, name)
+
+        // list the allocated ranges, since several IPs of a single range
+        // hint at a single hosting provider
+        if len(group.cidrs) > 0 {
+            network_strings += fmt.Sprintf("%8s | %5s | %7s |   %s\n", "",
+              "", "", strings.Join(group.cidrs, " "))
+        }
+    }
+
+    return network_strings
+}
+
+//! Assemble the contents of the networks log
+/*
+ * @param     map       string map containing ip addresses and counts
+ * @param     map       string map containing parsed whois records
+ * @param     string[]  addresses that are to be blocked
+ *
+ * @return    string    network report
+ */
+func obtainNetworkReport(ip_map map[string] int,
+  records map[string] whoisRecord, blocked []string) string {
+
+    // variable declaration
+    var network_strings string = ""
+
+    // group by origin AS number
+    by_asn := aggregateNetworks(ip_map, records, blocked,
+      func(r whoisRecord) (string, string) {
+        return r.asn, r.as_name
+    })
+    network_strings += "By Autonomous System\n\n"
+    network_strings += convertNetworksToString(by_asn)
+
+    // group by organisation
+    by_org := aggregateNetworks(ip_map, records, blocked,
+      func(r whoisRecord) (string, string) {
+        return r.org, r.netname
+    })
+    network_strings += "\nBy Organisation\n\n"
+    network_strings += convertNetworksToString(by_org)
+
+    return network_strings
+}
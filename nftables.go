@@ -0,0 +1,186 @@
+//
+// nftables firewall backend for ASCII-log
+//
+// The backend manages a dedicated table, with one set for IPv4 and one for
+// IPv6, plus an input chain that drops anything from either set:
+//
+// table inet ascii_log {
+//     set blocked4 { type ipv4_addr; flags interval, timeout; }
+//     set blocked6 { type ipv6_addr; flags interval, timeout; }
+//     chain input {
+//         type filter hook input priority -10; policy accept;
+//         ip saddr @blocked4 drop
+//         ip6 saddr @blocked6 drop
+//     }
+// }
+//
+// Every change is passed to `nft -f -` as a single script, so that it is
+// applied atomically.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "strings"
+)
+
+//! nftables firewall backend
+type nftablesBackend struct {
+    runner commandRunner
+    binary string
+    table  string
+}
+
+//! Obtain the name of the backend
+/*
+ * @return    string    backend name
+ */
+func (n *nftablesBackend) name() string {
+    return "nftables"
+}
+
+//! Obtain the elements currently present in one of the sets
+/*
+ * @param     string      set name
+ *
+ * @return    string[]    elements of the set; empty if the set is missing
+ */
+func (n *nftablesBackend) listElements(set string) []string {
+
+    // variable declaration
+    var result = make([]string, 0)
+
+    // if the table or set does not exist yet, then it is simply empty
+    output, err := n.runner.run(n.binary, []string{"list", "set", "inet",
+      n.table, set}, "")
+    if err != nil {
+        return result
+    }
+
+    // the elements are listed as "elements = { a timeout 2d expires 1d,
+    // b timeout ... }", possibly across several lines
+    start := strings.Index(output, "elements = {")
+    if start < 0 {
+        return result
+    }
+    output = output[start+len("elements = {"):]
+    if end := strings.Index(output, "}"); end >= 0 {
+        output = output[:end]
+    }
+
+    for _, element := range strings.Split(output, ",") {
+        fields := strings.Fields(element)
+        if len(fields) > 0 {
+            result = append(result, normalizeFirewallTarget(fields[0]))
+        }
+    }
+
+    return result
+}
+
+//! Assemble the nft script that brings a set in line with the entries
+/*
+ * @param     string      set name
+ * @param     string[]    elements currently in the set
+ * @param     map         map of targets and entries that ought to be in it
+ *
+ * @return    string      nft script lines
+ */
+func (n *nftablesBackend) assembleSetChanges(set string, current []string,
+  wanted map[string] firewallEntry) string {
+
+    // variable declaration
+    var script string = ""
+    var removed = make([]string, 0)
+    var refreshed = make([]string, 0)
+    var added = make([]string, 0)
+
+    // elements that are no longer blocked are removed, and those that are
+    // still blocked are removed and re-added to refresh their timeout
+    for _, element := range current {
+        if _, exists := wanted[element]; exists {
+            refreshed = append(refreshed, element)
+        } else {
+            removed = append(removed, element)
+        }
+    }
+
+    if len(removed) + len(refreshed) > 0 {
+        script += "delete element inet " + n.table + " " + set + " { " +
+          strings.Join(append(removed, refreshed...), ", ") + " }\n"
+    }
+
+    for _, target := range sortedFirewallTargets(wanted) {
+        added = append(added, target + " timeout " +
+          formatFirewallTimeout(wanted[target].timeout))
+    }
+
+    if len(added) > 0 {
+        script += "add element inet " + n.table + " " + set + " { " +
+          strings.Join(added, ", ") + " }\n"
+    }
+
+    return script
+}
+
+//! Assemble the complete nft script for the given entries
+/*
+ * @param     firewallEntry[]    entries to block
+ * @param     string[]           elements currently in the IPv4 set
+ * @param     string[]           elements currently in the IPv6 set
+ *
+ * @return    string             nft script
+ */
+func (n *nftablesBackend) assembleRuleset(entries []firewallEntry,
+  current4 []string, current6 []string) string {
+
+    // variable declaration
+    var script string = ""
+
+    entries4, entries6 := splitFirewallEntries(removeCoveredEntries(entries))
+
+    // "add" is a no-op for objects that already exist, so the table, sets
+    // and chain can safely be declared every time
+    script += "add table inet " + n.table + "\n"
+    script += "add set inet " + n.table + " blocked4 { type ipv4_addr; " +
+      "flags interval, timeout; }\n"
+    script += "add set inet " + n.table + " blocked6 { type ipv6_addr; " +
+      "flags interval, timeout; }\n"
+    script += "add chain inet " + n.table + " input { type filter hook " +
+      "input priority -10; policy accept; }\n"
+
+    // the chain is flushed and refilled so the rules are never doubled up
+    script += "flush chain inet " + n.table + " input\n"
+    script += "add rule inet " + n.table + " input ip saddr @blocked4 drop\n"
+    script += "add rule inet " + n.table + " input ip6 saddr @blocked6 " +
+      "drop\n"
+
+    script += n.assembleSetChanges("blocked4", current4, entries4)
+    script += n.assembleSetChanges("blocked6", current6, entries6)
+
+    return script
+}
+
+//! Bring the firewall in line with the given entries
+/*
+ * @param     firewallEntry[]    entries to block
+ *
+ * @return    error              error message, if any
+ */
+func (n *nftablesBackend) sync(entries []firewallEntry) error {
+
+    current4 := n.listElements("blocked4")
+    current6 := n.listElements("blocked6")
+
+    script := n.assembleRuleset(entries, current4, current6)
+
+    // apply every change at once
+    _, err := n.runner.run(n.binary, []string{"-f", "-"}, script)
+    return err
+}
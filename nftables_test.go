@@ -0,0 +1,132 @@
+//
+// nftables firewall backend tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "testing"
+    "time"
+)
+
+// The table, sets and chain that every nft script starts with.
+const nftablesTestHeader = "add table inet ascii_log\n" +
+  "add set inet ascii_log blocked4 { type ipv4_addr; " +
+  "flags interval, timeout; }\n" +
+  "add set inet ascii_log blocked6 { type ipv6_addr; " +
+  "flags interval, timeout; }\n" +
+  "add chain inet ascii_log input { type filter hook input " +
+  "priority -10; policy accept; }\n" +
+  "flush chain inet ascii_log input\n" +
+  "add rule inet ascii_log input ip saddr @blocked4 drop\n" +
+  "add rule inet ascii_log input ip6 saddr @blocked6 drop\n"
+
+func TestNftablesSync(t *testing.T) {
+
+    list4 := "nft list set inet ascii_log blocked4"
+    list6 := "nft list set inet ascii_log blocked6"
+
+    tests := []struct {
+        name     string
+        entries  []firewallEntry
+        outputs  map[string] string
+        failures map[string] bool
+        want     string
+    }{
+        {
+            name:     "empty firewall, sets not created yet",
+            entries:  []firewallEntry{
+                {"10.0.0.1", 2 * time.Hour},
+                {"2001:db8::1", time.Hour},
+            },
+            failures: map[string] bool{list4: true, list6: true},
+            want:     nftablesTestHeader +
+              "add element inet ascii_log blocked4 { " +
+              "10.0.0.1 timeout 7200s }\n" +
+              "add element inet ascii_log blocked6 { " +
+              "2001:db8::1 timeout 3600s }\n",
+        },
+        {
+            name:    "addresses within a blocked CIDR are left out",
+            entries: []firewallEntry{
+                {"10.0.0.5", time.Hour},
+                {"10.0.0.0/24", time.Hour},
+                {"10.0.1.0/24", time.Hour},
+            },
+            want:    nftablesTestHeader +
+              "add element inet ascii_log blocked4 { " +
+              "10.0.0.0/24 timeout 3600s, 10.0.1.0/24 timeout 3600s }\n",
+        },
+        {
+            name:    "stale elements removed, kept ones refreshed",
+            entries: []firewallEntry{
+                {"10.0.0.1", 2 * time.Hour},
+                {"10.0.0.2", time.Minute},
+            },
+            outputs: map[string] string{
+                list4: "table inet ascii_log {\n" +
+                  "\tset blocked4 {\n" +
+                  "\t\ttype ipv4_addr\n" +
+                  "\t\tflags interval,timeout\n" +
+                  "\t\telements = { 10.0.0.1 timeout 2h expires 1h,\n" +
+                  "\t\t\t     192.0.2.7/32 timeout 1d expires 3h }\n" +
+                  "\t}\n}\n",
+            },
+            want:    nftablesTestHeader +
+              "delete element inet ascii_log blocked4 { " +
+              "192.0.2.7, 10.0.0.1 }\n" +
+              "add element inet ascii_log blocked4 { " +
+              "10.0.0.1 timeout 7200s, 10.0.0.2 timeout 60s }\n",
+        },
+        {
+            name:    "everything unblocked",
+            outputs: map[string] string{
+                list6: "\t\telements = { 2001:db8::/64 timeout 1d }\n",
+            },
+            want:    nftablesTestHeader +
+              "delete element inet ascii_log blocked6 { 2001:db8::/64 }\n",
+        },
+    }
+
+    for _, test := range tests {
+
+        runner := &recordingRunner{outputs: test.outputs,
+          failures: test.failures}
+        backend := &nftablesBackend{runner: runner, binary: "nft",
+          table: "ascii_log"}
+
+        err := backend.sync(test.entries)
+        if err != nil {
+            t.Errorf("%s: unexpected error: %v", test.name, err)
+            continue
+        }
+
+        checkCommandLines(t, runner.lines(), []string{list4, list6,
+          "nft -f -"})
+        if len(runner.commands) != 3 {
+            continue
+        }
+        if runner.commands[2].stdin != test.want {
+            t.Errorf("%s: script:\n%s\nwant:\n%s", test.name,
+              runner.commands[2].stdin, test.want)
+        }
+    }
+}
+
+func TestNftablesSyncError(t *testing.T) {
+
+    runner := &recordingRunner{failures: map[string] bool{"nft -f -": true}}
+    backend := &nftablesBackend{runner: runner, binary: "nft",
+      table: "ascii_log"}
+
+    err := backend.sync([]firewallEntry{{"10.0.0.1", time.Hour}})
+    if err == nil {
+        t.Errorf("expected the failed nft -f - to be passed along")
+    }
+}
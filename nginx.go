@@ -0,0 +1,88 @@
+//
+// nginx output functions for ASCII-log
+//
+// nginx can block addresses with deny lines, included in a server or
+// location block:
+//
+// deny 192.0.2.1;
+// deny 198.51.100.0/24;
+//
+// or, for large lists, with a geo block included in the http block, which
+// sets a variable that the server blocks can then act on:
+//
+// geo $ascii_log_blocked {
+//     default 0;
+//     192.0.2.1 1;
+// }
+//
+// if ($ascii_log_blocked) { return 403; }
+//
+
+//
+// Package
+//
+package main
+
+// Formats of the nginx block file.
+const (
+    nginxFormatDeny = "deny"
+    nginxFormatGeo  = "geo"
+)
+
+//! Assemble the nginx deny lines that block the given addresses
+/*
+ * @param     string[]    blocked IP addresses and CIDRs
+ *
+ * @return    string      deny lines
+ */
+func assembleNginxDenyConfig(targets []string) string {
+
+    // variable declaration
+    var config string = "# blocked by ascii-log\n"
+
+    for _, target := range targets {
+        config += "deny " + target + ";\n"
+    }
+
+    return config
+}
+
+//! Assemble the nginx geo block that flags the given addresses
+/*
+ * @param     string[]    blocked IP addresses and CIDRs
+ *
+ * @return    string      geo block setting $ascii_log_blocked
+ */
+func assembleNginxGeoConfig(targets []string) string {
+
+    // variable declaration
+    var config string = ""
+
+    config += "geo $ascii_log_blocked {\n"
+    config += "    default 0;\n"
+    for _, target := range targets {
+        config += "    " + target + " 1;\n"
+    }
+    config += "}\n"
+
+    return config
+}
+
+//! Assemble the managed nginx block file
+/*
+ * @param     commandRunner        runs nginx
+ * @param     string               /path/to/include.conf
+ * @param     string               nginx binary
+ * @param     bool                 whether to print the file, not write it
+ * @param     string               /path/to/history, to record changes in
+ *
+ * @return    managedConfigFile    managed file
+ */
+func newNginxBlockFile(runner commandRunner, path string, nginx string,
+  dry_run bool, history_path string) *managedConfigFile {
+
+    return &managedConfigFile{runner: runner, path: path, dry_run: dry_run,
+      test_command: []string{nginx, "-t"},
+      reload_command: []string{nginx, "-s", "reload"},
+      history_path: history_path}
+}
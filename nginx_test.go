@@ -0,0 +1,205 @@
+//
+// nginx output tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestAssembleNginxConfig(t *testing.T) {
+
+    tests := []struct {
+        name    string
+        format  string
+        targets []string
+        want    string
+    }{
+        {
+            name:    "deny lines",
+            format:  nginxFormatDeny,
+            targets: []string{"192.0.2.1", "198.51.100.0/24",
+              "2001:db8::/64"},
+            want:    "# blocked by ascii-log\n" +
+              "deny 192.0.2.1;\n" +
+              "deny 198.51.100.0/24;\n" +
+              "deny 2001:db8::/64;\n",
+        },
+        {
+            name:   "no deny lines",
+            format: nginxFormatDeny,
+            want:   "# blocked by ascii-log\n",
+        },
+        {
+            name:    "geo block",
+            format:  nginxFormatGeo,
+            targets: []string{"192.0.2.1", "198.51.100.0/24",
+              "2001:db8::/64"},
+            want:    "geo $ascii_log_blocked {\n" +
+              "    default 0;\n" +
+              "    192.0.2.1 1;\n" +
+              "    198.51.100.0/24 1;\n" +
+              "    2001:db8::/64 1;\n" +
+              "}\n",
+        },
+        {
+            name:   "empty geo block still sets the variable",
+            format: nginxFormatGeo,
+            want:   "geo $ascii_log_blocked {\n" +
+              "    default 0;\n" +
+              "}\n",
+        },
+    }
+
+    for _, test := range tests {
+
+        got := assembleNginxDenyConfig(test.targets)
+        if test.format == nginxFormatGeo {
+            got = assembleNginxGeoConfig(test.targets)
+        }
+        if got != test.want {
+            t.Errorf("%s: config:\n%s\nwant:\n%s", test.name, got,
+              test.want)
+        }
+    }
+}
+
+func TestNginxBlockFileApply(t *testing.T) {
+
+    dir := t.TempDir()
+    history := filepath.Join(dir, "config.history")
+    contents := assembleNginxDenyConfig([]string{"192.0.2.1"})
+
+    tests := []struct {
+        name     string
+        file     string
+        existed  bool
+        failures map[string] bool
+        changed  bool
+        fails    bool
+        commands []string
+        contents string
+    }{
+        {
+            name:     "tested and reloaded",
+            file:     "block.conf",
+            existed:  true,
+            changed:  true,
+            commands: []string{"nginx -t", "nginx -s reload"},
+            contents: contents,
+        },
+        {
+            name:     "new file tested and reloaded",
+            file:     "new.conf",
+            changed:  true,
+            commands: []string{"nginx -t", "nginx -s reload"},
+            contents: contents,
+        },
+        {
+            name:     "failed test rolls back",
+            file:     "rollback.conf",
+            existed:  true,
+            failures: map[string] bool{"nginx -t": true},
+            fails:    true,
+            commands: []string{"nginx -t"},
+            contents: "# before\n",
+        },
+        {
+            name:     "failed test removes a new file",
+            file:     "removed.conf",
+            failures: map[string] bool{"nginx -t": true},
+            fails:    true,
+            commands: []string{"nginx -t"},
+        },
+        {
+            name:     "failed reload keeps the tested file",
+            file:     "reload.conf",
+            existed:  true,
+            failures: map[string] bool{"nginx -s reload": true},
+            changed:  true,
+            fails:    true,
+            commands: []string{"nginx -t", "nginx -s reload"},
+            contents: contents,
+        },
+    }
+
+    for _, test := range tests {
+
+        path := filepath.Join(dir, test.file)
+        if test.existed {
+            err := ioutil.WriteFile(path, []byte("# before\n"), 0644)
+            if err != nil {
+                t.Fatalf("unable to write %s: %v", path, err)
+            }
+        }
+
+        runner := &recordingRunner{failures: test.failures}
+        file := newNginxBlockFile(runner, path, "nginx", false, history)
+        changed, err := file.apply(contents)
+        if changed != test.changed || (err != nil) != test.fails {
+            t.Errorf("%s: apply() = %v, %v", test.name, changed, err)
+        }
+
+        checkCommandLines(t, runner.lines(), test.commands)
+
+        got, err := ioutil.ReadFile(path)
+        if len(test.contents) < 1 {
+            if !os.IsNotExist(err) {
+                t.Errorf("%s: file left behind: %q", test.name, got)
+            }
+            continue
+        }
+        if string(got) != test.contents {
+            t.Errorf("%s: contents = %q, want %q", test.name, got,
+              test.contents)
+        }
+    }
+
+    // the rolled back changes are recorded as such
+    got, _ := ioutil.ReadFile(history)
+    if strings.Count(string(got), "(applied)") != 3 ||
+      strings.Count(string(got), "(rolled back)") != 2 {
+        t.Errorf("history:\n%s", got)
+    }
+}
+
+func TestNginxBlockFileDryRun(t *testing.T) {
+
+    path := filepath.Join(t.TempDir(), "block.conf")
+    err := ioutil.WriteFile(path, []byte("geo $ascii_log_blocked {\n" +
+      "    default 0;\n}\n"), 0644)
+    if err != nil {
+        t.Fatalf("unable to write %s: %v", path, err)
+    }
+
+    // a dry run prints the change, and neither writes nor runs nginx
+    runner := &recordingRunner{}
+    file := newNginxBlockFile(runner, path, "nginx", true, "")
+    output := captureStdout(t, func() {
+        changed, err := file.apply(assembleNginxGeoConfig(
+          []string{"192.0.2.1"}))
+        if !changed || err != nil {
+            t.Errorf("apply() = %v, %v", changed, err)
+        }
+    })
+
+    if output != "# write " + path + "\n+    192.0.2.1 1;\n" {
+        t.Errorf("output = %q", output)
+    }
+    checkCommandLines(t, runner.lines(), nil)
+    got, _ := ioutil.ReadFile(path)
+    if strings.Contains(string(got), "192.0.2.1") {
+        t.Errorf("dry run wrote the file: %q", got)
+    }
+}
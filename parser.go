@@ -0,0 +1,266 @@
+//
+// Log line parsing functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Layout of the date-time stamp used by the apache / nginx access logs.
+const accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+//! Parsed form of a single access log line
+/*
+ * The access logs of both apache and nginx default to the "combined"
+ * format, which looks like so:
+ *
+ * ip - user [date] "METHOD /path PROTOCOL" status bytes "referer" "agent"
+ *
+ * Many setups also append the request time and upstream response time,
+ * e.g. nginx $request_time or apache %D, so those are read if present.
+ */
+type logEntry struct {
+    ip         string
+    timestamp  time.Time
+    request    string
+    method     string
+    path       string
+    protocol   string
+    status     int
+    bytes      int
+    referer    string
+    user_agent string
+
+    // response times in seconds, or -1 if not present in the log
+    request_time  float64
+    upstream_time float64
+}
+
+//! Split a log line into fields, keeping "quoted" and [bracketed] chunks
+/*
+ * @param     string      line data
+ *
+ * @return    string[]    array of fields, with quotes and brackets removed
+ */
+func splitLogFields(line string) []string {
+
+    // variable declaration
+    var fields = make([]string, 0)
+    var current = make([]byte, 0, len(line))
+    var closing byte = 0
+    var in_field = false
+
+    // for every character in the line...
+    for i := 0; i < len(line); i++ {
+
+        c := line[i]
+
+        // if currently inside a quoted or bracketed chunk...
+        if closing != 0 {
+
+            // escaped quotes are part of the value, e.g. \" in user agents,
+            // whereas other escapes such as \x16 are left as they are
+            if c == '\\' && closing == '"' && i+1 < len(line) &&
+              (line[i+1] == '"' || line[i+1] == '\\') {
+                current = append(current, line[i+1])
+                i++
+                continue
+            }
+
+            // if this is the closing char, then the chunk has ended
+            if c == closing {
+                fields = append(fields, string(current))
+                current = current[:0]
+                closing = 0
+                in_field = false
+                continue
+            }
+
+            // otherwise append the character to the chunk
+            current = append(current, c)
+            continue
+        }
+
+        // spaces separate the fields
+        if c == ' ' {
+            if in_field {
+                fields = append(fields, string(current))
+                current = current[:0]
+                in_field = false
+            }
+            continue
+        }
+
+        // start of a quoted or bracketed chunk
+        if !in_field && (c == '"' || c == '[') {
+            closing = '"'
+            if c == '[' {
+                closing = ']'
+            }
+            in_field = true
+            continue
+        }
+
+        // otherwise it is a regular character of a field
+        current = append(current, c)
+        in_field = true
+    }
+
+    // if the line ended during a field, go ahead and append it
+    if in_field {
+        fields = append(fields, string(current))
+    }
+
+    // pass back the fields
+    return fields
+}
+
+//! Parse an access log line into a logEntry
+/*
+ * @param     string      line data
+ *
+ * @return    logEntry    parsed entry
+ * @return    error       error message, if any
+ */
+func parseLogLine(line string) (logEntry, error) {
+
+    // variable declaration
+    var entry logEntry
+    var err error
+
+    // input validation
+    if len(line) < 1 {
+        return entry, fmt.Errorf("parseLogLine() --> invalid input")
+    }
+
+    // attempt to break the line into fields
+    fields := splitLogFields(line)
+
+    // safety check, the combined format has at least 7 fields before the
+    // referer and user agent
+    if len(fields) < 7 {
+        return entry, fmt.Errorf("parseLogLine() --> poorly formatted line")
+    }
+
+    // the first element is the IP address
+    entry.ip = fields[0]
+
+    // attempt to parse the date-time stamp
+    entry.timestamp, err = time.Parse(accessLogTimeLayout, fields[3])
+    if err != nil {
+        return entry, fmt.Errorf("parseLogLine() --> unable to parse " +
+          "date-time: %s", fields[3])
+    }
+
+    // the request line is usually "METHOD /path PROTOCOL", but scanners
+    // and broken clients can send almost anything here
+    entry.request = fields[4]
+    request_pieces := strings.Split(entry.request, " ")
+    if len(request_pieces) >= 2 {
+        entry.method = request_pieces[0]
+        entry.path = request_pieces[1]
+    }
+    if len(request_pieces) >= 3 {
+        entry.protocol = request_pieces[len(request_pieces)-1]
+    }
+
+    // attempt to obtain the HTTP status code
+    entry.status, err = strconv.Atoi(fields[5])
+    if err != nil {
+        return entry, fmt.Errorf("parseLogLine() --> improper status " +
+          "code: %s", fields[5])
+    }
+
+    // the byte count is "-" when nothing was sent, so treat it as zero
+    entry.bytes, _ = strconv.Atoi(fields[6])
+
+    // the referer and user agent are only present in the combined format
+    if len(fields) >= 8 {
+        entry.referer = fields[7]
+    }
+    if len(fields) >= 9 {
+        entry.user_agent = fields[8]
+    }
+
+    // any fields that follow are custom additions, so check them for
+    // response times; either as "key=value" or as bare numbers, where the
+    // first is the request time and the second the upstream time
+    entry.request_time = -1
+    entry.upstream_time = -1
+    for i := 9; i < len(fields); i++ {
+
+        key, value := "", fields[i]
+        if eq := strings.Index(value, "="); eq > 0 {
+            key, value = value[:eq], value[eq+1:]
+        }
+
+        seconds, ok := parseResponseTime(value)
+        if !ok {
+            continue
+        }
+
+        switch key {
+        case "rt", "request_time":
+            entry.request_time = seconds
+        case "urt", "upstream_time", "upstream_response_time":
+            entry.upstream_time = seconds
+        case "":
+            if entry.request_time < 0 {
+                entry.request_time = seconds
+            } else if entry.upstream_time < 0 {
+                entry.upstream_time = seconds
+            }
+        }
+    }
+
+    // having gotten this far, pass back the entry
+    return entry, nil
+}
+
+//! Convert a response time field of the log into seconds
+/*
+ * nginx logs response times as seconds with millisecond resolution,
+ * e.g. 0.042, whereas apache %D logs whole microseconds, e.g. 42000.
+ *
+ * @param     string     response time field
+ *
+ * @return    float64    response time, in seconds
+ * @return    bool       whether or not the field was a response time
+ */
+func parseResponseTime(value string) (float64, bool) {
+
+    // upstream times of several upstreams are comma separated
+    value = strings.Trim(value, ",\"")
+
+    // input validation, nginx uses "-" when there was no upstream
+    if len(value) < 1 || value == "-" {
+        return 0, false
+    }
+
+    // seconds, as used by nginx
+    if strings.Contains(value, ".") {
+        seconds, err := strconv.ParseFloat(value, 64)
+        if err != nil || seconds < 0 {
+            return 0, false
+        }
+        return seconds, true
+    }
+
+    // otherwise microseconds, as used by apache
+    micro, err := strconv.ParseUint(value, 10, 63)
+    if err != nil {
+        return 0, false
+    }
+    return float64(micro) / 1000000, true
+}
@@ -0,0 +1,51 @@
+//
+// Log parser tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "testing"
+)
+
+func TestParseResponseTime(t *testing.T) {
+
+    tests := []struct {
+        value   string
+        seconds float64
+        valid   bool
+    }{
+        {"", 0, false},
+        {"-", 0, false},
+        {"\"-\"", 0, false},
+        {",", 0, false},
+        {"0.042", 0.042, true},
+        {"0.000", 0, true},
+        {"12.5", 12.5, true},
+        {"\"0.042\"", 0.042, true},
+        {"0.010,", 0.01, true},
+        {"42000", 0.042, true},
+        {"0", 0, true},
+        {"1500000", 1.5, true},
+        {"-0.5", 0, false},
+        {"-42", 0, false},
+        {"0.0.1", 0, false},
+        {"fast", 0, false},
+        {"1e3", 0, false},
+        {"99999999999999999999", 0, false},
+    }
+
+    for _, test := range tests {
+        seconds, valid := parseResponseTime(test.value)
+        if seconds != test.seconds || valid != test.valid {
+            t.Errorf("parseResponseTime(%q) = %v, %v, want %v, %v",
+              test.value, seconds, valid, test.seconds, test.valid)
+        }
+    }
+}
@@ -0,0 +1,590 @@
+//
+// Blocking policy functions for ASCII-log
+//
+// A policy is a list of rules, one per line, evaluated in order, like so:
+//
+// # name            action  conditions...
+// redirects         block   redirects>=1
+// home-countries    allow   country=US,CA,GB
+// busy              block   requests>=5 error_ratio>=0.5
+//
+// All of the conditions of a rule have to match for it to fire. The first
+// allow or block rule that fires decides the outcome for the address,
+// whereas flag rules are merely noted and evaluation carries on.
+//
+// Block rules may also set how long the addresses they match stay blocked,
+// using a ttl option; e.g. "probes  block  signatures>=3  ttl=7d".
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "net"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Actions of a policy rule.
+const (
+    policyAllow = "allow"
+    policyBlock = "block"
+    policyFlag  = "flag"
+)
+
+// Fields matched against a list of values, using = or !=
+var policyListFields = []string{"country", "asn", "cidr", "ua",
+  "signature"}
+
+// Fields compared against a number, using >=, >, <=, < or =
+var policyNumberFields = []string{"requests", "rate", "redirects",
+  "malformed", "signatures", "errors", "error_ratio", "4xx_ratio",
+  "5xx_ratio"}
+
+// Policy used when no policy file is given, which mirrors the original
+// hard-coded behaviour of this program.
+const defaultPolicy = `
+verified-crawlers  allow  ua=verified-crawler
+fake-crawlers      block  ua=fake-crawler
+redirects          block  redirects>=1
+malformed          block  malformed>=2
+unknown-country    allow  country=--
+home-countries     allow  country=US,CA,GB,FR,DE,NL
+busy               block  requests>=5
+`
+
+//! A single condition of a policy rule; e.g. requests>=5
+type policyCondition struct {
+    field    string
+    operator string
+    values   []string
+    networks []*net.IPNet
+    number   float64
+}
+
+//! A single rule of a policy
+type policyRule struct {
+    name       string
+    action     string
+    line       int
+    ttl        time.Duration
+    conditions []policyCondition
+}
+
+//! Everything known about an IP address that the policy can match on
+type policyInput struct {
+    ip       string
+    requests int
+    country  string
+    asn      string
+    crawler  string
+    stats    *ipStats
+}
+
+//! Outcome of evaluating the policy against an IP address
+type policyDecision struct {
+    action string
+    rule   string
+    ttl    time.Duration
+    flags  []string
+}
+
+//! Parse a single condition of a policy rule
+/*
+ * @param     string             condition; e.g. country=US,CA
+ *
+ * @return    policyCondition    parsed condition
+ * @return    error              error message, if any
+ */
+func parsePolicyCondition(text string) (policyCondition, error) {
+
+    // variable declaration
+    var condition policyCondition
+
+    // find the operator, checking the two character ones first
+    for _, operator := range []string{">=", "<=", "!=", "=", ">", "<"} {
+        if i := strings.Index(text, operator); i > 0 {
+            condition.field = strings.ToLower(text[:i])
+            condition.operator = operator
+            text = text[i+len(operator):]
+            break
+        }
+    }
+    if len(condition.operator) < 1 || len(text) < 1 {
+        return condition, fmt.Errorf("improper condition '%s'", text)
+    }
+
+    // numeric fields
+    if isStringInArray(condition.field, policyNumberFields) {
+        if condition.operator == "!=" {
+            return condition, fmt.Errorf("operator != cannot be used " +
+              "with '%s'", condition.field)
+        }
+        number, err := strconv.ParseFloat(text, 64)
+        if err != nil {
+            return condition, fmt.Errorf("'%s' needs a number, not '%s'",
+              condition.field, text)
+        }
+        condition.number = number
+        return condition, nil
+    }
+
+    // otherwise it ought to be a list field
+    if !isStringInArray(condition.field, policyListFields) {
+        return condition, fmt.Errorf("unknown field '%s'", condition.field)
+    }
+    if condition.operator != "=" && condition.operator != "!=" {
+        return condition, fmt.Errorf("'%s' can only be used with = or !=",
+          condition.field)
+    }
+
+    for _, value := range strings.Split(text, ",") {
+
+        value = strings.TrimSpace(value)
+        if len(value) < 1 {
+            continue
+        }
+
+        switch condition.field {
+
+        case "country":
+            value = strings.ToUpper(value)
+            if len(value) != 2 {
+                return condition, fmt.Errorf("'%s' is not a two letter " +
+                  "country code", value)
+            }
+
+        case "asn":
+            value = strings.ToUpper(value)
+            if !strings.HasPrefix(value, "AS") {
+                value = "AS" + value
+            }
+            if _, err := strconv.ParseUint(value[2:], 10, 32); err != nil {
+                return condition, fmt.Errorf("'%s' is not an AS number",
+                  value)
+            }
+
+        case "cidr":
+            if !strings.Contains(value, "/") {
+                if strings.Contains(value, ":") {
+                    value += "/128"
+                } else {
+                    value += "/32"
+                }
+            }
+            _, network, err := net.ParseCIDR(value)
+            if err != nil {
+                return condition, fmt.Errorf("'%s' is not a CIDR", value)
+            }
+            condition.networks = append(condition.networks, network)
+
+        case "ua":
+            value = strings.ToLower(value)
+            if !isStringInArray(value, []string{uaEmpty, uaTool, uaScanner,
+              uaBot, uaBrowser, uaOther, uaVerifiedCrawler,
+              uaFakeCrawler, uaUnverifiedCrawler}) {
+                return condition, fmt.Errorf("unknown user agent class " +
+                  "'%s'", value)
+            }
+
+        case "signature":
+            value = strings.ToLower(value)
+            known := false
+            for _, signature := range attackSignatures {
+                if signature.name == value {
+                    known = true
+                }
+            }
+            if !known {
+                return condition, fmt.Errorf("unknown signature '%s'",
+                  value)
+            }
+        }
+
+        condition.values = append(condition.values, value)
+    }
+
+    if len(condition.values) < 1 {
+        return condition, fmt.Errorf("'%s' needs at least one value",
+          condition.field)
+    }
+
+    return condition, nil
+}
+
+//! Parse the block length of a policy rule
+/*
+ * @param     string      duration; e.g. 12h, or 7d for whole days
+ *
+ * @return    Duration    block length
+ * @return    error       error message, if any
+ */
+func parsePolicyTTL(text string) (time.Duration, error) {
+
+    // time.ParseDuration has no notion of days, but block lengths tend to
+    // be measured in them
+    if strings.HasSuffix(text, "d") {
+        days, err := strconv.Atoi(strings.TrimSuffix(text, "d"))
+        if err == nil && days > 0 {
+            return time.Duration(days) * 24 * time.Hour, nil
+        }
+    }
+
+    ttl, err := time.ParseDuration(text)
+    if err != nil || ttl <= 0 {
+        return 0, fmt.Errorf("'%s' is not a positive duration", text)
+    }
+
+    return ttl, nil
+}
+
+//! Parse the contents of a policy
+/*
+ * @param     string          policy contents
+ *
+ * @return    policyRule[]    array of rules, in order
+ * @return    error           every problem found, one per line
+ */
+func parsePolicy(contents string) ([]policyRule, error) {
+
+    // variable declaration
+    var rules = make([]policyRule, 0)
+    var problems = make([]string, 0)
+    var names = make([]string, 0)
+
+    for i, line := range strings.Split(contents, "\n") {
+
+        // strip away comments and blank lines
+        if hash := strings.Index(line, "#"); hash >= 0 {
+            line = line[:hash]
+        }
+        pieces := strings.Fields(line)
+        if len(pieces) < 1 {
+            continue
+        }
+
+        rule := policyRule{name: pieces[0], line: i+1}
+
+        // every rule needs a name and an action
+        if len(pieces) < 2 {
+            problems = append(problems, fmt.Sprintf("line %d: rule '%s' " +
+              "has no action", rule.line, rule.name))
+            continue
+        }
+        rule.action = strings.ToLower(pieces[1])
+        if rule.action != policyAllow && rule.action != policyBlock &&
+          rule.action != policyFlag {
+            problems = append(problems, fmt.Sprintf("line %d: unknown " +
+              "action '%s'", rule.line, pieces[1]))
+        }
+        if isStringInArray(rule.name, names) {
+            problems = append(problems, fmt.Sprintf("line %d: rule '%s' " +
+              "is defined twice", rule.line, rule.name))
+        }
+        names = append(names, rule.name)
+
+        for _, text := range pieces[2:] {
+
+            // the ttl option is not a condition, but the block length
+            if strings.HasPrefix(strings.ToLower(text), "ttl=") {
+                ttl, err := parsePolicyTTL(text[len("ttl="):])
+                if err != nil {
+                    problems = append(problems, fmt.Sprintf("line %d: %s",
+                      rule.line, err.Error()))
+                } else if rule.action != policyBlock {
+                    problems = append(problems, fmt.Sprintf("line %d: " +
+                      "only block rules can have a ttl", rule.line))
+                }
+                rule.ttl = ttl
+                continue
+            }
+
+            condition, err := parsePolicyCondition(text)
+            if err != nil {
+                problems = append(problems, fmt.Sprintf("line %d: %s",
+                  rule.line, err.Error()))
+                continue
+            }
+            rule.conditions = append(rule.conditions, condition)
+        }
+
+        rules = append(rules, rule)
+    }
+
+    if len(problems) > 0 {
+        return nil, fmt.Errorf("parsePolicy() --> %s",
+          strings.Join(problems, "; "))
+    }
+
+    return rules, nil
+}
+
+//! Read and parse a policy file, or the default policy if none is given
+/*
+ * @param     string          /path/to/file, or blank
+ *
+ * @return    policyRule[]    array of rules, in order
+ * @return    error           error message, if any
+ */
+func readPolicyFile(path string) ([]policyRule, error) {
+
+    // if no file is given, use the default policy
+    if len(path) < 1 {
+        return parsePolicy(defaultPolicy)
+    }
+
+    byte_contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("readPolicyFile() --> unable to read the " +
+          "following file: %s", path)
+    }
+
+    return parsePolicy(string(byte_contents))
+}
+
+//! Obtain the value of a numeric field for a given IP address
+/*
+ * @param     string         field name
+ * @param     policyInput    everything known about the address
+ *
+ * @return    float64        value of the field
+ */
+func obtainPolicyNumber(field string, input policyInput) float64 {
+
+    // the request count comes from the IP counts, since those are still
+    // present in sketch mode
+    if field == "requests" {
+        return float64(input.requests)
+    }
+
+    // the remaining fields need the parsed entries
+    stats := input.stats
+    if stats == nil {
+        return 0
+    }
+
+    ratio := func(count int) float64 {
+        if stats.requests < 1 {
+            return 0
+        }
+        return float64(count) / float64(stats.requests)
+    }
+
+    switch field {
+    case "rate":
+        return float64(stats.peak_rate)
+    case "redirects":
+        return float64(stats.redirects)
+    case "malformed":
+        return float64(stats.malformed)
+    case "errors":
+        return float64(stats.errors)
+    case "error_ratio":
+        return ratio(stats.errors)
+    case "4xx_ratio":
+        return ratio(stats.status4xx)
+    case "5xx_ratio":
+        return ratio(stats.status5xx)
+    case "signatures":
+        total := 0
+        for _, count := range stats.signatures {
+            total += count
+        }
+        return float64(total)
+    }
+
+    return 0
+}
+
+//! Check whether a condition matches a given IP address
+/*
+ * @param     policyInput    everything known about the address
+ *
+ * @return    bool           whether or not the condition matches
+ */
+func (c policyCondition) matches(input policyInput) bool {
+
+    // numeric fields
+    if isStringInArray(c.field, policyNumberFields) {
+        value := obtainPolicyNumber(c.field, input)
+        switch c.operator {
+        case ">=":
+            return value >= c.number
+        case ">":
+            return value > c.number
+        case "<=":
+            return value <= c.number
+        case "<":
+            return value < c.number
+        }
+        return value == c.number
+    }
+
+    // list fields match if any of the values match
+    found := false
+    switch c.field {
+
+    case "country":
+        found = isStringInArray(input.country, c.values)
+
+    case "asn":
+        found = isStringInArray(input.asn, c.values)
+
+    case "cidr":
+        parsed := net.ParseIP(input.ip)
+        for _, network := range c.networks {
+            if parsed != nil && network.Contains(parsed) {
+                found = true
+            }
+        }
+
+    case "ua":
+        if input.crawler == crawlerVerified {
+            found = isStringInArray(uaVerifiedCrawler, c.values)
+        } else if input.crawler == crawlerFake {
+            found = isStringInArray(uaFakeCrawler, c.values)
+        } else if input.crawler == crawlerUnverified {
+            found = isStringInArray(uaUnverifiedCrawler, c.values)
+        }
+        if input.stats != nil {
+            for class, _ := range input.stats.ua_classes {
+                if isStringInArray(class, c.values) {
+                    found = true
+                }
+            }
+        }
+
+    case "signature":
+        if input.stats != nil {
+            for name, _ := range input.stats.signatures {
+                if isStringInArray(name, c.values) {
+                    found = true
+                }
+            }
+        }
+    }
+
+    if c.operator == "!=" {
+        return !found
+    }
+    return found
+}
+
+//! Check whether every condition of a rule matches a given IP address
+/*
+ * @param     policyInput    everything known about the address
+ *
+ * @return    bool           whether or not the rule fires
+ */
+func (r policyRule) matches(input policyInput) bool {
+
+    for _, condition := range r.conditions {
+        if !condition.matches(input) {
+            return false
+        }
+    }
+    return true
+}
+
+//! Evaluate the policy against an IP address
+/*
+ * @param     policyRule[]      array of rules, in order
+ * @param     policyInput       everything known about the address
+ *
+ * @return    policyDecision    outcome, with a blank action if no allow or
+ *                              block rule fired
+ */
+func evaluatePolicy(rules []policyRule, input policyInput) policyDecision {
+
+    // variable declaration
+    var decision policyDecision
+
+    for _, rule := range rules {
+
+        if !rule.matches(input) {
+            continue
+        }
+
+        // flags are noted, but do not decide the outcome
+        if rule.action == policyFlag {
+            decision.flags = append(decision.flags, rule.name)
+            continue
+        }
+
+        decision.action = rule.action
+        decision.rule = rule.name
+        decision.ttl = rule.ttl
+        break
+    }
+
+    return decision
+}
+
+//! Convert the policy decisions into the contents of the policy log
+/*
+ * @param     policyRule[]    array of rules, in order
+ * @param     map             map of ip addresses and their decisions
+ *
+ * @return    string          policy report
+ */
+func convertPolicyDecisionsToString(rules []policyRule,
+  decisions map[string] policyDecision) string {
+
+    // variable declaration
+    var policy_strings string = ""
+    var hits = make(map[string] int)
+    var ips = make([]string, 0, len(decisions))
+
+    for ip, decision := range decisions {
+        if len(decision.rule) > 0 {
+            hits[decision.rule]++
+        }
+        for _, name := range decision.flags {
+            hits[name]++
+        }
+        ips = append(ips, ip)
+    }
+    sort.Strings(ips)
+
+    // the number of times every rule fired
+    policy_strings += "Rules\n\n"
+    for _, rule := range rules {
+        policy_strings += fmt.Sprintf("%6d | %-5s | %s\n", hits[rule.name],
+          rule.action, rule.name)
+    }
+
+    // the decision of every address that matched a rule
+    policy_strings += "\nDecisions\n\n"
+    listed := 0
+    for _, ip := range ips {
+        decision := decisions[ip]
+        if len(decision.rule) < 1 && len(decision.flags) < 1 {
+            continue
+        }
+        action, rule := decision.action, decision.rule
+        if len(action) < 1 {
+            action, rule = "-", "-"
+        }
+        flags := ""
+        if len(decision.flags) > 0 {
+            flags = " (flagged: " + strings.Join(decision.flags, ", ") + ")"
+        }
+        policy_strings += fmt.Sprintf("%-5s | %-39s | %s%s\n", action, ip,
+          rule, flags)
+        listed++
+    }
+    if listed < 1 {
+        policy_strings += "None.\n"
+    }
+
+    return policy_strings
+}
@@ -0,0 +1,245 @@
+//
+// Blocking policy tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParsePolicyCondition(t *testing.T) {
+
+    tests := []struct {
+        text     string
+        field    string
+        operator string
+        values   string
+        number   float64
+        fails    bool
+    }{
+        {text: "requests>=5", field: "requests", operator: ">=",
+          number: 5},
+        {text: "error_ratio<0.5", field: "error_ratio", operator: "<",
+          number: 0.5},
+        {text: "Country=us, ca", field: "country", operator: "=",
+          values: "US,CA"},
+        {text: "country!=US,CA", field: "country", operator: "!=",
+          values: "US,CA"},
+        {text: "asn=64500,as64501", field: "asn", operator: "=",
+          values: "AS64500,AS64501"},
+        {text: "cidr=10.0.0.0/8,2001:db8::1", field: "cidr",
+          operator: "=", values: "10.0.0.0/8,2001:db8::1/128"},
+        {text: "ua=Unverified-Crawler", field: "ua", operator: "=",
+          values: "unverified-crawler"},
+        {text: "requests!=5", fails: true},
+        {text: "requests>=many", fails: true},
+        {text: "country>=US", fails: true},
+        {text: "country=USA", fails: true},
+        {text: "asn=ASX", fails: true},
+        {text: "cidr=10.0.0.0/33", fails: true},
+        {text: "ua=robot", fails: true},
+        {text: "signature=nope", fails: true},
+        {text: "colour=red", fails: true},
+        {text: "country=", fails: true},
+        {text: "country=,", fails: true},
+        {text: "requests", fails: true},
+    }
+
+    for _, test := range tests {
+
+        condition, err := parsePolicyCondition(test.text)
+        if test.fails {
+            if err == nil {
+                t.Errorf("parsePolicyCondition(%q) parsed, expected an " +
+                  "error", test.text)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("parsePolicyCondition(%q) failed: %v", test.text, err)
+            continue
+        }
+
+        values := strings.Join(condition.values, ",")
+        if condition.field != test.field ||
+          condition.operator != test.operator || values != test.values ||
+          condition.number != test.number {
+            t.Errorf("parsePolicyCondition(%q) = %s %s %q %v", test.text,
+              condition.field, condition.operator, values, condition.number)
+        }
+    }
+}
+
+func TestParsePolicy(t *testing.T) {
+
+    // both the built-in policy and the example file parse
+    for _, path := range []string{"", "ascii-log.policy"} {
+        rules, err := readPolicyFile(path)
+        if err != nil || len(rules) < 1 {
+            t.Errorf("readPolicyFile(%q) = %d rules, %v", path, len(rules),
+              err)
+        }
+    }
+
+    rules, err := parsePolicy("# comment\n\n" +
+      "office  allow  cidr=192.0.2.0/24  # inline comment\n" +
+      "probes  block  signatures>=3 ttl=7d\n" +
+      "noisy   flag   rate>=120\n")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if len(rules) != 3 || rules[0].name != "office" ||
+      rules[0].line != 3 || rules[1].ttl != 7 * 24 * time.Hour ||
+      rules[2].action != policyFlag {
+        t.Errorf("rules = %+v", rules)
+    }
+
+    // every problem is reported at once, by line
+    _, err = parsePolicy("lonely\n" +
+      "bad     deny   requests>=5\n" +
+      "short   allow  ttl=1h\n" +
+      "never   block  ttl=0s\n" +
+      "broken  block  requests>=x\n" +
+      "bad     block  requests>=5\n")
+    if err == nil {
+        t.Fatalf("expected the problems to be reported")
+    }
+    for _, want := range []string{"line 1: rule 'lonely' has no action",
+      "line 2: unknown action 'deny'",
+      "line 3: only block rules can have a ttl",
+      "line 4: '0s' is not a positive duration",
+      "line 5: 'requests' needs a number",
+      "line 6: rule 'bad' is defined twice"} {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("error lacks %q: %v", want, err)
+        }
+    }
+}
+
+func TestParsePolicyTTL(t *testing.T) {
+
+    tests := []struct {
+        text  string
+        want  time.Duration
+        fails bool
+    }{
+        {text: "12h", want: 12 * time.Hour},
+        {text: "7d", want: 7 * 24 * time.Hour},
+        {text: "90m", want: 90 * time.Minute},
+        {text: "0d", fails: true},
+        {text: "-1h", fails: true},
+        {text: "week", fails: true},
+    }
+
+    for _, test := range tests {
+        got, err := parsePolicyTTL(test.text)
+        if (err != nil) != test.fails || got != test.want {
+            t.Errorf("parsePolicyTTL(%q) = %v, %v", test.text, got, err)
+        }
+    }
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+
+    rules, err := parsePolicy(`
+office             allow  cidr=192.0.2.0/24
+verified-crawlers  allow  ua=verified-crawler
+fake-crawlers      block  ua=fake-crawler
+probes             block  signature=dotenv ttl=7d
+hammering          flag   rate>=120
+noisy-network      flag   asn=AS64500
+home-countries     allow  country=US,CA
+busy               block  requests>=5 error_ratio>=0.5
+`)
+    if err != nil {
+        t.Fatalf("unable to parse the policy: %v", err)
+    }
+
+    busy := &ipStats{requests: 10, errors: 6, peak_rate: 150,
+      ua_classes: map[string] int{uaBrowser: 10}}
+    probing := &ipStats{requests: 3,
+      signatures: map[string] int{"dotenv": 2}}
+
+    tests := []struct {
+        name   string
+        input  policyInput
+        action string
+        rule   string
+        ttl    time.Duration
+        flags  string
+    }{
+        {
+            name:   "allowlisted network wins over everything",
+            input:  policyInput{ip: "192.0.2.9", requests: 50,
+              country: "BR", crawler: crawlerFake, stats: busy},
+            action: policyAllow,
+            rule:   "office",
+        },
+        {
+            name:   "verified crawler",
+            input:  policyInput{ip: "198.51.100.1", requests: 50,
+              country: "BR", crawler: crawlerVerified},
+            action: policyAllow,
+            rule:   "verified-crawlers",
+        },
+        {
+            name:   "fake crawler",
+            input:  policyInput{ip: "198.51.100.1", country: "US",
+              crawler: crawlerFake},
+            action: policyBlock,
+            rule:   "fake-crawlers",
+        },
+        {
+            name:   "unverified crawler is neither",
+            input:  policyInput{ip: "198.51.100.1", requests: 1,
+              country: "BR", crawler: crawlerUnverified},
+        },
+        {
+            name:   "signature block carries its ttl",
+            input:  policyInput{ip: "198.51.100.2", requests: 3,
+              country: "US", stats: probing},
+            action: policyBlock,
+            rule:   "probes",
+            ttl:    7 * 24 * time.Hour,
+        },
+        {
+            name:   "flags noted on the way to a block",
+            input:  policyInput{ip: "198.51.100.3", requests: 10,
+              country: "BR", asn: "AS64500", stats: busy},
+            action: policyBlock,
+            rule:   "busy",
+            flags:  "hammering,noisy-network",
+        },
+        {
+            name:   "flags noted on the way to an allow",
+            input:  policyInput{ip: "198.51.100.3", requests: 10,
+              country: "CA", stats: busy},
+            action: policyAllow,
+            rule:   "home-countries",
+            flags:  "hammering",
+        },
+        {
+            name:   "busy but without errors",
+            input:  policyInput{ip: "198.51.100.4", requests: 10,
+              country: "BR"},
+        },
+    }
+
+    for _, test := range tests {
+        decision := evaluatePolicy(rules, test.input)
+        if decision.action != test.action || decision.rule != test.rule ||
+          decision.ttl != test.ttl ||
+          strings.Join(decision.flags, ",") != test.flags {
+            t.Errorf("%s: decision = %+v", test.name, decision)
+        }
+    }
+}
@@ -0,0 +1,427 @@
+//
+// RDAP client functions for ASCII-log
+//
+// RDAP answers with JSON, rather than free text, so its network objects are
+// preferred over whois records. The registry to ask is picked with the IANA
+// bootstrap files, e.g. https://data.iana.org/rdap/ipv4.json, which map
+// every allocated prefix to the RDAP servers of its registry:
+//
+// {"services": [[["41.0.0.0/8"], ["https://rdap.afrinic.net/rdap/"]]]}
+//
+// The network object is mapped straight onto the fields of a whoisRecord,
+// so that it needs none of the guesswork of parsing whois text; it is
+// cached as such, and only listed in the whois log.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Largest RDAP answer that is read, in bytes.
+const rdapMaxResponse = 1 << 20
+
+//! Network details gathered from an RDAP network object
+type rdapRecord struct {
+    country     string
+    name        string
+    handle      string
+    cidr        string
+    asn         string
+    org         string
+    abuse_email string
+}
+
+//! A prefix of the bootstrap files, and the RDAP servers of its registry
+type rdapService struct {
+    network *net.IPNet
+    urls    []string
+}
+
+//! RDAP client
+type rdapClient struct {
+    services []rdapService
+    server   string
+    http     *http.Client
+}
+
+//! Contents of a bootstrap file
+type rdapBootstrapFile struct {
+    Services [][][]string `json:"services"`
+}
+
+//! Entity of an RDAP object; e.g. the registrant or abuse contact
+type rdapEntity struct {
+    Handle     string        `json:"handle"`
+    Roles      []string      `json:"roles"`
+    VcardArray []interface{} `json:"vcardArray"`
+    Entities   []rdapEntity  `json:"entities"`
+}
+
+//! RDAP IP network object
+type rdapNetworkObject struct {
+    ObjectClassName string       `json:"objectClassName"`
+    Handle          string       `json:"handle"`
+    Name            string       `json:"name"`
+    Country         string       `json:"country"`
+    StartAddress    string       `json:"startAddress"`
+    EndAddress      string       `json:"endAddress"`
+    Entities        []rdapEntity `json:"entities"`
+    Cidrs           []struct {
+        V4prefix string `json:"v4prefix"`
+        V6prefix string `json:"v6prefix"`
+        Length   int    `json:"length"`
+    } `json:"cidr0_cidrs"`
+    OriginAutnums   []int64      `json:"arin_originas0_originautnums"`
+}
+
+//! Read the bootstrap files
+/*
+ * @param     string           comma separated list of /path/to/files
+ *
+ * @return    rdapService[]    prefixes and the RDAP servers of each
+ * @return    error            error message, if any
+ */
+func readRdapBootstrap(paths string) ([]rdapService, error) {
+
+    // variable declaration
+    var services = make([]rdapService, 0)
+
+    for _, path := range strings.Split(paths, ",") {
+
+        path = strings.TrimSpace(path)
+        if len(path) < 1 {
+            continue
+        }
+
+        byte_contents, err := ioutil.ReadFile(path)
+        if err != nil {
+            return nil, fmt.Errorf("readRdapBootstrap() --> unable to " +
+              "read %s: %s", path, err.Error())
+        }
+
+        var bootstrap rdapBootstrapFile
+        err = json.Unmarshal(byte_contents, &bootstrap)
+        if err != nil {
+            return nil, fmt.Errorf("readRdapBootstrap() --> %s is " +
+              "improper: %s", path, err.Error())
+        }
+
+        for _, service := range bootstrap.Services {
+            if len(service) < 2 || len(service[1]) < 1 {
+                continue
+            }
+            for _, prefix := range service[0] {
+                _, network, err := net.ParseCIDR(prefix)
+                if err != nil {
+                    return nil, fmt.Errorf("readRdapBootstrap() --> %s " +
+                      "lists an improper prefix: %s", path, prefix)
+                }
+                services = append(services, rdapService{network: network,
+                  urls: service[1]})
+            }
+        }
+    }
+
+    return services, nil
+}
+
+//! Assemble an RDAP client
+/*
+ * @param     rdapService[]    prefixes and the RDAP servers of each
+ * @param     string           RDAP server of addresses outside of them, if any
+ * @param     Duration         timeout of every lookup
+ *
+ * @return    rdapClient       RDAP client
+ */
+func newRdapClient(services []rdapService, server string,
+  timeout time.Duration) *rdapClient {
+
+    return &rdapClient{services: services, server: server,
+      http: &http.Client{Timeout: timeout}}
+}
+
+//! Obtain the RDAP server of an IP address
+/*
+ * The most specific prefix wins, and https is preferred over http.
+ *
+ * @param     IP        IP address
+ *
+ * @return    string    base URL of the server, or blank if there is none
+ */
+func (c *rdapClient) obtainServer(ip net.IP) string {
+
+    // variable declaration
+    var result string = c.server
+    var result_bits int = -1
+
+    for _, service := range c.services {
+        if !service.network.Contains(ip) {
+            continue
+        }
+        bits, _ := service.network.Mask.Size()
+        if bits <= result_bits {
+            continue
+        }
+        result_bits = bits
+        result = service.urls[0]
+        for _, url := range service.urls {
+            if strings.HasPrefix(url, "https://") {
+                result = url
+                break
+            }
+        }
+    }
+
+    if len(result) > 0 && !strings.HasSuffix(result, "/") {
+        result += "/"
+    }
+    return result
+}
+
+//! Obtain the value of a vCard property of an entity
+/*
+ * @param     rdapEntity    entity
+ * @param     string        property name; e.g. email
+ *
+ * @return    string        value of the first such property, if any
+ */
+func obtainVcardValue(entity rdapEntity, name string) string {
+
+    // vcardArray is ["vcard", [[name, params, type, value], ...]]
+    if len(entity.VcardArray) < 2 {
+        return ""
+    }
+    properties, ok := entity.VcardArray[1].([]interface{})
+    if !ok {
+        return ""
+    }
+
+    for _, raw := range properties {
+        property, ok := raw.([]interface{})
+        if !ok || len(property) < 4 {
+            continue
+        }
+        if key, ok := property[0].(string); !ok || key != name {
+            continue
+        }
+        if value, ok := property[3].(string); ok {
+            return value
+        }
+    }
+
+    return ""
+}
+
+//! Obtain the first entity with a given role, searching nested entities too
+/*
+ * @param     rdapEntity[]    entities
+ * @param     string          role; e.g. abuse
+ *
+ * @return    rdapEntity      entity with the role
+ * @return    bool            whether or not one was found
+ */
+func obtainRdapEntity(entities []rdapEntity, role string) (rdapEntity,
+  bool) {
+
+    for _, entity := range entities {
+        if isStringInArray(role, entity.Roles) {
+            return entity, true
+        }
+    }
+    for _, entity := range entities {
+        if nested, found := obtainRdapEntity(entity.Entities, role); found {
+            return nested, true
+        }
+    }
+
+    return rdapEntity{}, false
+}
+
+//! Parse an RDAP IP network object
+/*
+ * @param     byte[]        JSON of the network object
+ *
+ * @return    rdapRecord    network details; fields are blank if absent
+ * @return    error         error message, if any
+ */
+func parseRdapNetwork(body []byte) (rdapRecord, error) {
+
+    // variable declaration
+    var record rdapRecord
+    var object rdapNetworkObject
+    var cidrs = make([]string, 0)
+
+    err := json.Unmarshal(body, &object)
+    if err != nil {
+        return record, err
+    }
+    if object.ObjectClassName != "ip network" {
+        return record, fmt.Errorf("not an ip network object: '%s'",
+          object.ObjectClassName)
+    }
+
+    record.country = strings.ToUpper(object.Country)
+    record.name = object.Name
+    record.handle = object.Handle
+
+    // the cidr0 extension lists the CIDRs, else they follow from the range
+    for _, cidr := range object.Cidrs {
+        prefix := cidr.V4prefix
+        if len(prefix) < 1 {
+            prefix = cidr.V6prefix
+        }
+        if len(prefix) > 0 {
+            cidrs = append(cidrs, fmt.Sprintf("%s/%d", prefix, cidr.Length))
+        }
+    }
+    if len(cidrs) < 1 && len(object.StartAddress) > 0 {
+        cidrs, _ = convertRangeToCidrs(object.StartAddress,
+          object.EndAddress)
+    }
+    record.cidr = strings.Join(cidrs, ",")
+
+    // only ARIN lists the origin AS, via its originas0 extension
+    if len(object.OriginAutnums) > 0 {
+        record.asn = fmt.Sprintf("AS%d", object.OriginAutnums[0])
+    }
+
+    if registrant, found := obtainRdapEntity(object.Entities,
+      "registrant"); found {
+        record.org = obtainVcardValue(registrant, "fn")
+    }
+    if abuse, found := obtainRdapEntity(object.Entities, "abuse"); found {
+        record.abuse_email = obtainVcardValue(abuse, "email")
+    }
+
+    return record, nil
+}
+
+//! Look up the network object of an IP address
+/*
+ * @param     Context       context of the lookup
+ * @param     string        IP address
+ *
+ * @return    rdapRecord    network details
+ * @return    string        URL of the network object
+ * @return    error         error message, if any
+ */
+func (c *rdapClient) query(ctx context.Context, ip string) (rdapRecord,
+  string, error) {
+
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return rdapRecord{}, "", fmt.Errorf("rdapClient.query() --> " +
+          "'%s' is not an IP address", ip)
+    }
+
+    server := c.obtainServer(parsed)
+    if len(server) < 1 {
+        return rdapRecord{}, "", fmt.Errorf("rdapClient.query() --> no " +
+          "RDAP server is known for %s", ip)
+    }
+    url := server + "ip/" + parsed.String()
+
+    request, err := http.NewRequest("GET", url, nil)
+    if err != nil {
+        return rdapRecord{}, url, err
+    }
+    request = request.WithContext(ctx)
+    request.Header.Set("Accept", "application/rdap+json")
+
+    response, err := c.http.Do(request)
+    if err != nil {
+        return rdapRecord{}, url, fmt.Errorf("rdapClient.query() --> %s",
+          err.Error())
+    }
+    defer response.Body.Close()
+
+    if response.StatusCode != http.StatusOK {
+        return rdapRecord{}, url, fmt.Errorf("rdapClient.query() --> %s: " +
+          "%s", url, response.Status)
+    }
+
+    // a misbehaving server is cut off, rather than read without end
+    body, err := ioutil.ReadAll(io.LimitReader(response.Body,
+      rdapMaxResponse))
+    if err != nil {
+        return rdapRecord{}, url, err
+    }
+
+    record, err := parseRdapNetwork(body)
+    if err != nil {
+        return record, url, fmt.Errorf("rdapClient.query() --> %s: %s",
+          url, err.Error())
+    }
+
+    return record, url, nil
+}
+
+//! Convert an RDAP record into the network details of a whois record
+/*
+ * @param     rdapRecord     network details of the RDAP network object
+ *
+ * @return    whoisRecord    network details
+ */
+func convertRdapRecordToWhoisRecord(record rdapRecord) whoisRecord {
+
+    // the handle names the network, if it was given no name
+    netname := record.name
+    if len(netname) < 1 {
+        netname = record.handle
+    }
+
+    return whoisRecord{country: record.country, asn: record.asn,
+      netname: netname, org: record.org, cidr: record.cidr,
+      abuse_email: record.abuse_email}
+}
+
+//! Convert the network details of an RDAP record into the text listed in
+//! the whois log; this is only ever read by people, never parsed
+/*
+ * @param     whoisRecord    network details
+ * @param     string         URL of the network object
+ *
+ * @return    string         network details, one per line
+ */
+func convertRdapRecordToString(record whoisRecord, url string) string {
+
+    // variable declaration
+    var text string = ""
+
+    fields := []struct {
+        key   string
+        value string
+    }{
+        {"netname", record.netname},
+        {"org-name", record.org},
+        {"cidr", record.cidr},
+        {"country", record.country},
+        {"origin", record.asn},
+        {"abuse-mailbox", record.abuse_email},
+    }
+
+    text += "% RDAP " + url + "\n"
+    for _, field := range fields {
+        if len(field.value) > 0 {
+            text += fmt.Sprintf("%-16s%s\n", field.key + ":", field.value)
+        }
+    }
+
+    return text
+}
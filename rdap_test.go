@@ -0,0 +1,254 @@
+//
+// RDAP client tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// Network object with the cidr0 extension and a nested abuse contact.
+const rdapTestNetwork = `{
+  "objectClassName": "ip network",
+  "handle": "NET-192-0-2-0-1",
+  "name": "EXAMPLE-NET",
+  "country": "us",
+  "startAddress": "192.0.2.0",
+  "endAddress": "192.0.2.255",
+  "cidr0_cidrs": [{"v4prefix": "192.0.2.0", "length": 24}],
+  "arin_originas0_originautnums": [64500],
+  "entities": [{
+    "handle": "EXAMPLE-ORG",
+    "roles": ["registrant"],
+    "vcardArray": ["vcard", [["version", {}, "text", "4.0"],
+      ["fn", {}, "text", "Example Networks"]]],
+    "entities": [{
+      "handle": "ABUSE-ARIN",
+      "roles": ["abuse"],
+      "vcardArray": ["vcard", [["fn", {}, "text", "Abuse"],
+        ["email", {}, "text", "abuse@example.net"]]]
+    }]
+  }]
+}`
+
+// Network object with a range, yet no cidr0 extension nor name.
+const rdapTestRange = `{
+  "objectClassName": "ip network",
+  "handle": "EXAMPLE-RANGE",
+  "startAddress": "198.51.100.0",
+  "endAddress": "198.51.100.255"
+}`
+
+func TestReadRdapBootstrap(t *testing.T) {
+
+    path := filepath.Join(t.TempDir(), "ipv4.json")
+    contents := `{"services": [
+      [["192.0.0.0/8"], ["http://wide.example/rdap",
+        "https://wide.example/rdap"]],
+      [["192.0.2.0/24"], ["https://narrow.example/rdap/"]],
+      [["198.51.100.0/24"], []]
+    ]}`
+    err := ioutil.WriteFile(path, []byte(contents), 0644)
+    if err != nil {
+        t.Fatalf("unable to write the bootstrap file: %v", err)
+    }
+
+    services, err := readRdapBootstrap(path + ", ")
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    client := newRdapClient(services, "https://fallback.example",
+      time.Second)
+
+    tests := []struct {
+        ip   string
+        want string
+    }{
+        {"192.0.2.1", "https://narrow.example/rdap/"},
+        {"192.0.3.1", "https://wide.example/rdap/"},
+        {"198.51.100.1", "https://fallback.example/"},
+        {"2001:db8::1", "https://fallback.example/"},
+    }
+
+    for _, test := range tests {
+        got := client.obtainServer(net.ParseIP(test.ip))
+        if got != test.want {
+            t.Errorf("obtainServer(%s) = %q, want %q", test.ip, got,
+              test.want)
+        }
+    }
+
+    // an improper file is refused, rather than half read
+    err = ioutil.WriteFile(path, []byte(`{"services": [[["nope"], ` +
+      `["https://x.example/"]]]}`), 0644)
+    if err != nil {
+        t.Fatalf("unable to write the bootstrap file: %v", err)
+    }
+    _, err = readRdapBootstrap(path)
+    if err == nil {
+        t.Errorf("expected an improper prefix to be refused")
+    }
+}
+
+func TestRdapClientQuery(t *testing.T) {
+
+    server := httptest.NewServer(http.HandlerFunc(func(
+      w http.ResponseWriter, r *http.Request) {
+
+        if r.Header.Get("Accept") != "application/rdap+json" {
+            http.Error(w, "bad accept header", http.StatusBadRequest)
+            return
+        }
+
+        switch r.URL.Path {
+        case "/ip/192.0.2.1":
+            w.Write([]byte(rdapTestNetwork))
+        case "/ip/198.51.100.1":
+            w.Write([]byte(rdapTestRange))
+        case "/ip/203.0.113.1":
+            w.Write([]byte(`{"objectClassName": "autnum"}`))
+        case "/ip/203.0.113.2":
+            w.Write([]byte(`{"objectClassName": `))
+        case "/ip/203.0.113.3":
+            time.Sleep(time.Second)
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+
+    tests := []struct {
+        name   string
+        ip     string
+        record rdapRecord
+        fails  bool
+    }{
+        {
+            name:   "network object",
+            ip:     "192.0.2.1",
+            record: rdapRecord{country: "US", name: "EXAMPLE-NET",
+              handle: "NET-192-0-2-0-1", cidr: "192.0.2.0/24",
+              asn: "AS64500", org: "Example Networks",
+              abuse_email: "abuse@example.net"},
+        },
+        {
+            name:   "range without cidr0",
+            ip:     "198.51.100.1",
+            record: rdapRecord{handle: "EXAMPLE-RANGE",
+              cidr: "198.51.100.0/24"},
+        },
+        {name: "not a network object", ip: "203.0.113.1", fails: true},
+        {name: "truncated answer", ip: "203.0.113.2", fails: true},
+        {name: "slow server", ip: "203.0.113.3", fails: true},
+        {name: "not found", ip: "203.0.113.4", fails: true},
+        {name: "not an address", ip: "203.0.113", fails: true},
+    }
+
+    client := newRdapClient(nil, server.URL, 200 * time.Millisecond)
+
+    for _, test := range tests {
+
+        record, url, err := client.query(context.Background(), test.ip)
+        if test.fails {
+            if err == nil {
+                t.Errorf("%s: expected an error", test.name)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%s: unexpected error: %v", test.name, err)
+            continue
+        }
+
+        if url != server.URL + "/ip/" + test.ip {
+            t.Errorf("%s: url = %q", test.name, url)
+        }
+        if record != test.record {
+            t.Errorf("%s: record = %+v, want %+v", test.name, record,
+              test.record)
+        }
+    }
+}
+
+func TestConvertRdapRecordToWhoisRecord(t *testing.T) {
+
+    tests := []struct {
+        record rdapRecord
+        want   whoisRecord
+    }{
+        {
+            rdapRecord{country: "US", name: "EXAMPLE-NET",
+              handle: "NET-1", cidr: "192.0.2.0/24", asn: "AS64500",
+              org: "Example Networks", abuse_email: "abuse@example.net"},
+            whoisRecord{country: "US", netname: "EXAMPLE-NET",
+              cidr: "192.0.2.0/24", asn: "AS64500",
+              org: "Example Networks", abuse_email: "abuse@example.net"},
+        },
+        {
+            rdapRecord{handle: "NET-2", cidr: "198.51.100.0/24"},
+            whoisRecord{netname: "NET-2", cidr: "198.51.100.0/24"},
+        },
+    }
+
+    for _, test := range tests {
+        got := convertRdapRecordToWhoisRecord(test.record)
+        if got != test.want {
+            t.Errorf("convertRdapRecordToWhoisRecord(%+v) = %+v, want %+v",
+              test.record, got, test.want)
+        }
+    }
+}
+
+func TestWhoisCacheRdap(t *testing.T) {
+
+    path := filepath.Join(t.TempDir(), "whois.cache")
+    now := time.Now()
+
+    cache, err := readWhoisCache(path, 24 * time.Hour, time.Hour)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    // the record is cached as is, under its allocation
+    result := whoisResult{text: "https://rdap.example/ip/192.0.2.1",
+      rdap: true, record: whoisRecord{country: "US", asn: "AS64500",
+      netname: "EXAMPLE-NET", org: "Example Networks",
+      cidr: "192.0.2.0/24", abuse_email: "abuse@example.net"}}
+    cache.store("192.0.2.1", result, false, now)
+
+    err = cache.write()
+    if err != nil {
+        t.Fatalf("unable to write the cache: %v", err)
+    }
+
+    cache, err = readWhoisCache(path, 24 * time.Hour, time.Hour)
+    if err != nil {
+        t.Fatalf("unable to read the cache back: %v", err)
+    }
+
+    entry := cache.lookup("192.0.2.77", now)
+    if entry == nil {
+        t.Fatalf("no cached record for an address of the allocation")
+    }
+    if entry.failed || entry.result != result {
+        t.Errorf("cached result = %+v, want %+v", entry.result, result)
+    }
+    if cache.lookup("192.0.3.1", now) != nil {
+        t.Errorf("cached record served outside of its allocation")
+    }
+}
@@ -0,0 +1,269 @@
+//
+// Visitor session functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// File extensions of requests that are assets rather than pages.
+var assetExtensions = []string{".css", ".js", ".png", ".jpg", ".jpeg",
+  ".gif", ".svg", ".ico", ".woff", ".woff2", ".ttf", ".eot", ".map",
+  ".webp", ".mp4", ".webm", ".txt", ".xml"}
+
+//! A visit, rebuilt from the requests of one IP and user agent
+type visitorSession struct {
+    ip         string
+    user_agent string
+    start      time.Time
+    end        time.Time
+    pages      int
+    entry_page string
+    exit_page  string
+}
+
+//! Obtain the length of time the session lasted
+/*
+ * @return    Duration    time between the first and last request
+ */
+func (s visitorSession) duration() time.Duration {
+    return s.end.Sub(s.start)
+}
+
+//! Whether or not the visitor left after viewing a single page
+/*
+ * @return    bool    whether or not this is a bounce
+ */
+func (s visitorSession) isBounce() bool {
+    return s.pages <= 1
+}
+
+//! Determine if a request path refers to a page, rather than an asset
+/*
+ * @param     string    request path
+ *
+ * @return    bool      whether or not this is a page
+ */
+func isPageRequest(request_path string) bool {
+
+    // input validation
+    if len(request_path) < 1 {
+        return false
+    }
+
+    // strip away the query string, if any
+    if i := strings.Index(request_path, "?"); i >= 0 {
+        request_path = request_path[:i]
+    }
+
+    // check the extension against the list of known asset types
+    extension := strings.ToLower(path.Ext(request_path))
+    return !isStringInArray(extension, assetExtensions)
+}
+
+//! Rebuild visitor sessions from a list of parsed log entries
+/*
+ * @param     logEntry[]          array of parsed log entries
+ * @param     Duration            inactivity gap that ends a session
+ *
+ * @return    visitorSession[]    array of sessions, ordered by start time
+ */
+func buildSessions(entries []logEntry,
+  gap time.Duration) []visitorSession {
+
+    // variable declaration
+    var sessions = make([]visitorSession, 0)
+    var open_sessions = make(map[string] int)
+
+    // the log is nearly always in order already, but a stable sort keeps
+    // requests that share the same second in their original order
+    sorted := make([]logEntry, len(entries))
+    copy(sorted, entries)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        return sorted[i].timestamp.Before(sorted[j].timestamp)
+    })
+
+    // for every entry...
+    for _, entry := range sorted {
+
+        // only pages count towards a session, since assets are loaded
+        // automatically by the browser
+        if !isPageRequest(entry.path) {
+            continue
+        }
+
+        // sessions are keyed by the IP address and user agent, so that
+        // several visitors behind a single NAT can be told apart
+        key := entry.ip + "|" + entry.user_agent
+
+        // check if there is a currently open session for this visitor
+        index, exists := open_sessions[key]
+
+        // if the visitor was inactive for longer than the gap, or this is
+        // their first request, then start a new session
+        if !exists || entry.timestamp.Sub(sessions[index].end) > gap {
+            sessions = append(sessions, visitorSession{
+                ip:         entry.ip,
+                user_agent: entry.user_agent,
+                start:      entry.timestamp,
+                end:        entry.timestamp,
+                pages:      1,
+                entry_page: entry.path,
+                exit_page:  entry.path,
+            })
+            open_sessions[key] = len(sessions)-1
+            continue
+        }
+
+        // otherwise extend the currently open session
+        sessions[index].end = entry.timestamp
+        sessions[index].pages++
+        sessions[index].exit_page = entry.path
+    }
+
+    // pass back the completed sessions
+    return sessions
+}
+
+//! Convert a list of sessions into the contents of the sessions log
+/*
+ * @param     visitorSession[]    array of sessions
+ * @param     Duration            sessions lasting longer are listed
+ *
+ * @return    string              session report
+ */
+func convertSessionsToString(sessions []visitorSession,
+  long_session time.Duration) string {
+
+    // variable declaration
+    var session_strings string = ""
+    var entry_pages = make(map[string] int)
+    var exit_pages = make(map[string] int)
+    var bounces int = 0
+    var total time.Duration = 0
+    var long_sessions = make([]visitorSession, 0)
+
+    // if no sessions present, append a line about there being no data
+    if len(sessions) < 1 {
+        return "No sessions listed at this time."
+    }
+
+    // buckets of the session duration distribution
+    bucket_labels := []string{"< 10s", "10s - 1m", "1m - 5m", "5m - 15m",
+      "15m - 30m", "30m - 1h", "> 1h"}
+    bucket_limits := []time.Duration{10 * time.Second, time.Minute,
+      5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour}
+    bucket_counts := make([]int, len(bucket_labels))
+
+    // gather the statistics of every session
+    for _, s := range sessions {
+
+        duration := s.duration()
+        total += duration
+
+        if s.isBounce() {
+            bounces++
+        }
+
+        entry_pages[s.entry_page]++
+        exit_pages[s.exit_page]++
+
+        // place the session into the first bucket it fits
+        bucket := len(bucket_limits)
+        for i, limit := range bucket_limits {
+            if duration < limit {
+                bucket = i
+                break
+            }
+        }
+        bucket_counts[bucket]++
+
+        if duration >= long_session {
+            long_sessions = append(long_sessions, s)
+        }
+    }
+
+    // summary section
+    session_strings += "Sessions:        " + strconv.Itoa(len(sessions)) +
+      "\n"
+    session_strings += "Bounce rate:     " +
+      fmt.Sprintf("%.1f%%", 100 * float64(bounces) / float64(len(sessions))) +
+      "\n"
+    session_strings += "Avg duration:    " +
+      (total / time.Duration(len(sessions))).Round(time.Second).String() +
+      "\n\n"
+
+    // duration distribution, drawn as an ASCII bar chart
+    max_bucket := 0
+    for _, count := range bucket_counts {
+        if count > max_bucket {
+            max_bucket = count
+        }
+    }
+    session_strings += "Duration Distribution\n\n"
+    for i, label := range bucket_labels {
+        session_strings += fmt.Sprintf("%-10s | %6d | %s\n", label,
+          bucket_counts[i], asciiBar(bucket_counts[i], max_bucket, 50))
+    }
+
+    // top entry and exit pages
+    session_strings += "\nTop Entry Pages\n\n"
+    session_strings += convertTopPagesToString(entry_pages, 10)
+    session_strings += "\nTop Exit Pages\n\n"
+    session_strings += convertTopPagesToString(exit_pages, 10)
+
+    // unusually long sessions, longest first
+    session_strings += "\nLong Sessions (" + long_session.String() +
+      " or more)\n\n"
+    if len(long_sessions) < 1 {
+        session_strings += "None.\n"
+    }
+    sort.SliceStable(long_sessions, func(i, j int) bool {
+        return long_sessions[i].duration() > long_sessions[j].duration()
+    })
+    for _, s := range long_sessions {
+        session_strings += fmt.Sprintf("%-10s | %5d | %-39s | %s\n",
+          s.duration().Round(time.Second).String(), s.pages, s.ip,
+          s.user_agent)
+    }
+
+    // everything worked fine, so return the completed string contents
+    return session_strings
+}
+
+//! Convert a map of page counts into the top N lines of the report
+/*
+ * @param     map       string map containing pages and counts
+ * @param     int       maximum number of lines
+ *
+ * @return    string    lines that contain "count | page \n"
+ */
+func convertTopPagesToString(pages map[string] int, limit int) string {
+
+    // variable declaration
+    var page_strings string = ""
+
+    // append the most common pages, up until the limit
+    for i, pair := range sortMapByCount(pages) {
+        if i >= limit {
+            break
+        }
+        page_strings += fmt.Sprintf("%6d | %s\n", pair.count, pair.name)
+    }
+
+    // pass back the lines
+    return page_strings
+}
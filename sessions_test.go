@@ -0,0 +1,129 @@
+//
+// Visitor session tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "testing"
+    "time"
+)
+
+func TestIsPageRequest(t *testing.T) {
+
+    tests := []struct {
+        path string
+        want bool
+    }{
+        {"", false},
+        {"/", true},
+        {"/about", true},
+        {"/blog/post.html", true},
+        {"/index.php?page=2", true},
+        {"/style.css", false},
+        {"/IMG/LOGO.PNG", false},
+        {"/app.js?v=2", false},
+        {"/search?file=style.css", true},
+        {"/robots.txt", false},
+        {"/release.v2/", true},
+        {"/archive.tar.gz", true},
+    }
+
+    for _, test := range tests {
+        got := isPageRequest(test.path)
+        if got != test.want {
+            t.Errorf("isPageRequest(%q) = %v, want %v", test.path, got,
+              test.want)
+        }
+    }
+}
+
+func TestBuildSessions(t *testing.T) {
+
+    start := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+    gap := 30 * time.Minute
+
+    // a request for a path, made some minutes after the start
+    request := func(ip string, agent string, minutes int,
+      path string) logEntry {
+        return logEntry{ip: ip, user_agent: agent, path: path,
+          timestamp: start.Add(time.Duration(minutes) * time.Minute)}
+    }
+
+    tests := []struct {
+        name    string
+        entries []logEntry
+        want    []visitorSession
+    }{
+        {"no entries", nil, []visitorSession{}},
+        {
+            "assets alone make no session",
+            []logEntry{request("192.0.2.1", "a", 0, "/style.css"),
+              request("192.0.2.1", "a", 1, "/favicon.ico")},
+            []visitorSession{},
+        },
+        {
+            "single page is a bounce",
+            []logEntry{request("192.0.2.1", "a", 0, "/"),
+              request("192.0.2.1", "a", 0, "/style.css")},
+            []visitorSession{{"192.0.2.1", "a", start, start, 1, "/", "/"}},
+        },
+        {
+            "a pause of the gap continues, a longer one splits",
+            []logEntry{request("192.0.2.1", "a", 0, "/"),
+              request("192.0.2.1", "a", 30, "/about"),
+              request("192.0.2.1", "a", 61, "/contact")},
+            []visitorSession{
+                {"192.0.2.1", "a", start, start.Add(30 * time.Minute), 2,
+                  "/", "/about"},
+                {"192.0.2.1", "a", start.Add(61 * time.Minute),
+                  start.Add(61 * time.Minute), 1, "/contact", "/contact"},
+            },
+        },
+        {
+            "visitors behind one address told apart, out of order",
+            []logEntry{request("192.0.2.1", "b", 5, "/b2"),
+              request("192.0.2.1", "a", 2, "/a1"),
+              request("192.0.2.1", "b", 1, "/b1"),
+              request("198.51.100.1", "a", 3, "/c1")},
+            []visitorSession{
+                {"192.0.2.1", "b", start.Add(time.Minute),
+                  start.Add(5 * time.Minute), 2, "/b1", "/b2"},
+                {"192.0.2.1", "a", start.Add(2 * time.Minute),
+                  start.Add(2 * time.Minute), 1, "/a1", "/a1"},
+                {"198.51.100.1", "a", start.Add(3 * time.Minute),
+                  start.Add(3 * time.Minute), 1, "/c1", "/c1"},
+            },
+        },
+    }
+
+    for _, test := range tests {
+
+        sessions := buildSessions(test.entries, gap)
+        if len(sessions) != len(test.want) {
+            t.Errorf("%s: %d sessions, want %d: %+v", test.name,
+              len(sessions), len(test.want), sessions)
+            continue
+        }
+        for i := range sessions {
+            if sessions[i] != test.want[i] {
+                t.Errorf("%s: session %d = %+v, want %+v", test.name, i,
+                  sessions[i], test.want[i])
+            }
+        }
+    }
+
+    // the durations and bounces follow from the first and last page
+    sessions := buildSessions(tests[3].entries, gap)
+    if sessions[0].duration() != 30 * time.Minute ||
+      sessions[0].isBounce() || sessions[1].duration() != 0 ||
+      !sessions[1].isBounce() {
+        t.Errorf("durations and bounces of %+v", sessions)
+    }
+}
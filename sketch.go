@@ -0,0 +1,205 @@
+//
+// Probabilistic sketch functions for ASCII-log
+//
+// These keep the memory used by the IP address counts fixed, regardless of
+// how many different addresses appear in the logs; e.g. during a DDoS.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "hash/fnv"
+    "math"
+    "math/bits"
+)
+
+// Number of bits of the hash used to select a HyperLogLog register; 2^14
+// registers gives a standard error of roughly 0.8%
+const hyperLogLogPrecision = 14
+
+//! HyperLogLog cardinality estimator
+type hyperLogLog struct {
+    registers []uint8
+}
+
+//! Space-saving counter, used to track one of the heaviest hitters
+type spaceSavingCounter struct {
+    count int
+    error int
+}
+
+//! Space-saving top-K heavy hitter tracker
+type spaceSaving struct {
+    capacity int
+    counters map[string] *spaceSavingCounter
+}
+
+//! Hash a string into a well mixed 64-bit value
+/*
+ * @param     string    value to hash
+ *
+ * @return    uint64    hash of the value
+ */
+func hashString64(value string) uint64 {
+
+    // FNV-1a is quick, but the high bits are poorly mixed for short and
+    // similar keys such as IP addresses
+    h := fnv.New64a()
+    h.Write([]byte(value))
+    x := h.Sum64()
+
+    // so run it thru the splitmix64 finalizer as well
+    x ^= x >> 30
+    x *= 0xbf58476d1ce4e5b9
+    x ^= x >> 27
+    x *= 0x94d049bb133111eb
+    x ^= x >> 31
+
+    return x
+}
+
+//! Create a new HyperLogLog estimator
+/*
+ * @return    hyperLogLog*    empty estimator
+ */
+func newHyperLogLog() *hyperLogLog {
+    return &hyperLogLog{make([]uint8, 1 << hyperLogLogPrecision)}
+}
+
+//! Add a value to the HyperLogLog estimator
+/*
+ * @param     string    value to add
+ */
+func (h *hyperLogLog) add(value string) {
+
+    x := hashString64(value)
+
+    // the top bits select the register
+    index := x >> (64 - hyperLogLogPrecision)
+
+    // the rank is the position of the first set bit in the remainder
+    rank := uint8(bits.LeadingZeros64(x << hyperLogLogPrecision)) + 1
+    if rank > 64 - hyperLogLogPrecision + 1 {
+        rank = 64 - hyperLogLogPrecision + 1
+    }
+
+    // keep the largest rank seen by the register
+    if rank > h.registers[index] {
+        h.registers[index] = rank
+    }
+}
+
+//! Estimate the number of unique values added
+/*
+ * @return    uint64    estimated cardinality
+ */
+func (h *hyperLogLog) estimate() uint64 {
+
+    // variable declaration
+    var sum float64 = 0
+    var zeros int = 0
+
+    m := float64(len(h.registers))
+
+    // harmonic mean of the registers
+    for _, r := range h.registers {
+        sum += 1 / math.Pow(2, float64(r))
+        if r == 0 {
+            zeros++
+        }
+    }
+    alpha := 0.7213 / (1 + 1.079 / m)
+    estimate := alpha * m * m / sum
+
+    // for small cardinalities, linear counting is far more accurate
+    if estimate <= 2.5 * m && zeros > 0 {
+        estimate = m * math.Log(m / float64(zeros))
+    }
+
+    return uint64(estimate + 0.5)
+}
+
+//! Create a new space-saving top-K tracker
+/*
+ * @param     int             number of counters to keep
+ *
+ * @return    spaceSaving*    empty tracker
+ */
+func newSpaceSaving(capacity int) *spaceSaving {
+
+    // input validation, always keep at least one counter
+    if capacity < 1 {
+        capacity = 1
+    }
+
+    return &spaceSaving{capacity,
+      make(map[string] *spaceSavingCounter, capacity)}
+}
+
+//! Add a value to the space-saving tracker
+/*
+ * @param     string    value to add
+ */
+func (s *spaceSaving) add(value string) {
+
+    // if the value is already tracked, simply increment it
+    if c, exists := s.counters[value]; exists {
+        c.count++
+        return
+    }
+
+    // if there is still room, start tracking the value
+    if len(s.counters) < s.capacity {
+        s.counters[value] = &spaceSavingCounter{1, 0}
+        return
+    }
+
+    // otherwise the value replaces the smallest counter, inheriting its
+    // count as the maximum possible overestimation
+    min_value := ""
+    var min_counter *spaceSavingCounter = nil
+    for v, c := range s.counters {
+        if min_counter == nil || c.count < min_counter.count ||
+          (c.count == min_counter.count && v < min_value) {
+            min_value = v
+            min_counter = c
+        }
+    }
+    delete(s.counters, min_value)
+    s.counters[value] = &spaceSavingCounter{min_counter.count + 1,
+      min_counter.count}
+}
+
+//! Obtain the counts of the tracked heavy hitters
+/*
+ * @return    map    string map containing values and estimated counts
+ */
+func (s *spaceSaving) counts() map[string] int {
+
+    result := make(map[string] int, len(s.counters))
+    for v, c := range s.counters {
+        result[v] = c.count
+    }
+    return result
+}
+
+//! Obtain the largest overestimation of any of the tracked counts
+/*
+ * @return    int    maximum error
+ */
+func (s *spaceSaving) maxError() int {
+
+    max_error := 0
+    for _, c := range s.counters {
+        if c.error > max_error {
+            max_error = c.error
+        }
+    }
+    return max_error
+}
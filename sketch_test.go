@@ -0,0 +1,165 @@
+//
+// Probabilistic sketch tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "math"
+    "math/rand"
+    "sort"
+    "testing"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+
+    // 2^14 registers have a standard error of about 0.81%, so an estimate
+    // more than 3 standard errors off is a fault, not bad luck; small
+    // counts go by linear counting, which is closer still
+    tests := []struct {
+        unique  int
+        allowed float64
+    }{
+        {0, 0},
+        {1, 0},
+        {100, 0.02},
+        {5000, 0.025},
+        {50000, 0.025},
+        {500000, 0.025},
+    }
+
+    for _, test := range tests {
+
+        hll := newHyperLogLog()
+        for i := 0; i < test.unique; i++ {
+            ip := fmt.Sprintf("10.%d.%d.%d", i >> 16 & 0xff, i >> 8 & 0xff,
+              i & 0xff)
+
+            // repeats of an address must not count twice
+            hll.add(ip)
+            hll.add(ip)
+        }
+
+        estimate := float64(hll.estimate())
+        off := math.Abs(estimate - float64(test.unique))
+        if off > test.allowed * float64(test.unique) {
+            t.Errorf("estimate of %d unique addresses = %.0f, %.2f%% off, " +
+              "allowed %.1f%%", test.unique, estimate,
+              100 * off / math.Max(1, float64(test.unique)),
+              100 * test.allowed)
+        }
+    }
+}
+
+func TestSpaceSavingSkewed(t *testing.T) {
+
+    // a handful of busy addresses among a flood of one-off ones
+    heavy := []struct {
+        ip    string
+        count int
+    }{
+        {"192.0.2.1", 8000},
+        {"192.0.2.2", 4000},
+        {"192.0.2.3", 3000},
+        {"192.0.2.4", 2500},
+        {"192.0.2.5", 2000},
+    }
+    events := make([]string, 0)
+    for _, hitter := range heavy {
+        for i := 0; i < hitter.count; i++ {
+            events = append(events, hitter.ip)
+        }
+    }
+    for i := 0; i < 10000; i++ {
+        events = append(events, fmt.Sprintf("10.0.%d.%d", i >> 8, i & 0xff))
+    }
+
+    // mixed in at random, and, the worst case, all after the flood
+    shuffled := append([]string{}, events...)
+    rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) {
+        shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+    })
+    last := append([]string{}, events[len(events)-10000:]...)
+    last = append(last, events[:len(events)-10000]...)
+
+    capacity := 20
+    for name, order := range map[string] []string{"shuffled": shuffled,
+      "heavy last": last} {
+
+        tracker := newSpaceSaving(capacity)
+        for _, ip := range order {
+            tracker.add(ip)
+        }
+        counts := tracker.counts()
+
+        // counts are never under, and over by no more than N/k
+        bound := len(order) / capacity
+        if tracker.maxError() > bound {
+            t.Errorf("%s: max error %d, over the bound of %d", name,
+              tracker.maxError(), bound)
+        }
+        for _, hitter := range heavy {
+            estimate, tracked := counts[hitter.ip]
+            if !tracked {
+                t.Errorf("%s: %s with %d hits not tracked", name, hitter.ip,
+                  hitter.count)
+                continue
+            }
+            if estimate < hitter.count ||
+              estimate - hitter.count > tracker.maxError() {
+                t.Errorf("%s: %s counted %d, want %d to %d", name,
+                  hitter.ip, estimate, hitter.count,
+                  hitter.count + tracker.maxError())
+            }
+        }
+
+        // the busiest addresses come out on top, in order
+        ranked := make([]string, 0, len(counts))
+        for ip, _ := range counts {
+            ranked = append(ranked, ip)
+        }
+        sort.Slice(ranked, func(i, j int) bool {
+            return counts[ranked[i]] > counts[ranked[j]]
+        })
+        for i, hitter := range heavy {
+            if ranked[i] != hitter.ip {
+                t.Errorf("%s: rank %d = %s, want %s", name, i+1, ranked[i],
+                  hitter.ip)
+            }
+        }
+    }
+}
+
+func TestSpaceSavingExact(t *testing.T) {
+
+    // with room for every value, the counts are exact
+    tracker := newSpaceSaving(3)
+    for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.1",
+      "192.0.2.3", "192.0.2.1"} {
+        tracker.add(ip)
+    }
+    counts := tracker.counts()
+    if counts["192.0.2.1"] != 3 || counts["192.0.2.2"] != 1 ||
+      counts["192.0.2.3"] != 1 || tracker.maxError() != 0 {
+        t.Errorf("counts = %v, max error %d", counts, tracker.maxError())
+    }
+
+    // an empty tracker, or one without room, still keeps a single value
+    tracker = newSpaceSaving(0)
+    if len(tracker.counts()) != 0 || tracker.maxError() != 0 {
+        t.Errorf("empty tracker counts %v", tracker.counts())
+    }
+    tracker.add("192.0.2.1")
+    tracker.add("192.0.2.2")
+    if counts := tracker.counts(); len(counts) != 1 ||
+      counts["192.0.2.2"] != 2 {
+        t.Errorf("single counter = %v, want 192.0.2.2 at 2", counts)
+    }
+}
@@ -0,0 +1,195 @@
+//
+// Per IP address statistics functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "strings"
+)
+
+// Attack signatures, as found in the request line of probes and exploit
+// attempts, along with the name used to refer to them in the policy.
+var attackSignatures = []struct {
+    name    string
+    pattern string
+}{
+    {"wp-login", "/wp-login.php"},
+    {"xmlrpc", "/xmlrpc.php"},
+    {"dotenv", "/.env"},
+    {"git", "/.git/"},
+    {"phpmyadmin", "phpmyadmin"},
+    {"cgi-bin", "/cgi-bin/"},
+    {"passwd", "/etc/passwd"},
+    {"traversal", "../"},
+    {"shell", "/bin/sh"},
+    {"sql-injection", "union select"},
+    {"script-injection", "<script"},
+}
+
+// User agent classes, as used by the policy.
+const (
+    uaEmpty             = "empty"
+    uaTool              = "tool"
+    uaScanner           = "scanner"
+    uaBot               = "bot"
+    uaBrowser           = "browser"
+    uaOther             = "other"
+    uaVerifiedCrawler   = "verified-crawler"
+    uaFakeCrawler       = "fake-crawler"
+    uaUnverifiedCrawler = "unverified-crawler"
+)
+
+// Tokens of scripting tools and libraries, as found in their user agents.
+var toolUserAgents = []string{"curl", "wget", "python", "go-http-client",
+  "libwww", "java/", "okhttp", "httpclient", "aiohttp", "node-fetch"}
+
+// Tokens of vulnerability and port scanners, as found in their user agents.
+var scannerUserAgents = []string{"nikto", "sqlmap", "masscan", "zgrab",
+  "nmap", "nuclei", "wpscan", "dirbuster", "gobuster", "censys"}
+
+//! Statistics gathered from the parsed log entries of one IP address
+type ipStats struct {
+    requests    int
+    errors      int
+    status4xx   int
+    status5xx   int
+    redirects   int
+    malformed   int
+    peak_rate   int
+    user_agents map[string] int
+    ua_classes  map[string] int
+    signatures  map[string] int
+}
+
+//! Classify a user agent; e.g. as a browser or a scripting tool
+/*
+ * @param     string    user agent
+ *
+ * @return    string    user agent class
+ */
+func classifyUserAgent(user_agent string) string {
+
+    lowered := strings.ToLower(strings.TrimSpace(user_agent))
+
+    if len(lowered) < 1 || lowered == "-" {
+        return uaEmpty
+    }
+    for _, token := range scannerUserAgents {
+        if strings.Contains(lowered, token) {
+            return uaScanner
+        }
+    }
+    for _, token := range toolUserAgents {
+        if strings.Contains(lowered, token) {
+            return uaTool
+        }
+    }
+    if strings.Contains(lowered, "bot") ||
+      strings.Contains(lowered, "crawl") ||
+      strings.Contains(lowered, "spider") {
+        return uaBot
+    }
+    if strings.HasPrefix(lowered, "mozilla/") ||
+      strings.HasPrefix(lowered, "opera/") {
+        return uaBrowser
+    }
+    return uaOther
+}
+
+//! Obtain the names of the attack signatures found in a request line
+/*
+ * @param     string      request line
+ *
+ * @return    string[]    names of the matching signatures
+ */
+func obtainAttackSignatures(request string) []string {
+
+    // variable declaration
+    var result = make([]string, 0)
+
+    // probes often use upper case or url encoding to dodge simple filters
+    lowered := strings.ToLower(request)
+    lowered = strings.Replace(lowered, "%2e", ".", -1)
+    lowered = strings.Replace(lowered, "%2f", "/", -1)
+    lowered = strings.Replace(lowered, "%20", " ", -1)
+    lowered = strings.Replace(lowered, "+", " ", -1)
+
+    for _, signature := range attackSignatures {
+        if strings.Contains(lowered, signature.pattern) {
+            result = append(result, signature.name)
+        }
+    }
+
+    return result
+}
+
+//! Gather the statistics of every IP address from the parsed entries
+/*
+ * @param     logEntry[]    array of parsed log entries
+ *
+ * @return    map           map of ip addresses and their statistics
+ */
+func aggregateIpStats(entries []logEntry) map[string] *ipStats {
+
+    // variable declaration
+    var stats_map = make(map[string] *ipStats)
+    var per_minute = make(map[string] int)
+
+    // for every entry...
+    for _, entry := range entries {
+
+        stats, exists := stats_map[entry.ip]
+        if !exists {
+            stats = &ipStats{user_agents: make(map[string] int),
+              ua_classes: make(map[string] int),
+              signatures: make(map[string] int)}
+            stats_map[entry.ip] = stats
+        }
+
+        stats.requests++
+        stats.user_agents[entry.user_agent]++
+        stats.ua_classes[classifyUserAgent(entry.user_agent)]++
+
+        // 4xx and 5xx responses count as errors
+        if entry.status >= 400 {
+            stats.errors++
+        }
+        if entry.status >= 400 && entry.status < 500 {
+            stats.status4xx++
+        }
+        if entry.status >= 500 {
+            stats.status5xx++
+        }
+
+        // 302 found-redirections
+        if entry.status == 302 {
+            stats.redirects++
+        }
+
+        // odd request lines; e.g. CONNECT attempts or TLS handshakes
+        if classifyRequest(entry) != "" {
+            stats.malformed++
+        }
+
+        for _, name := range obtainAttackSignatures(entry.request) {
+            stats.signatures[name]++
+        }
+
+        // keep track of the busiest minute of the address
+        minute := entry.ip + " " +
+          entry.timestamp.UTC().Format("2006-01-02 15:04")
+        per_minute[minute]++
+        if per_minute[minute] > stats.peak_rate {
+            stats.peak_rate = per_minute[minute]
+        }
+    }
+
+    return stats_map
+}
@@ -0,0 +1,657 @@
+//
+// Subcommand functions for ASCII-log
+//
+// Besides the automated run, the blocks can be managed by hand:
+//
+// ascii-log block <ip|cidr> --ttl 48h --reason "..."
+// ascii-log unblock <ip|cidr>
+// ascii-log list-blocks [--all]
+// ascii-log explain <ip>
+//
+// and the whois cache inspected and maintained:
+//
+// ascii-log whois-cache [list|show <ip>|prune|warm [ip...]]
+//
+// Every flag of the automated run can be given to a subcommand as well;
+// e.g. --allowlist-file or --policy-file.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "flag"
+    "fmt"
+    "net"
+    "os"
+    "strings"
+    "time"
+)
+
+// Names of the available subcommands.
+var subcommands = []string{"block", "unblock", "list-blocks", "explain",
+  "whois-cache"}
+
+//! Assemble the flag set of a subcommand, which also accepts every flag of
+//! the automated run
+/*
+ * @param     string     subcommand name
+ *
+ * @return    FlagSet    flag set of the subcommand
+ */
+func newSubcommandFlagSet(name string) *flag.FlagSet {
+
+    flags := flag.NewFlagSet("ascii-log " + name, flag.ExitOnError)
+
+    // the global flags share their values, so setting them here sets the
+    // same globals as the automated run uses
+    flag.CommandLine.VisitAll(func(f *flag.Flag) {
+        flags.Var(f.Value, f.Name, f.Usage)
+    })
+
+    return flags
+}
+
+//! Parse the arguments of a subcommand, allowing flags to come before or
+//! after the positional arguments
+/*
+ * @param     FlagSet     flag set of the subcommand
+ * @param     string[]    arguments after the subcommand name
+ *
+ * @return    string[]    positional arguments
+ * @return    error       error message, if any
+ */
+func parseSubcommandArgs(flags *flag.FlagSet, args []string) ([]string,
+  error) {
+
+    // variable declaration
+    var positional = make([]string, 0)
+
+    for {
+        if err := flags.Parse(args); err != nil {
+            return nil, err
+        }
+        if flags.NArg() < 1 {
+            break
+        }
+        positional = append(positional, flags.Arg(0))
+        args = flags.Args()[1:]
+    }
+
+    return positional, nil
+}
+
+//! Check whether an IP address or CIDR is properly formed
+/*
+ * @param     string    IP address or CIDR
+ *
+ * @return    string    normalized target
+ * @return    error     error message, if any
+ */
+func parseBlockTarget(target string) (string, error) {
+
+    if net.ParseIP(target) == nil {
+        if _, _, err := net.ParseCIDR(target); err != nil {
+            return "", fmt.Errorf("'%s' is not an IP address or CIDR",
+              target)
+        }
+    }
+    return normalizeFirewallTarget(target), nil
+}
+
+//! Run a subcommand
+/*
+ * @param     string      subcommand name
+ * @param     string[]    arguments after the subcommand name
+ *
+ * @return    error       error message, if any
+ */
+func runSubcommand(name string, args []string) error {
+
+    // attempt to create the state directory, if it does not yet exist
+    err := os.MkdirAll(state_directory, 0755)
+    if err != nil {
+        return fmt.Errorf("runSubcommand() --> unable to create the " +
+          "following directory: %s", state_directory)
+    }
+
+    switch name {
+    case "block":
+        return runBlockCommand(args)
+    case "unblock":
+        return runUnblockCommand(args)
+    case "list-blocks":
+        return runListBlocksCommand(args)
+    case "explain":
+        return runExplainCommand(args)
+    case "whois-cache":
+        return runWhoisCacheCommand(args)
+    }
+
+    return fmt.Errorf("runSubcommand() --> unknown subcommand: %s", name)
+}
+
+//! Block an address or CIDR by hand
+/*
+ * @param     string[]    arguments; e.g. 192.0.2.1 --ttl 48h --reason spam
+ *
+ * @return    error       error message, if any
+ */
+func runBlockCommand(args []string) error {
+
+    flags := newSubcommandFlagSet("block")
+    ttl := flags.Duration("ttl", blockTTL,
+      "Length of the block; e.g. '48h' ")
+    reason := flags.String("reason", "manual", "Reason for the block.")
+
+    positional, err := parseSubcommandArgs(flags, args)
+    if err != nil {
+        return err
+    }
+    if len(positional) != 1 {
+        return fmt.Errorf("usage: ascii-log block <ip|cidr> --ttl 48h " +
+          "--reason \"...\"")
+    }
+    if *ttl <= 0 {
+        return fmt.Errorf("the block length has to be positive")
+    }
+
+    target, err := parseBlockTarget(positional[0])
+    if err != nil {
+        return err
+    }
+
+    // allowlisted addresses can never be blocked, not even by hand
+    allowed, err := readAllowlistFile(allowlistFile)
+    if err != nil {
+        return err
+    }
+    if allowed.coversTarget(target, nil) {
+        return fmt.Errorf("%s is allowlisted, and cannot be blocked",
+          target)
+    }
+
+    ledger, err := readBlockLedger(state_directory + blocks_ledger)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    block := recordManualBlock(ledger, target,
+      strings.Join(strings.Fields(*reason), " "), *ttl, now)
+
+    err = writeBlockLedger(state_directory + blocks_ledger, ledger)
+    if err != nil {
+        return err
+    }
+
+    fmt.Println("Blocked " + block.target + " until " +
+      block.expires.Format(ledgerTimeLayout) + "; it is applied on the " +
+      "next enforcing run.")
+    return nil
+}
+
+//! Lift the block of an address or CIDR
+/*
+ * @param     string[]    arguments; e.g. 192.0.2.1
+ *
+ * @return    error       error message, if any
+ */
+func runUnblockCommand(args []string) error {
+
+    flags := newSubcommandFlagSet("unblock")
+
+    positional, err := parseSubcommandArgs(flags, args)
+    if err != nil {
+        return err
+    }
+    if len(positional) != 1 {
+        return fmt.Errorf("usage: ascii-log unblock <ip|cidr>")
+    }
+
+    target, err := parseBlockTarget(positional[0])
+    if err != nil {
+        return err
+    }
+
+    ledger, err := readBlockLedger(state_directory + blocks_ledger)
+    if err != nil {
+        return err
+    }
+
+    // the address may only be blocked as part of a wider CIDR, which is
+    // left as is, since it covers other addresses too
+    if _, exists := ledger[target]; !exists {
+        covering := make([]string, 0)
+        for _, block := range obtainActiveBlocks(ledger, time.Now()) {
+            if targetsOverlap(block.target, target) {
+                covering = append(covering, block.target)
+            }
+        }
+        if len(covering) > 0 {
+            return fmt.Errorf("%s is not blocked by itself, but is " +
+              "covered by: %s", target, strings.Join(covering, ", "))
+        }
+        return fmt.Errorf("%s is not blocked", target)
+    }
+
+    delete(ledger, target)
+    err = writeBlockLedger(state_directory + blocks_ledger, ledger)
+    if err != nil {
+        return err
+    }
+
+    fmt.Println("Unblocked " + target + "; it is lifted on the next " +
+      "enforcing run, unless the policy blocks it again.")
+    return nil
+}
+
+//! List the blocks of the ledger
+/*
+ * @param     string[]    arguments; e.g. --all
+ *
+ * @return    error       error message, if any
+ */
+func runListBlocksCommand(args []string) error {
+
+    flags := newSubcommandFlagSet("list-blocks")
+    all := flags.Bool("all", false, "Also list the expired blocks that are " +
+      "still remembered.")
+
+    if _, err := parseSubcommandArgs(flags, args); err != nil {
+        return err
+    }
+
+    ledger, err := readBlockLedger(state_directory + blocks_ledger)
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    blocks := obtainActiveBlocks(ledger, now)
+    if *all {
+        blocks = sortBlockEntries(ledger)
+    }
+    if len(blocks) < 1 {
+        fmt.Println("No IPs blocked at this time.")
+        return nil
+    }
+
+    fmt.Printf("%-43s | %-6s | %-20s | %-20s | %5s | %3s | %s\n", "Target",
+      "Source", "First blocked", "Expires", "Hits", "Off", "Reason")
+    for _, block := range blocks {
+        source := "auto"
+        if block.manual {
+            source = "manual"
+        }
+        expires := block.expires.Format(ledgerTimeLayout)
+        if !block.active(now) {
+            expires = "expired"
+        }
+        fmt.Printf("%-43s | %-6s | %-20s | %-20s | %5d | %3d | %s\n",
+          block.target, source, block.first_blocked.Format(ledgerTimeLayout),
+          expires, block.hits, block.offences, block.reason)
+    }
+
+    return nil
+}
+
+//! Explain why an address is, or is not, blocked
+/*
+ * @param     string[]    arguments; e.g. 192.0.2.1
+ *
+ * @return    error       error message, if any
+ */
+func runExplainCommand(args []string) error {
+
+    // variable declaration
+    var entries = make([]logEntry, 0)
+    var total_requests int = 0
+    var latest_date string = ""
+
+    flags := newSubcommandFlagSet("explain")
+
+    positional, err := parseSubcommandArgs(flags, args)
+    if err != nil {
+        return err
+    }
+    if len(positional) != 1 || net.ParseIP(positional[0]) == nil {
+        return fmt.Errorf("usage: ascii-log explain <ip>")
+    }
+    ip := net.ParseIP(positional[0]).String()
+
+    serverType = strings.ToLower(serverType)
+    if !isStringInArray(serverType, validServerTypes) {
+        return fmt.Errorf("unknown server type: %s", serverType)
+    }
+    crawlerRules, err = parseCrawlerRules(crawlers)
+    if err != nil {
+        return err
+    }
+    policyRules, err = readPolicyFile(policyFile)
+    if err != nil {
+        return err
+    }
+    allowlistEntries, err = readAllowlistFile(allowlistFile)
+    if err != nil {
+        return err
+    }
+
+    // gather the entries of the address on the latest date, as the
+    // automated run does
+    lines, err := tokenizeFile(log_directory + serverType + "/" + access_log,
+      "\n")
+    if err == nil && len(lines) > 1 {
+        latest_date, _ = obtainLatestDate(lines[len(lines)-2])
+    }
+    for _, line := range lines {
+        if !strings.HasPrefix(line, ip + " ") {
+            continue
+        }
+        total_requests++
+        if len(latest_date) < 1 || !strings.Contains(line, latest_date) {
+            continue
+        }
+        if entry, err := parseLogLine(line); err == nil {
+            entries = append(entries, entry)
+        }
+    }
+    stats := aggregateIpStats(entries)[ip]
+    requests := len(entries)
+
+    // look up the network details
+    if err = setupLookups(); err != nil {
+        return err
+    }
+    lookup_ctx, cancel_lookups := obtainLookupContext()
+    defer cancel_lookups()
+    _, summary_map, record_map, err := obtainWhoisEntries(lookup_ctx,
+      map[string] int{ip: requests})
+    if err != nil {
+        return err
+    }
+    if err = saveWhoisCache(); err != nil {
+        return err
+    }
+    record := record_map[ip]
+    country := summary_map[ip]
+    if len(country) != 2 || country == ".." {
+        country = "--"
+    }
+
+    crawler := ""
+    if stats != nil {
+        crawler = obtainCrawlerChecks(lookup_ctx,
+          map[string] *ipStats{ip: stats}, crawlerRules)[ip].status
+    }
+
+    fmt.Println("IP address:   " + ip)
+    fmt.Println("Country:      " + country)
+    fmt.Println("Network:      " + strings.TrimSpace(record.asn + " " +
+      record.as_name + " " + record.netname + " " + record.cidr))
+    if len(crawler) > 0 {
+        fmt.Println("Crawler:      " + crawler)
+    }
+
+    // counts
+    fmt.Println("")
+    fmt.Printf("Requests:     %d in the log, %d on %s\n", total_requests,
+      requests, latest_date)
+    if stats != nil {
+        fmt.Printf("Errors:       %d (4xx %d, 5xx %d)\n", stats.errors,
+          stats.status4xx, stats.status5xx)
+        fmt.Printf("Redirects:    %d\n", stats.redirects)
+        fmt.Printf("Malformed:    %d\n", stats.malformed)
+        fmt.Printf("Busiest:      %d requests in a minute\n",
+          stats.peak_rate)
+        classes := make([]string, 0)
+        for _, pair := range sortMapByCount(stats.ua_classes) {
+            classes = append(classes, fmt.Sprintf("%s %d", pair.name,
+              pair.count))
+        }
+        fmt.Println("User agents:  " + strings.Join(classes, ", "))
+        signatures := make([]string, 0)
+        for _, pair := range sortMapByCount(stats.signatures) {
+            signatures = append(signatures, fmt.Sprintf("%s %d", pair.name,
+              pair.count))
+        }
+        if len(signatures) > 0 {
+            fmt.Println("Signatures:   " + strings.Join(signatures, ", "))
+        }
+    }
+
+    // matched rules, in order
+    input := policyInput{ip: ip, requests: requests, country: country,
+      asn: record.asn, crawler: crawler, stats: stats}
+    fmt.Println("")
+    fmt.Println("Policy rules:")
+    for _, rule := range policyRules {
+        matched := "no"
+        if rule.matches(input) {
+            matched = "yes"
+        }
+        fmt.Printf("  %-3s | %-5s | %s\n", matched, rule.action, rule.name)
+    }
+
+    decision := evaluatePolicy(policyRules, input)
+    if match := obtainAllowlistMatches(lookup_ctx, allowlistEntries,
+      []string{ip}, record_map)[ip]; len(match) > 0 {
+        decision = policyDecision{action: policyAllow,
+          rule: "allowlist (" + match + ")"}
+    }
+    if len(decision.action) < 1 {
+        decision = policyDecision{action: "-", rule: "no rule fired"}
+    }
+    fmt.Println("Decision:     " + decision.action + " (" + decision.rule +
+      ")")
+
+    // history of the blocks covering the address
+    ledger, err := readBlockLedger(state_directory + blocks_ledger)
+    if err != nil {
+        return err
+    }
+    now := time.Now()
+    fmt.Println("")
+    fmt.Println("Block history:")
+    history := 0
+    for _, block := range sortBlockEntries(ledger) {
+        if !targetsOverlap(block.target, ip) {
+            continue
+        }
+        state := "expired"
+        if block.active(now) {
+            state = "active"
+        }
+        source := "auto"
+        if block.manual {
+            source = "manual"
+        }
+        fmt.Printf("  %s: %s, %s block for '%s', first blocked %s, " +
+          "expires %s, %d hits, %d offences\n", block.target, state, source,
+          block.reason, block.first_blocked.Format(ledgerTimeLayout),
+          block.expires.Format(ledgerTimeLayout), block.hits,
+          block.offences)
+        history++
+    }
+    if history < 1 {
+        fmt.Println("  Never blocked, or long forgotten.")
+    }
+
+    return nil
+}
+
+//! Inspect, prune or warm the whois cache
+/*
+ * @param     string[]    arguments; e.g. warm 192.0.2.1
+ *
+ * @return    error       error message, if any
+ */
+func runWhoisCacheCommand(args []string) error {
+
+    // variable declaration
+    var action string = "list"
+
+    flags := newSubcommandFlagSet("whois-cache")
+
+    positional, err := parseSubcommandArgs(flags, args)
+    if err != nil {
+        return err
+    }
+    if len(positional) > 0 {
+        action = positional[0]
+        positional = positional[1:]
+    }
+
+    if whoisCacheTTL <= 0 {
+        return fmt.Errorf("the whois cache is disabled, since " +
+          "--whois-cache-ttl is 0")
+    }
+    if err = setupLookups(); err != nil {
+        return err
+    }
+
+    now := time.Now()
+    switch action {
+    case "list":
+        entries := whoisCacheEntries.sortedEntries()
+        if len(entries) < 1 {
+            fmt.Println("No whois records cached at this time.")
+            return nil
+        }
+        fmt.Printf("%-43s | %-6s | %-20s | %-20s | %s\n", "Key", "Status",
+          "Fetched", "Expires", "Network")
+        for _, entry := range entries {
+            status := "ok"
+            details := entry.result.record
+            network := strings.TrimSpace(details.asn + " " +
+              details.netname)
+            if entry.failed {
+                status = "failed"
+                network = entry.result.text
+            } else if entry.result.rdap {
+                status = "rdap"
+            }
+            expires := entry.expires.Format(ledgerTimeLayout)
+            if !now.Before(entry.expires) {
+                expires = "expired"
+            }
+            fmt.Printf("%-43s | %-6s | %-20s | %-20s | %s\n", entry.key,
+              status, entry.fetched.Format(ledgerTimeLayout), expires,
+              network)
+        }
+        return nil
+
+    case "show":
+        if len(positional) != 1 || net.ParseIP(positional[0]) == nil {
+            return fmt.Errorf("usage: ascii-log whois-cache show <ip>")
+        }
+        entry := whoisCacheEntries.lookup(positional[0], now)
+        if entry == nil {
+            return fmt.Errorf("%s is not cached", positional[0])
+        }
+        fmt.Println("Cached as " + entry.key + ", fetched " +
+          entry.fetched.Format(ledgerTimeLayout) + ", expires " +
+          entry.expires.Format(ledgerTimeLayout))
+        if entry.failed {
+            fmt.Println("Lookup failed: " + entry.result.text)
+        } else {
+            fmt.Println("")
+            fmt.Println(convertWhoisResultToString(entry.result))
+        }
+        return nil
+
+    case "prune":
+        pruned := whoisCacheEntries.prune(now)
+        if err = whoisCacheEntries.write(); err != nil {
+            return err
+        }
+        fmt.Printf("Forgot %d expired whois records, %d remain.\n", pruned,
+          len(whoisCacheEntries.entries))
+        return nil
+
+    case "warm":
+        return warmWhoisCache(positional, now)
+    }
+
+    return fmt.Errorf("usage: ascii-log whois-cache " +
+      "[list|show <ip>|prune|warm [ip...]]")
+}
+
+//! Look up the given addresses, or else those of the latest date in the
+//! access log, that are not yet cached
+/*
+ * @param     string[]    IP addresses, if any
+ * @param     Time        current time
+ *
+ * @return    error       error message, if any
+ */
+func warmWhoisCache(ips []string, now time.Time) error {
+
+    // variable declaration
+    var wanted = make(map[string] string)
+    var looked_up int = 0
+    var failed int = 0
+
+    for _, ip := range ips {
+        if net.ParseIP(ip) == nil {
+            return fmt.Errorf("'%s' is not an IP address", ip)
+        }
+        wanted[ip] = ""
+    }
+
+    // without any addresses given, warm the cache for the next run
+    if len(ips) < 1 {
+        serverType = strings.ToLower(serverType)
+        if !isStringInArray(serverType, validServerTypes) {
+            return fmt.Errorf("unknown server type: %s", serverType)
+        }
+        lines, err := tokenizeFile(log_directory + serverType + "/" +
+          access_log, "\n")
+        if err != nil {
+            return err
+        }
+        latest_date := ""
+        if len(lines) > 1 {
+            latest_date, _ = obtainLatestDate(lines[len(lines)-2])
+        }
+        for _, line := range lines {
+            ip := strings.Split(line, " ")[0]
+            if len(latest_date) < 1 || !strings.Contains(line, latest_date) ||
+              (!isValidIPv4Address(ip) && !isValidIPv6Address(ip)) {
+                continue
+            }
+            wanted[ip] = ""
+        }
+    }
+
+    uncached := make([]string, 0)
+    for _, ip := range sortedStringKeys(wanted) {
+        if whoisCacheEntries.lookup(ip, now) == nil {
+            uncached = append(uncached, ip)
+        }
+    }
+
+    lookup_ctx, cancel_lookups := obtainLookupContext()
+    defer cancel_lookups()
+    _, errs := obtainWhoisResults(lookup_ctx, uncached)
+    for _, err := range errs {
+        looked_up++
+        if err != nil {
+            failed++
+        }
+    }
+
+    whoisCacheEntries.prune(now)
+    if err := whoisCacheEntries.write(); err != nil {
+        return err
+    }
+
+    fmt.Printf("Looked up %d of %d IPs, %d of which failed; the others " +
+      "were already cached.\n", looked_up, len(wanted), failed)
+    return nil
+}
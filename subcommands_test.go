@@ -0,0 +1,235 @@
+//
+// Subcommand tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "flag"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+//! Point the subcommands at a fresh state directory and allowlist, put
+//! back once the test is over
+/*
+ * @param     T*        test state
+ * @param     string    contents of the allowlist
+ */
+func setupSubcommandState(t *testing.T, allowlist string) {
+
+    t.Helper()
+
+    saved_state, saved_allowlist := state_directory, allowlistFile
+    t.Cleanup(func() {
+        state_directory, allowlistFile = saved_state, saved_allowlist
+    })
+
+    dir := t.TempDir()
+    state_directory = dir + "/"
+    allowlistFile = filepath.Join(dir, "allowlist")
+    err := ioutil.WriteFile(allowlistFile, []byte(allowlist), 0644)
+    if err != nil {
+        t.Fatalf("unable to write the allowlist: %v", err)
+    }
+}
+
+//! Read the ledger of the subcommands
+/*
+ * @param     T*     test state
+ *
+ * @return    map    map of targets and their blocks
+ */
+func readSubcommandLedger(t *testing.T) map[string] *blockEntry {
+
+    t.Helper()
+
+    ledger, err := readBlockLedger(state_directory + blocks_ledger)
+    if err != nil {
+        t.Fatalf("unable to read the ledger: %v", err)
+    }
+    return ledger
+}
+
+func TestParseSubcommandArgs(t *testing.T) {
+
+    tests := []struct {
+        args       []string
+        positional string
+        ttl        time.Duration
+        reason     string
+        fails      bool
+    }{
+        {[]string{"192.0.2.1"}, "192.0.2.1", time.Hour, "manual", false},
+        {[]string{"192.0.2.1", "--ttl", "48h", "--reason", "spam"},
+          "192.0.2.1", 48 * time.Hour, "spam", false},
+        {[]string{"--ttl", "2h", "192.0.2.1", "--reason", "spam"},
+          "192.0.2.1", 2 * time.Hour, "spam", false},
+        {[]string{"--reason=spam", "192.0.2.1", "198.51.100.1", "-ttl=3h"},
+          "192.0.2.1 198.51.100.1", 3 * time.Hour, "spam", false},
+        {[]string{"192.0.2.1", "--", "--ttl"}, "192.0.2.1 --ttl",
+          time.Hour, "manual", false},
+        {[]string{}, "", time.Hour, "manual", false},
+        {[]string{"192.0.2.1", "--nonsense"}, "", 0, "", true},
+        {[]string{"192.0.2.1", "--ttl", "soon"}, "", 0, "", true},
+    }
+
+    for _, test := range tests {
+
+        flags := flag.NewFlagSet("test", flag.ContinueOnError)
+        flags.SetOutput(ioutil.Discard)
+        ttl := flags.Duration("ttl", time.Hour, "")
+        reason := flags.String("reason", "manual", "")
+
+        positional, err := parseSubcommandArgs(flags, test.args)
+        if test.fails {
+            if err == nil {
+                t.Errorf("%q: expected an error", test.args)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%q: unexpected error: %v", test.args, err)
+            continue
+        }
+
+        if strings.Join(positional, " ") != test.positional ||
+          *ttl != test.ttl || *reason != test.reason {
+            t.Errorf("%q: parsed %q, %v, %q, want %q, %v, %q", test.args,
+              positional, *ttl, *reason, test.positional, test.ttl,
+              test.reason)
+        }
+    }
+}
+
+func TestRunBlockCommand(t *testing.T) {
+
+    setupSubcommandState(t, "192.0.2.10\n10.9.0.0/16\n")
+
+    // flags on either side of the address
+    start := time.Now()
+    output := captureStdout(t, func() {
+        err := runBlockCommand([]string{"--reason", "credential  stuffing",
+          "198.51.100.7", "--ttl", "48h"})
+        if err != nil {
+            t.Errorf("unexpected error: %v", err)
+        }
+    })
+    if !strings.HasPrefix(output, "Blocked 198.51.100.7 until ") {
+        t.Errorf("output = %q", output)
+    }
+
+    block := readSubcommandLedger(t)["198.51.100.7"]
+    if block == nil {
+        t.Fatalf("block not recorded")
+    }
+    if !block.manual || block.reason != "credential stuffing" ||
+      block.expires.Before(start.Add(48 * time.Hour - time.Second)) ||
+      block.expires.After(time.Now().Add(48 * time.Hour)) {
+        t.Errorf("block = %+v, want a manual 48h block", block)
+    }
+
+    tests := []struct {
+        name string
+        args []string
+    }{
+        {"allowlisted address", []string{"192.0.2.10"}},
+        {"address of an allowlisted network", []string{"10.9.8.7"}},
+        {"network holding an allowlisted address",
+          []string{"192.0.2.0/24", "--ttl", "1h"}},
+        {"improper address", []string{"192.0.2.300"}},
+        {"no address", []string{"--ttl", "1h"}},
+        {"two addresses", []string{"203.0.113.1", "203.0.113.2"}},
+        {"non-positive length", []string{"203.0.113.1", "--ttl", "0s"}},
+    }
+
+    for _, test := range tests {
+        err := runBlockCommand(test.args)
+        if err == nil {
+            t.Errorf("%s: expected an error", test.name)
+        }
+    }
+
+    // none of the refused blocks made it into the ledger
+    ledger := readSubcommandLedger(t)
+    if len(ledger) != 1 {
+        t.Errorf("ledger holds %d blocks, want 1", len(ledger))
+    }
+}
+
+func TestRunUnblockCommand(t *testing.T) {
+
+    setupSubcommandState(t, "")
+
+    now := time.Now()
+    ledger := make(map[string] *blockEntry)
+    recordBlock(ledger, "198.51.100.0/24", "subnet", time.Hour, now)
+    recordBlock(ledger, "203.0.113.5", "busy", time.Hour, now)
+    err := writeBlockLedger(state_directory + blocks_ledger, ledger)
+    if err != nil {
+        t.Fatalf("unable to write the ledger: %v", err)
+    }
+
+    // an address only covered by a wider block cannot be lifted alone
+    err = runUnblockCommand([]string{"198.51.100.7"})
+    if err == nil || !strings.Contains(err.Error(),
+      "covered by: 198.51.100.0/24") {
+        t.Errorf("unblocking a covered address = %v, want the covering " +
+          "block named", err)
+    }
+
+    err = runUnblockCommand([]string{"192.0.2.99"})
+    if err == nil || !strings.Contains(err.Error(), "is not blocked") {
+        t.Errorf("unblocking an unblocked address = %v", err)
+    }
+
+    captureStdout(t, func() {
+        err = runUnblockCommand([]string{"203.0.113.5"})
+    })
+    if err != nil {
+        t.Errorf("unexpected error: %v", err)
+    }
+
+    ledger = readSubcommandLedger(t)
+    if _, exists := ledger["203.0.113.5"]; exists {
+        t.Errorf("unblocked address still in the ledger")
+    }
+    if _, exists := ledger["198.51.100.0/24"]; !exists {
+        t.Errorf("covering block lifted along with the address")
+    }
+}
+
+func TestManualBlockOutlastsRecordBlock(t *testing.T) {
+
+    now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+    ledger := make(map[string] *blockEntry)
+    recordManualBlock(ledger, "192.0.2.1", "by hand", 48 * time.Hour, now)
+
+    // the automated run catches the address again, yet the manual block
+    // keeps its length and reason
+    entry := recordBlock(ledger, "192.0.2.1", "busy", time.Hour,
+      now.Add(time.Hour))
+    if !entry.manual || entry.reason != "by hand" ||
+      !entry.expires.Equal(now.Add(48 * time.Hour)) {
+        t.Errorf("manual block = %+v, want it kept as given", entry)
+    }
+    if !entry.active(now.Add(47 * time.Hour)) {
+        t.Errorf("manual block lifted early")
+    }
+
+    // once it has expired, the automated run takes it over
+    entry = recordBlock(ledger, "192.0.2.1", "busy", time.Hour,
+      now.Add(49 * time.Hour))
+    if entry.manual || entry.reason != "busy" {
+        t.Errorf("expired manual block = %+v, want an automated one", entry)
+    }
+}
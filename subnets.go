@@ -0,0 +1,309 @@
+//
+// Subnet aggregation functions for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+//! Request totals of every address within a given subnet
+type subnetStats struct {
+    subnet   string
+    hosts    int
+    requests int
+}
+
+//! Obtain the subnet of a given prefix length an IP address belongs to
+/*
+ * @param     string    IP address
+ * @param     int       prefix length used for IPv4 addresses
+ * @param     int       prefix length used for IPv6 addresses
+ *
+ * @return    string    subnet, as a CIDR
+ * @return    error     error message, if any
+ */
+func obtainSubnetFromIp(ip string, prefix_len4 int,
+  prefix_len6 int) (string, error) {
+
+    // the /24 and /64 cases have their own functions
+    if isValidIPv6Address(ip) {
+        if prefix_len6 < 1 {
+            return "", fmt.Errorf("obtainSubnetFromIp() --> no IPv6 prefix")
+        } else if prefix_len6 == 64 {
+            return obtainSlash64FromIpv6(ip)
+        }
+        return obtainPrefixFromIp(ip, prefix_len6)
+    }
+
+    if prefix_len4 < 1 {
+        return "", fmt.Errorf("obtainSubnetFromIp() --> no IPv4 prefix")
+    } else if prefix_len4 == 24 {
+        return obtainSlash24FromIpv4(ip)
+    }
+    return obtainPrefixFromIp(ip, prefix_len4)
+}
+
+//! Roll up IP address counts into subnets of the given prefix length
+/*
+ * @param     map              string map containing ip addresses and counts
+ * @param     int              prefix length used for IPv4 addresses
+ * @param     int              prefix length used for IPv6 addresses
+ *
+ * @return    subnetStats[]    subnets, most requests first
+ */
+func aggregateSubnets(ip_map map[string] int, prefix_len4 int,
+  prefix_len6 int) []subnetStats {
+
+    // variable declaration
+    var subnet_map = make(map[string] *subnetStats)
+    var result = make([]subnetStats, 0)
+
+    // for every IP address...
+    for ip, count := range ip_map {
+
+        // determine the subnet the address belongs to
+        subnet, err := obtainSubnetFromIp(ip, prefix_len4, prefix_len6)
+
+        // if an error occurs, skip to the next element
+        if err != nil {
+            continue
+        }
+
+        // add the address to the subnet totals
+        stats, exists := subnet_map[subnet]
+        if !exists {
+            stats = &subnetStats{subnet: subnet}
+            subnet_map[subnet] = stats
+        }
+        stats.hosts++
+        stats.requests += count
+    }
+
+    // copy the subnets into an array
+    for _, stats := range subnet_map {
+        result = append(result, *stats)
+    }
+
+    // sort by requests, then hosts, then name, so that the order is
+    // always the same between runs
+    sort.Slice(result, func(i, j int) bool {
+        if result[i].requests != result[j].requests {
+            return result[i].requests > result[j].requests
+        }
+        if result[i].hosts != result[j].hosts {
+            return result[i].hosts > result[j].hosts
+        }
+        return result[i].subnet < result[j].subnet
+    })
+
+    return result
+}
+
+//! Determine which subnets ought to be blocked as a whole
+/*
+ * @param     subnetStats[]    subnets to check
+ * @param     int              minimum number of hosts in the subnet
+ * @param     int              minimum number of requests from the subnet
+ *
+ * @return    subnetStats[]    subnets that crossed both thresholds
+ */
+func obtainSubnetsToBlock(subnets []subnetStats, min_hosts int,
+  min_requests int) []subnetStats {
+
+    // variable declaration
+    var result = make([]subnetStats, 0)
+
+    // a threshold of zero or less disables subnet blocking
+    if min_hosts < 1 || min_requests < 1 {
+        return result
+    }
+
+    for _, stats := range subnets {
+        if stats.hosts >= min_hosts && stats.requests >= min_requests {
+            result = append(result, stats)
+        }
+    }
+
+    return result
+}
+
+//! Run the subnets that crossed the thresholds through the policy
+/*
+ * A subnet is evaluated like a single address, with the requests of all of
+ * its hosts and the country and AS number they share, if any. It is left
+ * alone if the policy allows it, or if it allows any address within it, so
+ * that e.g. verified crawlers or home country visitors are never caught up
+ * in a subnet block.
+ *
+ * @param     subnetStats[]    subnets that crossed the thresholds
+ * @param     policyRule[]     array of rules, in order
+ * @param     map              map of ip addresses and their decisions
+ * @param     map              map of ip addresses and their country codes
+ * @param     map              map of ip addresses and their whois records
+ *
+ * @return    string[]         CIDRs of the subnets to block
+ * @return    map              map of subnets left alone, and the rule why
+ */
+func obtainPolicySubnets(subnets []subnetStats, rules []policyRule,
+  decisions map[string] policyDecision, country_map map[string] string,
+  record_map map[string] whoisRecord) ([]string, map[string] string) {
+
+    // variable declaration
+    var blocked_subnets = make([]string, 0)
+    var allowed_subnets = make(map[string] string)
+    var members = make(map[string] []string)
+
+    // nothing to do if no subnet crossed the thresholds
+    if len(subnets) < 1 {
+        return blocked_subnets, allowed_subnets
+    }
+
+    // group the evaluated addresses by their /24 or /64, in order, so
+    // that the allowing rule reported is always the same between runs
+    ips := make([]string, 0, len(decisions))
+    for ip := range decisions {
+        ips = append(ips, ip)
+    }
+    sort.Strings(ips)
+    for _, ip := range ips {
+        subnet, err := obtainSubnetFromIp(ip, 24, 64)
+        if err == nil {
+            members[subnet] = append(members[subnet], ip)
+        }
+    }
+
+    for _, stats := range subnets {
+
+        // an allowed address keeps its whole subnet unblocked
+        rule := ""
+        for _, ip := range members[stats.subnet] {
+            if decisions[ip].action == policyAllow {
+                rule = decisions[ip].rule + " for " + ip
+                break
+            }
+        }
+
+        // the country and AS number, if every host shares them
+        country, asn := "", ""
+        for i, ip := range members[stats.subnet] {
+            code := country_map[ip]
+            if len(code) != 2 || code == ".." {
+                code = "--"
+            }
+            if i == 0 {
+                country, asn = code, record_map[ip].asn
+                continue
+            }
+            if code != country {
+                country = "--"
+            }
+            if record_map[ip].asn != asn {
+                asn = ""
+            }
+        }
+        if country == "" {
+            country = "--"
+        }
+
+        // the subnet itself, as seen from its network address
+        if rule == "" {
+            decision := evaluatePolicy(rules, policyInput{
+                ip:       strings.Split(stats.subnet, "/")[0],
+                requests: stats.requests,
+                country:  country,
+                asn:      asn,
+            })
+            if decision.action == policyAllow {
+                rule = decision.rule
+            }
+        }
+
+        if rule != "" {
+            allowed_subnets[stats.subnet] = rule
+            continue
+        }
+        blocked_subnets = append(blocked_subnets, stats.subnet)
+    }
+
+    return blocked_subnets, allowed_subnets
+}
+
+//! Convert a list of subnets into lines of the subnets log
+/*
+ * @param     subnetStats[]    subnets to list
+ * @param     map              map of subnets and a note on what became of
+ *                             them, e.g. "blocked"
+ *
+ * @return    string           lines that contain "requests | hosts | subnet"
+ */
+func convertSubnetsToString(subnets []subnetStats,
+  subnet_notes map[string] string) string {
+
+    // variable declaration
+    var subnet_strings string = ""
+
+    // if no subnets present, append a line about there being no data
+    if len(subnets) < 1 {
+        return "No subnets listed at this time.\n"
+    }
+
+    subnet_strings += fmt.Sprintf("%8s | %5s | %-43s |\n", "requests",
+      "hosts", "subnet")
+
+    for _, stats := range subnets {
+
+        subnet_strings += fmt.Sprintf("%8d | %5d | %-43s | %s\n",
+          stats.requests, stats.hosts, stats.subnet,
+          subnet_notes[stats.subnet])
+    }
+
+    return subnet_strings
+}
+
+//! Assemble the contents of the subnets log
+/*
+ * @param     map         string map containing ip addresses and counts
+ * @param     int[]       additional, wider IPv4 prefix lengths
+ * @param     int[]       additional, wider IPv6 prefix lengths
+ * @param     map         map of /24 and /64 subnets and what became of them
+ *
+ * @return    string      subnet report
+ */
+func obtainSubnetReport(ip_map map[string] int, prefixes4 []int,
+  prefixes6 []int, subnet_notes map[string] string) string {
+
+    // variable declaration
+    var subnet_strings string = ""
+
+    // the /24 and /64 subnets are the ones considered for blocking
+    subnet_strings += "Subnets (/24 and /64)\n\n"
+    subnet_strings += convertSubnetsToString(aggregateSubnets(ip_map, 24,
+      64), subnet_notes)
+
+    // the wider prefixes are purely informational
+    for _, prefix_len := range prefixes4 {
+        subnet_strings += "\nSubnets (IPv4 /" + strconv.Itoa(prefix_len) +
+          ")\n\n"
+        subnet_strings += convertSubnetsToString(
+          aggregateSubnets(ip_map, prefix_len, 0), nil)
+    }
+    for _, prefix_len := range prefixes6 {
+        subnet_strings += "\nSubnets (IPv6 /" + strconv.Itoa(prefix_len) +
+          ")\n\n"
+        subnet_strings += convertSubnetsToString(
+          aggregateSubnets(ip_map, 0, prefix_len), nil)
+    }
+
+    return strings.TrimRight(subnet_strings, "\n") + "\n"
+}
@@ -0,0 +1,126 @@
+//
+// Subnet aggregation tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "strings"
+    "testing"
+)
+
+func TestAggregateSubnets(t *testing.T) {
+
+    ip_map := map[string] int{
+        "192.0.2.1":     10,
+        "192.0.2.200":   5,
+        "198.51.100.7":  20,
+        "2001:db8::1":   3,
+        "2001:db8::ff":  4,
+        "2001:db8:1::1": 1,
+    }
+
+    tests := []struct {
+        prefix_len4 int
+        prefix_len6 int
+        want        string
+    }{
+        {24, 64, "198.51.100.0/24 1 20, 192.0.2.0/24 2 15, " +
+          "2001:db8::/64 2 7, 2001:db8:1::/64 1 1"},
+        {16, 0, "198.51.0.0/16 1 20, 192.0.0.0/16 2 15"},
+        {0, 32, "2001:db8::/32 3 8"},
+    }
+
+    for _, test := range tests {
+
+        pieces := make([]string, 0)
+        for _, stats := range aggregateSubnets(ip_map, test.prefix_len4,
+          test.prefix_len6) {
+            pieces = append(pieces, fmt.Sprintf("%s %d %d", stats.subnet,
+              stats.hosts, stats.requests))
+        }
+
+        got := strings.Join(pieces, ", ")
+        if got != test.want {
+            t.Errorf("aggregateSubnets(/%d, /%d) = %q, want %q",
+              test.prefix_len4, test.prefix_len6, got, test.want)
+        }
+    }
+}
+
+func TestObtainPolicySubnets(t *testing.T) {
+
+    rules, err := parsePolicy(`
+verified-crawlers  allow  ua=verified-crawler
+office             allow  cidr=203.0.113.0/24
+home-countries     allow  country=US,CA
+busy               block  requests>=5
+`)
+    if err != nil {
+        t.Fatalf("unable to parse the policy: %v", err)
+    }
+
+    // five hosts in each subnet, one of them verified as a crawler in
+    // the first, all of them in a home country in the second
+    ip_map := make(map[string] int)
+    decisions := make(map[string] policyDecision)
+    countries := make(map[string] string)
+    records := make(map[string] whoisRecord)
+    for host := 1; host <= 5; host++ {
+        for _, prefix := range []string{"66.249.66.", "192.0.2.",
+          "198.51.100.", "203.0.113."} {
+            ip := fmt.Sprintf("%s%d", prefix, host)
+            ip_map[ip] = 6
+            decisions[ip] = policyDecision{action: policyBlock,
+              rule: "busy"}
+            countries[ip] = "BR"
+            records[ip] = whoisRecord{asn: "AS64500"}
+        }
+        countries[fmt.Sprintf("198.51.100.%d", host)] = "US"
+        decisions[fmt.Sprintf("198.51.100.%d", host)] = policyDecision{
+          action: policyAllow, rule: "home-countries"}
+        decisions[fmt.Sprintf("203.0.113.%d", host)] = policyDecision{}
+    }
+    decisions["66.249.66.3"] = policyDecision{action: policyAllow,
+      rule: "verified-crawlers"}
+
+    candidates := obtainSubnetsToBlock(aggregateSubnets(ip_map, 24, 64),
+      5, 25)
+    if len(candidates) != 4 {
+        t.Fatalf("candidates = %+v", candidates)
+    }
+
+    blocked, allowed := obtainPolicySubnets(candidates, rules, decisions,
+      countries, records)
+
+    if strings.Join(blocked, ",") != "192.0.2.0/24" {
+        t.Errorf("blocked = %v, want [192.0.2.0/24]", blocked)
+    }
+    want := map[string] string{
+        "66.249.66.0/24":  "verified-crawlers for 66.249.66.3",
+        "198.51.100.0/24": "home-countries for 198.51.100.1",
+        "203.0.113.0/24":  "office",
+    }
+    if len(allowed) != len(want) {
+        t.Errorf("allowed = %v", allowed)
+    }
+    for subnet, rule := range want {
+        if allowed[subnet] != rule {
+            t.Errorf("%s: allowed by %q, want %q", subnet, allowed[subnet],
+              rule)
+        }
+    }
+
+    // thresholds of zero turn subnet blocking off
+    if len(obtainSubnetsToBlock(aggregateSubnets(ip_map, 24, 64), 0,
+      25)) > 0 {
+        t.Errorf("subnets blocked with a host threshold of zero")
+    }
+}
@@ -0,0 +1,216 @@
+//
+// Managed web server config file functions for ASCII-log
+//
+// The blocked addresses can be written to a file that the web server
+// includes. Every change is checked with the config test of the server
+// before it is reloaded, and the file is put back the way it was if the
+// check fails. The file of the previous run is kept next to it, with a
+// .previous suffix, so that it can be rolled back by hand as well.
+//
+// Every change is appended to a history file as a line diff, along with
+// whether it was applied or rolled back.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+    "time"
+)
+
+//! A web server config file managed by this program
+type managedConfigFile struct {
+    runner         commandRunner
+    path           string
+    dry_run        bool
+    test_command   []string
+    reload_command []string
+    history_path   string
+}
+
+//! Assemble a line diff between two texts, using their longest common
+//! subsequence of lines
+/*
+ * @param     string    old text
+ * @param     string    new text
+ *
+ * @return    string    removed lines prefixed with -, added ones with +
+ */
+func diffLines(old_text string, new_text string) string {
+
+    // variable declaration
+    var diff string = ""
+
+    old_lines := strings.Split(strings.TrimSuffix(old_text, "\n"), "\n")
+    new_lines := strings.Split(strings.TrimSuffix(new_text, "\n"), "\n")
+    if len(old_text) < 1 {
+        old_lines = []string{}
+    }
+    if len(new_text) < 1 {
+        new_lines = []string{}
+    }
+
+    // lengths of the common subsequences of every pair of suffixes
+    common := make([][]int, len(old_lines)+1)
+    for i := range common {
+        common[i] = make([]int, len(new_lines)+1)
+    }
+    for i := len(old_lines)-1; i >= 0; i-- {
+        for j := len(new_lines)-1; j >= 0; j-- {
+            if old_lines[i] == new_lines[j] {
+                common[i][j] = common[i+1][j+1] + 1
+            } else if common[i+1][j] >= common[i][j+1] {
+                common[i][j] = common[i+1][j]
+            } else {
+                common[i][j] = common[i][j+1]
+            }
+        }
+    }
+
+    // walk thru both texts, noting the lines outside of the subsequence
+    i, j := 0, 0
+    for i < len(old_lines) || j < len(new_lines) {
+        switch {
+        case i < len(old_lines) && j < len(new_lines) &&
+          old_lines[i] == new_lines[j]:
+            i++
+            j++
+        case j < len(new_lines) && (i >= len(old_lines) ||
+          common[i][j+1] >= common[i+1][j]):
+            diff += "+" + new_lines[j] + "\n"
+            j++
+        default:
+            diff += "-" + old_lines[i] + "\n"
+            i++
+        }
+    }
+
+    return diff
+}
+
+//! Append a change to the history file, if there is one
+/*
+ * @param     string    previous contents
+ * @param     string    new contents
+ * @param     string    outcome; e.g. applied
+ *
+ * @return    error     error message, if any
+ */
+func (m *managedConfigFile) recordChange(previous string, contents string,
+  outcome string) error {
+
+    if len(m.history_path) < 1 {
+        return nil
+    }
+
+    entry := "=== " + time.Now().Format(time.RFC3339) + " " + m.path +
+      " (" + outcome + ")\n" + diffLines(previous, contents) + "\n"
+
+    file, err := os.OpenFile(m.history_path, os.O_APPEND|os.O_CREATE|
+      os.O_WRONLY, 0644)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    _, err = file.WriteString(entry)
+    return err
+}
+
+//! Restore the file to how it was before the last write
+/*
+ * @param     string    previous contents
+ * @param     bool      whether or not the file existed beforehand
+ *
+ * @return    error     error message, if any
+ */
+func (m *managedConfigFile) restore(previous string, existed bool) error {
+
+    if !existed {
+        return os.Remove(m.path)
+    }
+    return ioutil.WriteFile(m.path, []byte(previous), 0644)
+}
+
+//! Write new contents to the file, then test and reload the server
+/*
+ * @param     string    new contents
+ *
+ * @return    bool      whether or not the file was changed
+ * @return    error     error message, if any
+ */
+func (m *managedConfigFile) apply(contents string) (bool, error) {
+
+    // variable declaration
+    var previous string = ""
+
+    byte_contents, err := ioutil.ReadFile(m.path)
+    existed := err == nil
+    if existed {
+        previous = string(byte_contents)
+    }
+
+    // nothing to do if the file is already up to date
+    if existed && previous == contents {
+        return false, nil
+    }
+
+    // during a dry run, print the changes that would have been written
+    if m.dry_run {
+        fmt.Println("# write " + m.path)
+        fmt.Print(diffLines(previous, contents))
+        return true, nil
+    }
+
+    // keep the current file, so that it can be rolled back to
+    if existed {
+        err = ioutil.WriteFile(m.path + ".previous", byte_contents, 0644)
+        if err != nil {
+            return false, err
+        }
+    }
+
+    err = ioutil.WriteFile(m.path, []byte(contents), 0644)
+    if err != nil {
+        return false, err
+    }
+
+    // put the file back if the server refuses the new config, so that a
+    // later restart does not fail
+    if len(m.test_command) > 0 {
+        _, err = m.runner.run(m.test_command[0], m.test_command[1:], "")
+        if err != nil {
+            m.recordChange(previous, contents, "rolled back")
+            if restore_err := m.restore(previous, existed); restore_err !=
+              nil {
+                return true, fmt.Errorf("%s; also unable to restore %s: %s",
+                  err.Error(), m.path, restore_err.Error())
+            }
+            return false, fmt.Errorf("config test failed, restored %s: %s",
+              m.path, err.Error())
+        }
+    }
+
+    err = m.recordChange(previous, contents, "applied")
+    if err != nil {
+        return true, err
+    }
+
+    if len(m.reload_command) > 0 {
+        _, err = m.runner.run(m.reload_command[0], m.reload_command[1:], "")
+        if err != nil {
+            return true, err
+        }
+    }
+
+    return true, nil
+}
@@ -0,0 +1,170 @@
+//
+// Managed web server config tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestDiffLines(t *testing.T) {
+
+    tests := []struct {
+        name     string
+        old_text string
+        new_text string
+        want     string
+    }{
+        {"unchanged", "a\nb\n", "a\nb\n", ""},
+        {"both empty", "", "", ""},
+        {"new file", "", "a\nb\n", "+a\n+b\n"},
+        {"removed file", "a\nb\n", "", "-a\n-b\n"},
+        {"line removed", "a\nb\nc\n", "a\nc\n", "-b\n"},
+        {"line added", "a\nc\n", "a\nb\nc\n", "+b\n"},
+        {"line replaced", "a\nb\n", "a\nc\n", "+c\n-b\n"},
+        {"no trailing newline", "a\nb", "a\nb\n", ""},
+        {
+            "block list shifted",
+            "Require not ip 192.0.2.1\nRequire not ip 192.0.2.2\n",
+            "Require not ip 192.0.2.2\nRequire not ip 192.0.2.3\n",
+            "-Require not ip 192.0.2.1\n+Require not ip 192.0.2.3\n",
+        },
+    }
+
+    for _, test := range tests {
+        got := diffLines(test.old_text, test.new_text)
+        if got != test.want {
+            t.Errorf("%s: diffLines() = %q, want %q", test.name, got,
+              test.want)
+        }
+    }
+}
+
+func TestAssembleApacheBlockConfig(t *testing.T) {
+
+    tests := []struct {
+        name    string
+        targets []string
+        merge   bool
+        want    string
+    }{
+        {
+            name:    "include file",
+            targets: []string{"192.0.2.1", "2001:db8::/64"},
+            want:    "<RequireAll>\n" +
+              "    Require all granted\n" +
+              "    Require not ip 192.0.2.1\n" +
+              "    Require not ip 2001:db8::/64\n" +
+              "</RequireAll>\n",
+        },
+        {
+            name:    ".htaccess keeps the existing authorization",
+            targets: []string{"192.0.2.1"},
+            merge:   true,
+            want:    "AuthMerging And\n" +
+              "<RequireAll>\n" +
+              "    Require all granted\n" +
+              "    Require not ip 192.0.2.1\n" +
+              "</RequireAll>\n",
+        },
+    }
+
+    for _, test := range tests {
+        got := assembleApacheBlockConfig(test.targets, test.merge)
+        if got != test.want {
+            t.Errorf("%s: config:\n%s\nwant:\n%s", test.name, got,
+              test.want)
+        }
+    }
+}
+
+func TestApacheBlockFileApply(t *testing.T) {
+
+    dir := t.TempDir()
+    history := filepath.Join(dir, "config.history")
+
+    tests := []struct {
+        name     string
+        path     string
+        failures map[string] bool
+        changed  bool
+        fails    bool
+        commands []string
+        contents string
+    }{
+        {
+            name:     "include tested and reloaded",
+            path:     filepath.Join(dir, "block.conf"),
+            changed:  true,
+            commands: []string{"apachectl configtest", "apachectl graceful"},
+            contents: "after\n",
+        },
+        {
+            name:     ".htaccess tested, not reloaded",
+            path:     filepath.Join(dir, ".htaccess"),
+            changed:  true,
+            commands: []string{"apachectl configtest"},
+            contents: "after\n",
+        },
+        {
+            name:     "failed configtest rolls back",
+            path:     filepath.Join(dir, "rollback.conf"),
+            failures: map[string] bool{"apachectl configtest": true},
+            fails:    true,
+            commands: []string{"apachectl configtest"},
+            contents: "before\n",
+        },
+    }
+
+    for _, test := range tests {
+
+        err := ioutil.WriteFile(test.path, []byte("before\n"), 0644)
+        if err != nil {
+            t.Fatalf("unable to write %s: %v", test.path, err)
+        }
+
+        runner := &recordingRunner{failures: test.failures}
+        file := newApacheBlockFile(runner, test.path, "apachectl", false,
+          history)
+        changed, err := file.apply("after\n")
+        if changed != test.changed || (err != nil) != test.fails {
+            t.Errorf("%s: apply() = %v, %v", test.name, changed, err)
+        }
+
+        checkCommandLines(t, runner.lines(), test.commands)
+
+        contents, _ := ioutil.ReadFile(test.path)
+        if string(contents) != test.contents {
+            t.Errorf("%s: contents = %q, want %q", test.name, contents,
+              test.contents)
+        }
+
+        // applying the same contents again is a no-op
+        if !test.fails {
+            runner = &recordingRunner{}
+            file.runner = runner
+            changed, err = file.apply("after\n")
+            if changed || err != nil || len(runner.commands) > 0 {
+                t.Errorf("%s: second apply() = %v, %v, %v", test.name,
+                  changed, err, runner.lines())
+            }
+        }
+    }
+
+    // every change is recorded, along with its outcome
+    contents, _ := ioutil.ReadFile(history)
+    if strings.Count(string(contents), "(applied)") != 2 ||
+      strings.Count(string(contents), "(rolled back)") != 1 {
+        t.Errorf("history:\n%s", contents)
+    }
+}
@@ -0,0 +1,442 @@
+//
+// Whois cache functions for ASCII-log
+//
+// Whois lookups are slow, and the registries rate limit those that make too
+// many of them, so every record is kept on disk between runs. A record is
+// stored under the CIDR it was allocated from, so that a single lookup
+// covers every address of that network, or under the IP address itself if
+// the record lacks one. Failed lookups are remembered as well, for a shorter
+// while, so that they are not retried on every run.
+//
+// The cache is stored one record per line, with tab separated columns:
+//
+// # key  fetched  expires  status  record
+//
+// The status is ok, rdap or failed, and the record is quoted, so that it
+// fits on a single line. An ok record holds the whois text, an rdap record
+// the network details of the RDAP object as JSON, and a failed one the
+// error instead.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+//! A cached whois record, or failed lookup
+type whoisCacheEntry struct {
+    key     string
+    network *net.IPNet
+    fetched time.Time
+    expires time.Time
+    failed  bool
+    result  whoisResult
+}
+
+//! Network details of an RDAP record, as cached
+type whoisCacheRdap struct {
+    Url        string `json:"url"`
+    Country    string `json:"country,omitempty"`
+    Asn        string `json:"asn,omitempty"`
+    Netname    string `json:"netname,omitempty"`
+    Org        string `json:"org,omitempty"`
+    Cidr       string `json:"cidr,omitempty"`
+    AbuseEmail string `json:"abuse_email,omitempty"`
+}
+
+//! Encode the network details of an RDAP result for the cache
+/*
+ * @param     whoisResult    RDAP result
+ *
+ * @return    string         JSON of the network details
+ */
+func encodeWhoisCacheRdap(result whoisResult) string {
+
+    byte_contents, _ := json.Marshal(whoisCacheRdap{Url: result.text,
+      Country: result.record.country, Asn: result.record.asn,
+      Netname: result.record.netname, Org: result.record.org,
+      Cidr: result.record.cidr, AbuseEmail: result.record.abuse_email})
+
+    return string(byte_contents)
+}
+
+//! Decode the network details of a cached RDAP result
+/*
+ * @param     string         JSON of the network details
+ *
+ * @return    whoisResult    RDAP result
+ * @return    error          error message, if any
+ */
+func decodeWhoisCacheRdap(contents string) (whoisResult, error) {
+
+    // variable declaration
+    var cached whoisCacheRdap
+
+    err := json.Unmarshal([]byte(contents), &cached)
+    if err != nil {
+        return whoisResult{}, err
+    }
+
+    return whoisResult{text: cached.Url, rdap: true,
+      record: whoisRecord{country: cached.Country, asn: cached.Asn,
+      netname: cached.Netname, org: cached.Org, cidr: cached.Cidr,
+      abuse_email: cached.AbuseEmail}}, nil
+}
+
+//! Whois records of earlier runs, by IP address or CIDR; lookups and
+//! stores may be made by several workers at once
+type whoisCache struct {
+    path         string
+    ttl          time.Duration
+    negative_ttl time.Duration
+    mutex        sync.Mutex
+    entries      map[string] *whoisCacheEntry
+}
+
+//! Read the whois cache
+/*
+ * @param     string        /path/to/file
+ * @param     Duration      length of time records are kept
+ * @param     Duration      length of time failed lookups are kept
+ *
+ * @return    whoisCache    cached records
+ * @return    error         error message, if any
+ */
+func readWhoisCache(path string, ttl time.Duration,
+  negative_ttl time.Duration) (*whoisCache, error) {
+
+    // variable declaration
+    var cache = &whoisCache{path: path, ttl: ttl,
+      negative_ttl: negative_ttl,
+      entries: make(map[string] *whoisCacheEntry)}
+
+    // a missing file simply means nothing has been looked up yet
+    byte_contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return cache, nil
+    }
+
+    for i, line := range strings.Split(string(byte_contents), "\n") {
+
+        if len(strings.TrimSpace(line)) < 1 ||
+          strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        pieces := strings.SplitN(line, "\t", 5)
+        if len(pieces) < 5 {
+            return nil, fmt.Errorf("readWhoisCache() --> line %d of %s " +
+              "has too few columns", i+1, path)
+        }
+
+        entry := &whoisCacheEntry{key: pieces[0],
+          failed: pieces[3] == "failed"}
+        contents := ""
+        entry.fetched, err = time.Parse(ledgerTimeLayout, pieces[1])
+        if err == nil {
+            entry.expires, err = time.Parse(ledgerTimeLayout, pieces[2])
+        }
+        if err == nil {
+            contents, err = strconv.Unquote(pieces[4])
+        }
+
+        // whois text is parsed once, here, rather than on every lookup
+        if err == nil && pieces[3] == "rdap" {
+            entry.result, err = decodeWhoisCacheRdap(contents)
+        } else if err == nil {
+            entry.result = whoisResult{text: contents}
+            if !entry.failed {
+                entry.result.record = parseWhoisRecord(contents)
+            }
+        }
+        if err == nil && strings.Contains(entry.key, "/") {
+            _, entry.network, err = net.ParseCIDR(entry.key)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("readWhoisCache() --> line %d of %s " +
+              "is improper: %s", i+1, path, err.Error())
+        }
+
+        cache.entries[entry.key] = entry
+    }
+
+    return cache, nil
+}
+
+//! Write the whois cache
+/*
+ * @return    error    error message, if any
+ */
+func (c *whoisCache) write() error {
+
+    // variable declaration
+    var contents string = ""
+
+    contents += "# key\tfetched\texpires\tstatus\trecord\n"
+
+    for _, entry := range c.sortedEntries() {
+        status, record := "ok", entry.result.text
+        if entry.failed {
+            status = "failed"
+        } else if entry.result.rdap {
+            status, record = "rdap", encodeWhoisCacheRdap(entry.result)
+        }
+        contents += strings.Join([]string{entry.key,
+          entry.fetched.Format(ledgerTimeLayout),
+          entry.expires.Format(ledgerTimeLayout), status,
+          strconv.Quote(record)}, "\t") + "\n"
+    }
+
+    return writeLogFile(c.path, contents)
+}
+
+//! Obtain the cached records, sorted by key
+/*
+ * @return    whoisCacheEntry[]    sorted records
+ */
+func (c *whoisCache) sortedEntries() []*whoisCacheEntry {
+
+    // variable declaration
+    var result = make([]*whoisCacheEntry, 0, len(c.entries))
+
+    for _, entry := range c.entries {
+        result = append(result, entry)
+    }
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].key < result[j].key
+    })
+
+    return result
+}
+
+//! Obtain the cached record of an IP address, if there is one
+/*
+ * An exact match of the address wins, else the narrowest CIDR covering it.
+ *
+ * @param     string             IP address
+ * @param     Time               current time
+ *
+ * @return    whoisCacheEntry    cached record, or nil if there is none
+ */
+func (c *whoisCache) lookup(ip string, now time.Time) *whoisCacheEntry {
+
+    // variable declaration
+    var result *whoisCacheEntry = nil
+    var result_bits int = -1
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    if entry, exists := c.entries[ip]; exists && now.Before(entry.expires) {
+        return entry
+    }
+
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return nil
+    }
+
+    for _, entry := range c.entries {
+        if entry.network == nil || !now.Before(entry.expires) ||
+          !entry.network.Contains(parsed) {
+            continue
+        }
+        bits, _ := entry.network.Mask.Size()
+        if bits > result_bits {
+            result = entry
+            result_bits = bits
+        }
+    }
+
+    return result
+}
+
+//! Store the outcome of a whois or RDAP lookup
+/*
+ * @param     string         IP address that was looked up
+ * @param     whoisResult    whois or RDAP result; if the lookup failed, its
+ *                           text holds the error message
+ * @param     bool           whether or not the lookup failed
+ * @param     Time           current time
+ */
+func (c *whoisCache) store(ip string, result whoisResult, failed bool,
+  now time.Time) {
+
+    // variable declaration
+    var keys = make([]string, 0)
+
+    // a record is kept under the allocated CIDRs covering the address,
+    // failed lookups only under the address itself
+    parsed := net.ParseIP(ip)
+    if !failed && parsed != nil {
+        for _, cidr := range strings.Split(result.record.cidr, ",") {
+            _, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+            if err == nil && network.Contains(parsed) {
+                keys = append(keys, network.String())
+            }
+        }
+    }
+    if len(keys) < 1 {
+        keys = append(keys, ip)
+    }
+
+    ttl := c.ttl
+    if failed {
+        ttl = c.negative_ttl
+    }
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    for _, key := range keys {
+        entry := &whoisCacheEntry{key: key, fetched: now,
+          expires: now.Add(ttl), failed: failed, result: result}
+        if strings.Contains(key, "/") {
+            _, entry.network, _ = net.ParseCIDR(key)
+        }
+        c.entries[key] = entry
+    }
+}
+
+//! Forget the expired records
+/*
+ * @param     Time    current time
+ *
+ * @return    int     number of records forgotten
+ */
+func (c *whoisCache) prune(now time.Time) int {
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    pruned := 0
+    for key, entry := range c.entries {
+        if !now.Before(entry.expires) {
+            delete(c.entries, key)
+            pruned++
+        }
+    }
+    return pruned
+}
+
+//! Obtain the whois record of an IP address, from the cache if possible
+/*
+ * @param     Context        context of the lookup
+ * @param     whoisCache     cached records, or nil to always look up
+ * @param     rateLimiter    spaces out the lookups not in the cache
+ * @param     string         IP address
+ * @param     Time           current time
+ *
+ * @return    whoisResult    whois or RDAP record; the whois text may be
+ *                           blank
+ * @return    bool           whether or not it came from the cache
+ * @return    error          error message, if any
+ */
+func obtainWhoisResult(ctx context.Context, cache *whoisCache,
+  limiter *rateLimiter, ip string, now time.Time) (whoisResult, bool,
+  error) {
+
+    if cache != nil {
+        if entry := cache.lookup(ip, now); entry != nil {
+            if entry.failed && len(entry.result.text) > 0 {
+                return whoisResult{}, true, fmt.Errorf("%s",
+                  entry.result.text)
+            }
+            return entry.result, true, nil
+        }
+    }
+
+    // only the lookups that go out to the network count towards the limit
+    if err := limiter.wait(ctx); err != nil {
+        return whoisResult{}, false, err
+    }
+
+    // prefer the RDAP network object, if RDAP is configured, since its
+    // fields need no guesswork
+    if rdapLookupClient != nil {
+        record, url, err := rdapLookupClient.query(ctx, ip)
+        if err == nil {
+            result := whoisResult{text: url, rdap: true,
+              record: convertRdapRecordToWhoisRecord(record)}
+            if cache != nil {
+                cache.store(ip, result, false, now)
+            }
+            return result, false, nil
+        }
+    }
+
+    // else fall back to the whois record
+    response, err := whoisLookupClient.query(ctx, ip)
+    if err != nil {
+
+        // a lookup cut short by the run is not the fault of the server,
+        // so it is retried next time
+        if cache != nil && !lookupCutShort(ctx) {
+            cache.store(ip, whoisResult{text: err.Error()}, true, now)
+        }
+        return whoisResult{}, false, err
+    }
+
+    text := strings.Trim(response.text, " ")
+    result := whoisResult{text: text, record: parseWhoisRecord(text)}
+
+    // a blank record is kept as a failed lookup, so that it is retried
+    // sooner, while a partial one is not kept at all, since the referral
+    // may well answer next time
+    if cache != nil && !response.partial {
+        cache.store(ip, result, len(text) < 1, now)
+    }
+
+    return result, false, nil
+}
+
+//! Read the whois cache into whoisCacheEntries, unless caching is disabled
+/*
+ * @return    error    error message, if any
+ */
+func loadWhoisCache() error {
+
+    if whoisCacheTTL <= 0 {
+        whoisCacheEntries = nil
+        return nil
+    }
+
+    cache, err := readWhoisCache(state_directory + whois_cache,
+      whoisCacheTTL, whoisNegativeTTL)
+    if err != nil {
+        return err
+    }
+
+    whoisCacheEntries = cache
+    return nil
+}
+
+//! Forget the expired records of whoisCacheEntries, then save it
+/*
+ * @return    error    error message, if any
+ */
+func saveWhoisCache() error {
+
+    if whoisCacheEntries == nil {
+        return nil
+    }
+
+    whoisCacheEntries.prune(time.Now())
+    return whoisCacheEntries.write()
+}
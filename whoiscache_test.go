@@ -0,0 +1,210 @@
+//
+// Whois cache tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "io/ioutil"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// Whois record of an allocation, as RIPE gives it.
+const whoisCacheTestRecord = "inetnum:        192.0.2.0 - 192.0.2.255\n" +
+  "netname:        EXAMPLE-NET\n" +
+  "country:        NL\n" +
+  "abuse-mailbox:  abuse@example.net\n"
+
+//! Assemble a whois result from whois text, as a lookup would
+/*
+ * @param     string         whois text
+ *
+ * @return    whoisResult    result, with the parsed record
+ */
+func assembleWhoisCacheTestResult(text string) whoisResult {
+    return whoisResult{text: text, record: parseWhoisRecord(text)}
+}
+
+func TestWhoisCacheLookup(t *testing.T) {
+
+    now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+    cache, err := readWhoisCache(filepath.Join(t.TempDir(), "whois.cache"),
+      24 * time.Hour, time.Hour)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    allocated := assembleWhoisCacheTestResult(whoisCacheTestRecord)
+    if allocated.record.cidr != "192.0.2.0/24" {
+        t.Fatalf("fixture cidr = %q", allocated.record.cidr)
+    }
+    unallocated := assembleWhoisCacheTestResult("netname: NO-CIDR\n")
+    nested := whoisResult{text: "https://rdap.example/ip/203.0.113.1",
+      rdap: true, record: whoisRecord{country: "DE",
+      cidr: "203.0.113.0/24, 203.0.113.0/28"}}
+
+    cache.store("192.0.2.1", allocated, false, now)
+    cache.store("198.51.100.1", unallocated, false, now)
+    cache.store("203.0.113.1", nested, false, now)
+    cache.store("192.0.2.200", whoisResult{text: "connection refused"},
+      true, now)
+
+    tests := []struct {
+        name   string
+        ip     string
+        after  time.Duration
+        want   string
+        failed bool
+    }{
+        {"the address looked up", "192.0.2.1", 0, "192.0.2.0/24", false},
+        {"another address of the allocation", "192.0.2.77", 0,
+          "192.0.2.0/24", false},
+        {"most specific allocation wins", "203.0.113.9", 0,
+          "203.0.113.0/28", false},
+        {"wider allocation", "203.0.113.99", 0, "203.0.113.0/24", false},
+        {"outside every allocation", "192.0.3.1", 0, "", false},
+        {"record without a cidr, by address", "198.51.100.1", 0,
+          "198.51.100.1", false},
+        {"record without a cidr, other address", "198.51.100.2", 0, "",
+          false},
+        {"failed lookup, by address", "192.0.2.200", 0, "192.0.2.200",
+          true},
+        {"failed lookup after its shorter ttl", "192.0.2.200", time.Hour,
+          "192.0.2.0/24", false},
+        {"just before the ttl", "192.0.2.77", 24 * time.Hour - time.Second,
+          "192.0.2.0/24", false},
+        {"stale after the ttl", "192.0.2.77", 24 * time.Hour, "", false},
+        {"not an address", "192.0.2", 0, "", false},
+    }
+
+    for _, test := range tests {
+
+        entry := cache.lookup(test.ip, now.Add(test.after))
+        if entry == nil {
+            if len(test.want) > 0 {
+                t.Errorf("%s: no record, want %s", test.name, test.want)
+            }
+            continue
+        }
+        if entry.key != test.want || entry.failed != test.failed {
+            t.Errorf("%s: record %s, failed = %v, want %s, failed = %v",
+              test.name, entry.key, entry.failed, test.want, test.failed)
+        }
+    }
+
+    // the expired records are the ones pruned
+    pruned := cache.prune(now.Add(2 * time.Hour))
+    if _, exists := cache.entries["192.0.2.200"]; pruned != 1 || exists {
+        t.Errorf("pruned %d records, want the failed lookup only", pruned)
+    }
+    pruned = cache.prune(now.Add(24 * time.Hour))
+    if pruned != 4 || len(cache.entries) != 0 {
+        t.Errorf("pruned %d records, %d left, want all 4", pruned,
+          len(cache.entries))
+    }
+}
+
+func TestObtainWhoisResultCached(t *testing.T) {
+
+    now := time.Now()
+    cache, err := readWhoisCache(filepath.Join(t.TempDir(), "whois.cache"),
+      24 * time.Hour, time.Hour)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    allocated := assembleWhoisCacheTestResult(whoisCacheTestRecord)
+    cache.store("192.0.2.1", allocated, false, now)
+    cache.store("198.51.100.1", whoisResult{text: "connection refused"},
+      true, now)
+
+    // cached answers, good or bad, never go out to the network
+    result, cached, err := obtainWhoisResult(context.Background(), cache,
+      nil, "192.0.2.77", now)
+    if err != nil || !cached || result != allocated {
+        t.Errorf("cached record = %+v, %v, %v, want %+v", result, cached,
+          err, allocated)
+    }
+    _, cached, err = obtainWhoisResult(context.Background(), cache, nil,
+      "198.51.100.1", now)
+    if err == nil || !cached || err.Error() != "connection refused" {
+        t.Errorf("cached failure = %v, %v, want the cached error", cached,
+          err)
+    }
+}
+
+func TestWhoisCacheRoundTrip(t *testing.T) {
+
+    path := filepath.Join(t.TempDir(), "whois.cache")
+    now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+    cache, err := readWhoisCache(path, 24 * time.Hour, time.Hour)
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    cache.store("192.0.2.1",
+      assembleWhoisCacheTestResult(whoisCacheTestRecord), false, now)
+    cache.store("198.51.100.1", assembleWhoisCacheTestResult(
+      "netname: NO-CIDR\n% quoted \"text\"\twith a tab\n"), false, now)
+    cache.store("203.0.113.1", whoisResult{text: "https://rdap.example/",
+      rdap: true, record: whoisRecord{country: "DE", asn: "AS64500",
+      cidr: "203.0.113.0/24", abuse_email: "abuse@example.de"}}, false,
+      now)
+    cache.store("2001:db8::1", whoisResult{text: "i/o timeout"}, true, now)
+
+    err = cache.write()
+    if err != nil {
+        t.Fatalf("unable to write the cache: %v", err)
+    }
+    read, err := readWhoisCache(path, 24 * time.Hour, time.Hour)
+    if err != nil {
+        t.Fatalf("unable to read the cache back: %v", err)
+    }
+
+    if len(read.entries) != len(cache.entries) {
+        t.Fatalf("%d records read back, want %d", len(read.entries),
+          len(cache.entries))
+    }
+    for key, want := range cache.entries {
+        got, exists := read.entries[key]
+        if !exists {
+            t.Errorf("%s not read back", key)
+            continue
+        }
+        if got.failed != want.failed || got.result != want.result ||
+          !got.fetched.Equal(want.fetched) ||
+          !got.expires.Equal(want.expires) ||
+          (got.network == nil) != (want.network == nil) {
+            t.Errorf("%s read back as %+v, want %+v", key, got, want)
+        }
+    }
+
+    // improper lines are an error, rather than silently dropped
+    for _, contents := range []string{
+        "192.0.2.0/24\ttoo few columns\n",
+        "192.0.2.0/24\tyesterday\t2026-01-02T03:04:05Z\tok\t\"\"\n",
+        "192.0.2.0/24\t2026-01-02T03:04:05Z\t2026-01-02T03:04:05Z\tok\t" +
+          "not quoted\n",
+        "192.0.2.0/99\t2026-01-02T03:04:05Z\t2026-01-02T03:04:05Z\tok\t" +
+          "\"\"\n",
+        "192.0.2.0/24\t2026-01-02T03:04:05Z\t2026-01-02T03:04:05Z\trdap\t" +
+          "\"{not json\"\n",
+    } {
+        err = ioutil.WriteFile(path, []byte(contents), 0644)
+        if err != nil {
+            t.Fatalf("unable to write the cache: %v", err)
+        }
+        _, err = readWhoisCache(path, 24 * time.Hour, time.Hour)
+        if err == nil {
+            t.Errorf("improper cache accepted: %q", contents)
+        }
+    }
+}
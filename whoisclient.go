@@ -0,0 +1,292 @@
+//
+// Whois client functions for ASCII-log
+//
+// Lookups are made over port 43 directly, rather than by running the whois
+// binary. A lookup starts at IANA, or at a configured registry, and follows
+// the referrals given along the way:
+//
+// refer:          whois.ripe.net                  (IANA)
+// whois:          whois.ripe.net                  (IANA)
+// ReferralServer: whois://whois.ripe.net          (ARIN)
+//
+// The answer of the last server to respond is the most specific one, and is
+// the one passed back, along with the chain of servers that were asked.
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "context"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Port that whois servers listen on.
+const whoisPort = "43"
+
+// Largest whois answer that is read, in bytes.
+const whoisMaxResponse = 1 << 20
+
+// Queries of the servers that need more than the bare address, by server
+// address. ARIN answers a bare address with a summary of every network
+// that matches, whereas "n + " asks for the details of the network.
+var whoisQueryFormats = map[string] string{
+    "whois.arin.net:43": "n + %s",
+}
+
+//! Answer of a whois lookup
+type whoisResponse struct {
+    text    string
+    servers []string
+    partial bool
+}
+
+//! Port 43 whois client
+type whoisClient struct {
+    server        string
+    timeout       time.Duration
+    max_referrals int
+    concurrency   int
+    mutex         sync.Mutex
+    slots         map[string] chan struct{}
+}
+
+//! Assemble a whois client
+/*
+ * @param     string         first server to ask; e.g. whois.iana.org
+ * @param     Duration       timeout of every server
+ * @param     int            most referrals to follow
+ * @param     int            most lookups in flight per server
+ *
+ * @return    whoisClient    whois client
+ */
+func newWhoisClient(server string, timeout time.Duration,
+  max_referrals int, concurrency int) *whoisClient {
+
+    if concurrency < 1 {
+        concurrency = 1
+    }
+
+    return &whoisClient{server: server, timeout: timeout,
+      max_referrals: max_referrals, concurrency: concurrency,
+      slots: make(map[string] chan struct{})}
+}
+
+//! Obtain the lookup slots of a server, which limit how many lookups it
+//! is sent at once
+/*
+ * @param     string    server address
+ *
+ * @return    chan      lookup slots of the server
+ */
+func (c *whoisClient) serverSlots(server string) chan struct{} {
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    slots, exists := c.slots[server]
+    if !exists {
+        slots = make(chan struct{}, c.concurrency)
+        c.slots[server] = slots
+    }
+    return slots
+}
+
+//! Add the whois port to a server address, if it lacks one
+/*
+ * @param     string    server; e.g. whois.ripe.net or 127.0.0.1:4343
+ *
+ * @return    string    server with a port
+ */
+func convertWhoisServerToAddress(server string) string {
+
+    if _, _, err := net.SplitHostPort(server); err == nil {
+        return server
+    }
+    return net.JoinHostPort(strings.Trim(server, "[]"), whoisPort)
+}
+
+//! Obtain the server an answer refers the lookup onto, if any
+/*
+ * @param     string    whois answer
+ *
+ * @return    string    server address, or blank if there is no referral
+ */
+func obtainWhoisReferral(text string) string {
+
+    referral := obtainWhoisValue(text, []string{"refer", "whois",
+      "referralserver"})
+
+    // rwhois and other protocols cannot be followed
+    lower := strings.ToLower(referral)
+    if strings.Contains(lower, "://") {
+        if !strings.HasPrefix(lower, "whois://") {
+            return ""
+        }
+        referral = referral[len("whois://"):]
+    }
+
+    return strings.TrimSuffix(strings.TrimSpace(referral), "/")
+}
+
+//! Obtain the query to send a whois server for an IP address
+/*
+ * @param     string    server address
+ * @param     string    IP address
+ *
+ * @return    string    query; e.g. the address itself
+ */
+func obtainWhoisQuery(server string, ip string) string {
+
+    format, exists := whoisQueryFormats[server]
+    if !exists {
+        return ip
+    }
+    return fmt.Sprintf(format, ip)
+}
+
+//! Send a query to a single whois server
+/*
+ * @param     Context    context of the lookup
+ * @param     string     server address
+ * @param     string     query; e.g. an IP address
+ *
+ * @return    string     answer of the server
+ * @return    error      error message, if any
+ */
+func (c *whoisClient) ask(ctx context.Context, server string,
+  query string) (string, error) {
+
+    // wait for a free slot, unless the lookup is cancelled meanwhile
+    slots := c.serverSlots(server)
+    select {
+    case slots <- struct{}{}:
+    case <-ctx.Done():
+        return "", ctx.Err()
+    }
+    defer func() { <-slots }()
+
+    dialer := net.Dialer{Timeout: c.timeout}
+    conn, err := dialer.DialContext(ctx, "tcp", server)
+    if err != nil {
+        return "", err
+    }
+    defer conn.Close()
+
+    // the deadline covers the whole exchange, so a slow server cannot
+    // stall a lookup for longer than the timeout, or the lookup itself
+    // has left
+    deadline, has_deadline := ctx.Deadline()
+    if c.timeout > 0 && (!has_deadline ||
+      time.Now().Add(c.timeout).Before(deadline)) {
+        deadline, has_deadline = time.Now().Add(c.timeout), true
+    }
+    if has_deadline {
+        conn.SetDeadline(deadline)
+    }
+
+    _, err = conn.Write([]byte(query + "\r\n"))
+    if err != nil {
+        return "", err
+    }
+
+    // a misbehaving server is cut off, rather than read without end
+    answer, err := ioutil.ReadAll(io.LimitReader(conn, whoisMaxResponse))
+    if err != nil {
+        return "", err
+    }
+
+    return strings.Replace(string(answer), "\r\n", "\n", -1), nil
+}
+
+//! Look up an IP address, following referrals
+/*
+ * @param     Context          context of the lookup
+ * @param     string           IP address
+ *
+ * @return    whoisResponse    answer of the last server, and every server
+ *                             asked
+ * @return    error            error message, if any
+ */
+func (c *whoisClient) query(ctx context.Context, ip string) (whoisResponse,
+  error) {
+
+    // variable declaration
+    var response whoisResponse
+
+    server := convertWhoisServerToAddress(c.server)
+    for {
+        text, err := c.ask(ctx, server, obtainWhoisQuery(server, ip))
+
+        // a referral that fails still leaves the answer of the server
+        // before it, which is less specific, yet still useful
+        if err != nil {
+            if len(response.servers) > 0 && !lookupCutShort(ctx) {
+                response.servers = append(response.servers, server +
+                  " (failed)")
+                response.partial = true
+                return response, nil
+            }
+            return response, fmt.Errorf("whoisClient.query() --> %s: %s",
+              server, err.Error())
+        }
+
+        response.text = text
+        response.servers = append(response.servers, server)
+
+        referral := obtainWhoisReferral(text)
+        if len(referral) < 1 || len(response.servers) > c.max_referrals {
+            return response, nil
+        }
+
+        // servers sometimes refer to themselves, or each other
+        referral = convertWhoisServerToAddress(referral)
+        if isStringInArray(referral, response.servers) {
+            return response, nil
+        }
+        server = referral
+    }
+}
+
+//! Assemble the whois and RDAP clients, load the GeoIP databases and read
+//! the whois cache, as configured by the flags
+/*
+ * @return    error    error message, if any
+ */
+func setupLookups() error {
+
+    if whoisMaxReferrals < 0 {
+        return fmt.Errorf("setupLookups() --> the most referrals " +
+          "to follow cannot be negative")
+    }
+
+    whoisLookupClient = newWhoisClient(whoisServer, whoisTimeout,
+      whoisMaxReferrals, whoisConcurrency)
+
+    // RDAP is only used once a bootstrap file or server is given
+    rdapLookupClient = nil
+    if len(rdapBootstrapFiles) > 0 || len(rdapServer) > 0 {
+        services, err := readRdapBootstrap(rdapBootstrapFiles)
+        if err != nil {
+            return err
+        }
+        rdapLookupClient = newRdapClient(services, rdapServer, rdapTimeout)
+    }
+
+    if err := loadGeoipDatabases(); err != nil {
+        return err
+    }
+
+    return loadWhoisCache()
+}
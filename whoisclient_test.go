@@ -0,0 +1,297 @@
+//
+// Whois client tests for ASCII-log
+//
+
+//
+// Package
+//
+package main
+
+//
+// Imports
+//
+import (
+    "bufio"
+    "context"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+//! Stand-in whois server, listening on a local port
+type fakeWhoisServer struct {
+    address string
+    mutex   sync.Mutex
+    answer  string
+    queries []string
+}
+
+//! Start a stand-in whois server, stopped once the test is over
+/*
+ * @param     T*                 test state
+ *
+ * @return    fakeWhoisServer    server, answering blank until told to
+ */
+func startFakeWhoisServer(t *testing.T) *fakeWhoisServer {
+
+    t.Helper()
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("unable to listen: %v", err)
+    }
+    t.Cleanup(func() { listener.Close() })
+
+    server := &fakeWhoisServer{address: listener.Addr().String()}
+
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+
+            // a single query per connection, answered and hung up on
+            query, _ := bufio.NewReader(conn).ReadString('\n')
+            server.mutex.Lock()
+            server.queries = append(server.queries, query)
+            answer := server.answer
+            server.mutex.Unlock()
+
+            conn.Write([]byte(strings.Replace(answer, "\n", "\r\n", -1)))
+            conn.Close()
+        }
+    }()
+
+    return server
+}
+
+//! Set the answer of the stand-in whois server
+/*
+ * @param     string    whois answer
+ */
+func (s *fakeWhoisServer) setAnswer(answer string) {
+
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.answer = answer
+}
+
+//! Obtain the address of a local port nothing listens on
+/*
+ * @param     T*        test state
+ *
+ * @return    string    address; e.g. 127.0.0.1:40123
+ */
+func obtainClosedAddress(t *testing.T) string {
+
+    t.Helper()
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("unable to listen: %v", err)
+    }
+    address := listener.Addr().String()
+    listener.Close()
+    return address
+}
+
+func TestObtainWhoisReferral(t *testing.T) {
+
+    tests := []struct {
+        text string
+        want string
+    }{
+        {"refer:        whois.ripe.net\n", "whois.ripe.net"},
+        {"whois:        whois.apnic.net\n", "whois.apnic.net"},
+        {"ReferralServer:  whois://whois.lacnic.net/\n", "whois.lacnic.net"},
+        {"ReferralServer:  rwhois://rwhois.example.net:4321\n", ""},
+        {"inetnum: 192.0.2.0 - 192.0.2.255\n", ""},
+    }
+
+    for _, test := range tests {
+        got := obtainWhoisReferral(test.text)
+        if got != test.want {
+            t.Errorf("obtainWhoisReferral(%q) = %q, want %q", test.text, got,
+              test.want)
+        }
+    }
+}
+
+func TestObtainWhoisQuery(t *testing.T) {
+
+    tests := []struct {
+        server string
+        ip     string
+        want   string
+    }{
+        {"whois.iana.org:43", "192.0.2.1", "192.0.2.1"},
+        {"whois.arin.net:43", "192.0.2.1", "n + 192.0.2.1"},
+        {"whois.arin.net:43", "2001:db8::1", "n + 2001:db8::1"},
+        {"whois.ripe.net:43", "2001:db8::1", "2001:db8::1"},
+    }
+
+    for _, test := range tests {
+        got := obtainWhoisQuery(test.server, test.ip)
+        if got != test.want {
+            t.Errorf("obtainWhoisQuery(%q, %q) = %q, want %q", test.server,
+              test.ip, got, test.want)
+        }
+    }
+}
+
+func TestWhoisClientQuery(t *testing.T) {
+
+    iana := startFakeWhoisServer(t)
+    arin := startFakeWhoisServer(t)
+    ripe := startFakeWhoisServer(t)
+    closed := obtainClosedAddress(t)
+
+    // the stand-in for ARIN is sent its queries as ARIN is
+    whoisQueryFormats[arin.address] = whoisQueryFormats["whois.arin.net:43"]
+    defer delete(whoisQueryFormats, arin.address)
+
+    tests := []struct {
+        name          string
+        iana          string
+        arin          string
+        ripe          string
+        max_referrals int
+        servers       []string
+        text          string
+        partial       bool
+    }{
+        {
+            name:          "referrals followed to the last server",
+            iana:          "refer: " + arin.address + "\n",
+            arin:          "ReferralServer: whois://" + ripe.address + "\n",
+            ripe:          "inetnum: 192.0.2.0 - 192.0.2.255\n",
+            max_referrals: 5,
+            servers:       []string{iana.address, arin.address,
+              ripe.address},
+            text:          "inetnum: 192.0.2.0 - 192.0.2.255\n",
+        },
+        {
+            name:          "referrals stop at the limit",
+            iana:          "refer: " + arin.address + "\n",
+            arin:          "ReferralServer: whois://" + ripe.address + "\n",
+            ripe:          "inetnum: 192.0.2.0 - 192.0.2.255\n",
+            max_referrals: 1,
+            servers:       []string{iana.address, arin.address},
+            text:          "ReferralServer: whois://" + ripe.address + "\n",
+        },
+        {
+            name:          "servers referring to each other",
+            iana:          "refer: " + arin.address + "\n",
+            arin:          "refer: " + iana.address + "\n",
+            max_referrals: 5,
+            servers:       []string{iana.address, arin.address},
+            text:          "refer: " + iana.address + "\n",
+        },
+        {
+            name:          "failed referral keeps the earlier answer",
+            iana:          "refer: " + closed + "\n",
+            max_referrals: 5,
+            servers:       []string{iana.address, closed + " (failed)"},
+            text:          "refer: " + closed + "\n",
+            partial:       true,
+        },
+        {
+            name:          "rwhois referral not followed",
+            iana:          "ReferralServer: rwhois://" + arin.address + "\n",
+            max_referrals: 5,
+            servers:       []string{iana.address},
+            text:          "ReferralServer: rwhois://" + arin.address + "\n",
+        },
+    }
+
+    for _, test := range tests {
+
+        iana.setAnswer(test.iana)
+        arin.setAnswer(test.arin)
+        ripe.setAnswer(test.ripe)
+
+        client := newWhoisClient(iana.address, time.Second,
+          test.max_referrals, 1)
+        response, err := client.query(context.Background(), "192.0.2.1")
+        if err != nil {
+            t.Errorf("%s: unexpected error: %v", test.name, err)
+            continue
+        }
+
+        if strings.Join(response.servers, " ") !=
+          strings.Join(test.servers, " ") {
+            t.Errorf("%s: servers = %v, want %v", test.name,
+              response.servers, test.servers)
+        }
+        if response.text != test.text {
+            t.Errorf("%s: text = %q, want %q", test.name, response.text,
+              test.text)
+        }
+        if response.partial != test.partial {
+            t.Errorf("%s: partial = %v, want %v", test.name,
+              response.partial, test.partial)
+        }
+    }
+
+    // every server is sent a single line, ARIN asking for the network
+    for _, check := range []struct {
+        server *fakeWhoisServer
+        query  string
+    }{
+        {iana, "192.0.2.1\r\n"},
+        {arin, "n + 192.0.2.1\r\n"},
+        {ripe, "192.0.2.1\r\n"},
+    } {
+        check.server.mutex.Lock()
+        if len(check.server.queries) < 1 {
+            t.Errorf("%s never asked", check.server.address)
+        }
+        for _, query := range check.server.queries {
+            if query != check.query {
+                t.Errorf("%s asked %q, want %q", check.server.address,
+                  query, check.query)
+            }
+        }
+        check.server.mutex.Unlock()
+    }
+}
+
+func TestWhoisClientQueryUnreachable(t *testing.T) {
+
+    client := newWhoisClient(obtainClosedAddress(t), time.Second, 5, 1)
+    _, err := client.query(context.Background(), "192.0.2.1")
+    if err == nil {
+        t.Errorf("expected an error when the first server is unreachable")
+    }
+}
+
+func TestWhoisClientQueryTimeout(t *testing.T) {
+
+    // a server that accepts, yet never answers
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("unable to listen: %v", err)
+    }
+    defer listener.Close()
+    go func() {
+        conn, err := listener.Accept()
+        if err == nil {
+            time.Sleep(2 * time.Second)
+            conn.Close()
+        }
+    }()
+
+    client := newWhoisClient(listener.Addr().String(),
+      100 * time.Millisecond, 5, 1)
+    start := time.Now()
+    _, err = client.query(context.Background(), "192.0.2.1")
+    if err == nil {
+        t.Errorf("expected a silent server to time out")
+    }
+    if time.Since(start) > 2 * time.Second {
+        t.Errorf("timeout took %v", time.Since(start))
+    }
+}